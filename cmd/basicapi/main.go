@@ -4,17 +4,32 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/skonto/test-otel/pkg/boundcache"
+	"github.com/skonto/test-otel/pkg/cardinality"
+	"github.com/skonto/test-otel/pkg/ctxlabels"
+	"github.com/skonto/test-otel/pkg/httpmetrics"
+	"github.com/skonto/test-otel/pkg/promserver"
+	"github.com/skonto/test-otel/pkg/spanmetrics"
+	"github.com/skonto/test-otel/pkg/tracing"
+	"github.com/skonto/test-otel/pkg/views"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/metric/prometheus"
 	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
 	"go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/semconv"
 	"go.opentelemetry.io/otel/unit"
+	"google.golang.org/grpc"
 )
 
 var (
@@ -22,24 +37,57 @@ var (
 	requestsB       metric.BoundInt64Counter
 	requestLatency  metric.Float64ValueRecorder
 	requestLatencyB metric.BoundFloat64ValueRecorder
+	// Bound per label set on first use and unbound when idle
+	requestsC       *boundcache.Int64Counter
+	requestLatencyC *boundcache.Float64ValueRecorder
+	// Labeled with the labels the requests carry in their context
+	requestsCtx       ctxlabels.Int64Counter
+	requestLatencyCtx ctxlabels.Float64ValueRecorder
 )
 
 func initMeter() {
-	exporter, err := prometheus.InstallNewPipeline(prometheus.Config{
-		// View API is not ready yet, so we have to set up global boundaries here
-		// https://github.com/open-telemetry/opentelemetry-go/issues/689
-		// https://github.com/open-telemetry/opentelemetry-go/issues/689#issuecomment-622137029
-		DefaultHistogramBoundaries: []float64{1, 5, 10, 50, 100},
-	}, basic.WithCollectPeriod(1*time.Second)) // How fast metrics exported from Prometheus
+	// View API is not ready yet, latencies use the millisecond buckets of
+	// the selector and sizes get theirs from a view
+	// https://github.com/open-telemetry/opentelemetry-go/issues/689
+	selector, err := views.New(simple.NewWithHistogramDistribution([]float64{1, 5, 10, 50, 100}),
+		views.View{Name: "http.server.response_size", Boundaries: []float64{100, 1000, 10000, 100000}},
+	)
+	if err != nil {
+		log.Panicf("failed to create views %v", err)
+	}
+	// The path label comes from requests, cap the series it can create
+	limiter, err := cardinality.New(
+		processor.New(selector, export.CumulativeExportKindSelector(), processor.WithMemory(true)),
+		cardinality.WithLimit(100),
+		cardinality.WithMeterProvider(otel.GetMeterProvider()),
+	)
+	if err != nil {
+		log.Panicf("failed to create cardinality limiter %v", err)
+	}
+	cont := basic.New(limiter, basic.WithCollectPeriod(1*time.Second)) // How fast metrics exported from Prometheus
+	exporter, err := prometheus.NewExporter(prometheus.Config{}, cont)
 	if err != nil {
 		log.Panicf("failed to initialize prometheus exporter %v", err)
 	}
-	http.HandleFunc("/metrics", exporter.ServeHTTP)
+	otel.SetMeterProvider(exporter.MeterProvider())
+	srv, err := promserver.New(exporter, append([]promserver.Option{
+		promserver.WithAddress(":9090"),
+		promserver.WithPath("/metrics"),
+		promserver.WithMeterProvider(otel.GetMeterProvider()),
+	}, promserver.OptionsFromEnv()...)...)
+	if err != nil {
+		log.Panicf("failed to create prometheus server %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Panicf("failed to start prometheus server %v", err)
+	}
 	go func() {
-		_ = http.ListenAndServe(":9090", nil)
+		if err := <-srv.Err(); err != nil {
+			log.Fatal(err)
+		}
 	}()
 
-	fmt.Println("Prometheus server running on :9090")
+	fmt.Printf("Prometheus server running on %s\n", srv.URL())
 }
 
 func initSyncIntruments() {
@@ -66,25 +114,79 @@ func initSyncIntruments() {
 	}
 
 	requestLatencyB = requestLatency.Bind(otherLabels...)
+
+	requestsC = boundcache.NewInt64Counter(requests)
+	requestLatencyC = boundcache.NewFloat64ValueRecorder(requestLatency)
+	requestsCtx = ctxlabels.NewInt64Counter(requestsC)
+	requestLatencyCtx = ctxlabels.NewFloat64ValueRecorder(requestLatencyC)
+}
+
+// oltpEndpointEnv is the collector spans are exported to, spans are not
+// exported if unset.
+const oltpEndpointEnv = "OLTP_ENDPOINT"
+
+// initTracer installs a tracer provider deriving RED metrics from the
+// spans and exporting them in batches over OTLP/gRPC, it returns the
+// provider to shut down on exit.
+func initTracer() *tracing.TracerProvider {
+	red, err := spanmetrics.New(spanmetrics.WithAttributeLabels(semconv.HTTPMethodKey))
+	if err != nil {
+		log.Panicf("failed to create span metrics %v", err)
+	}
+	opts := []tracing.Option{
+		tracing.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String("basicapi"))),
+		// The metrics cover every request, the traces only the sampled ones
+		tracing.WithAllSpansProcessor(red),
+	}
+	if endpoint := os.Getenv(oltpEndpointEnv); endpoint != "" {
+		conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+		if err != nil {
+			log.Panicf("failed to dial collector %v", err)
+		}
+		bsp := tracing.NewBatchSpanProcessor(tracing.NewOTLPExporter(conn),
+			tracing.WithMeterProvider(otel.GetMeterProvider()))
+		opts = append(opts, tracing.WithSpanProcessor(bsp))
+		fmt.Printf("Exporting spans to %s\n", endpoint)
+	}
+	tp := tracing.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp
 }
 
 func main() {
 	initMeter()
 	initSyncIntruments()
+	tp := initTracer()
 
+	// Callers pick their tenant with the baggage header, eg. baggage: tenant=acme
+	m, err := httpmetrics.New(httpmetrics.WithBaggageLabels("tenant"))
+	if err != nil {
+		log.Panicf("failed to create http metrics middleware %v", err)
+	}
+	fmt.Printf("API server running on %s, metrics on :9090\n", apiAddress)
+	srv := &http.Server{
+		Addr:              apiAddress,
+		Handler:           newAPI(m, apiHosts()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
 	go func() {
-		// TODO make it more realistic using a server
-		for {
-			count := int64(rand.Float64() * 100)
-			latency := rand.Float64() * 10
-			recordMetrics(count, latency)
-			recordMetricsB(count, latency)
-			time.Sleep(time.Millisecond * 100)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
 	}()
 
-	fmt.Printf("Example finished updating, please visit :9090\n")
-	select {}
+	// Flush the last spans on exit
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut the API server down %v", err)
+	}
+	if err := tp.Shutdown(ctx); err != nil {
+		log.Printf("failed to flush spans %v", err)
+	}
 }
 
 func recordMetrics(count int64, latency float64) {
@@ -98,3 +200,14 @@ func recordMetricsB(count int64, latency float64) {
 	requestsB.Add(context.TODO(), count)
 	requestLatencyB.Record(context.TODO(), latency)
 }
+
+func recordMetricsC(count int64, latency float64, labels ...label.KeyValue) {
+	requestsC.Add(context.TODO(), count, labels...)
+	requestLatencyC.Record(context.TODO(), latency, labels...)
+}
+
+// recordMetricsCtx records with the labels of ctx, set by withLabels.
+func recordMetricsCtx(ctx context.Context, count int64, latency float64) {
+	requestsCtx.Add(ctx, count)
+	requestLatencyCtx.Record(ctx, latency)
+}
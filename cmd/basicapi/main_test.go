@@ -1,24 +1,53 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
 )
 
+var setupOnce sync.Once
+
+// setup records through the SDK, without the Prometheus server, so that
+// the benchmarks measure the cost of recording rather than of the no-op
+// global MeterProvider.
+func setup() {
+	setupOnce.Do(func() {
+		cont := basic.New(processor.New(simple.NewWithInexpensiveDistribution(), export.CumulativeExportKindSelector()))
+		otel.SetMeterProvider(cont.MeterProvider())
+		initSyncIntruments()
+	})
+}
+
 func BenchmarkMetricsRecording(b *testing.B) {
-	initSyncIntruments()
+	setup()
 	cases := []struct {
 		name string
 	}{
 		{"binding"},
 		{"nobinding"},
+		{"cached"},
 	}
 
+	cachedLabels := []label.KeyValue{label.String("path", "/api/list/foo"), label.String("host", "localhost")}
 	for _, c := range cases {
 		b.Run(c.name, func(b *testing.B) {
 			for n := 0; n < b.N; n++ {
-				if c.name == "binding" {
+				switch c.name {
+				case "binding":
 					recordMetricsB(int64(100), float64(100))
-				} else {
+				case "cached":
+					recordMetricsC(int64(100), float64(100), cachedLabels...)
+				default:
 					recordMetrics(int64(100), float64(100))
 				}
 			}
@@ -27,9 +56,12 @@ func BenchmarkMetricsRecording(b *testing.B) {
 		b.Run(c.name+"-parallel", func(b *testing.B) {
 			b.RunParallel(func(pb *testing.PB) {
 				for pb.Next() {
-					if c.name == "binding" {
+					switch c.name {
+					case "binding":
 						recordMetricsB(int64(100), float64(100))
-					} else {
+					case "cached":
+						recordMetricsC(int64(100), float64(100), cachedLabels...)
+					default:
 						recordMetrics(int64(100), float64(100))
 					}
 				}
@@ -37,3 +69,78 @@ func BenchmarkMetricsRecording(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkCardinality compares unbound and cached recording as the number
+// of label sets grows. Up to the cache size, 1000 by default, cached
+// recording does not allocate, past it most label sets record unbound
+// after missing the cache.
+func BenchmarkCardinality(b *testing.B) {
+	setup()
+	ctx := context.Background()
+	for _, cardinality := range []int{1, 10, 100, 1000, 10000} {
+		labelSets := make([][]label.KeyValue, cardinality)
+		for i := range labelSets {
+			labelSets[i] = []label.KeyValue{label.String("path", fmt.Sprintf("/api/list/%d", i)), label.String("host", "localhost")}
+		}
+		b.Run(fmt.Sprintf("nobinding-%d", cardinality), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				labels := labelSets[n%cardinality]
+				requests.Add(ctx, 100, labels...)
+				requestLatency.Record(ctx, 100, labels...)
+			}
+		})
+		b.Run(fmt.Sprintf("cached-%d", cardinality), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				recordMetricsC(100, 100, labelSets[n%cardinality]...)
+			}
+		})
+	}
+}
+
+// BenchmarkCollection measures a collection of a pipeline like basicapi's,
+// without the Prometheus exporter, as the number of label sets grows. In
+// the active case every label set is recorded between collections, which
+// is not timed, idle label sets are only kept by the processor memory.
+func BenchmarkCollection(b *testing.B) {
+	ctx := context.Background()
+	for _, cardinality := range []int{10, 100, 1000, 10000} {
+		for _, active := range []bool{true, false} {
+			name := fmt.Sprintf("idle-%d", cardinality)
+			if active {
+				name = fmt.Sprintf("active-%d", cardinality)
+			}
+			b.Run(name, func(b *testing.B) {
+				cont := basic.New(processor.New(simple.NewWithHistogramDistribution([]float64{1, 5, 10, 50, 100}),
+					export.CumulativeExportKindSelector(), processor.WithMemory(true)), basic.WithCollectPeriod(0))
+				meter := metric.Must(cont.MeterProvider().Meter("bench"))
+				counter := meter.NewInt64Counter("request.count")
+				latency := meter.NewFloat64ValueRecorder("request.latency")
+				labelSets := make([][]label.KeyValue, cardinality)
+				for i := range labelSets {
+					labelSets[i] = []label.KeyValue{label.String("path", fmt.Sprintf("/api/list/%d", i)), label.String("host", "localhost")}
+				}
+				record := func() {
+					for _, labels := range labelSets {
+						counter.Add(ctx, 1, labels...)
+						latency.Record(ctx, 10, labels...)
+					}
+				}
+				record()
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if active {
+						b.StopTimer()
+						record()
+						b.StartTimer()
+					}
+					if err := cont.Collect(ctx); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
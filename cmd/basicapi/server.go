@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/ctxlabels"
+	"github.com/skonto/test-otel/pkg/httpmetrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const apiAddress = ":8080"
+
+// apiHostsEnv lists, comma separated, the hosts the backend metrics are
+// labeled with, localhost if unset. The Host header is set by callers, the
+// other hosts are labeled "other" so that they do not create series. Hosts
+// are compared case insensitively.
+const apiHostsEnv = "API_HOSTS"
+
+// otherHost labels the requests to hosts that are not allowed.
+const otherHost = "other"
+
+// apiHosts returns the allowed hosts.
+func apiHosts() map[string]bool {
+	hosts := map[string]bool{}
+	for _, h := range strings.Split(os.Getenv(apiHostsEnv), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts[h] = true
+		}
+	}
+	if len(hosts) == 0 {
+		hosts["localhost"] = true
+	}
+	return hosts
+}
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// newAPI returns the handler of the API, every route is instrumented by
+// the middleware. The backend metrics are labeled with hosts, the others
+// are labeled "other".
+func newAPI(m *httpmetrics.Middleware, hosts map[string]bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/list/", m.HandleFunc("/api/list/{name}", withLabels("/api/list/{name}", hosts, listHandler)))
+	mux.Handle("/api/items/", m.HandleFunc("/api/items/{id}", withLabels("/api/items/{id}", hosts, itemHandler)))
+	mux.Handle("/healthz", m.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// Unknown paths share a route so that they do not create series
+	mux.Handle("/", m.Handle("unmatched", http.NotFoundHandler()))
+	return mux
+}
+
+// withLabels labels the measurements recorded with the request context
+// with the route template, rather than the path which has one value per
+// item, and the request host if it is one of hosts.
+func withLabels(route string, hosts map[string]bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		host = strings.ToLower(host)
+		if !hosts[host] {
+			host = otherHost
+		}
+		ctx := ctxlabels.ContextWithLabels(r.Context(), label.String("path", route), label.String("host", host))
+		h(w, r.WithContext(ctx))
+	}
+}
+
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/list/")
+	items := make([]item, 1+rand.Intn(10))
+	for i := range items {
+		items[i] = item{ID: i, Name: name + "-" + strconv.Itoa(i)}
+	}
+	backend(r)
+	writeJSON(w, items)
+}
+
+func itemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/items/"))
+	if err != nil || id < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	backend(r)
+	writeJSON(w, item{ID: id, Name: "item-" + strconv.Itoa(id)})
+}
+
+// backend simulates a call to a backend, traced as a child of the request
+// span, and records its latency labeled with the labels of the request
+// context.
+func backend(r *http.Request) {
+	_, span := otel.Tracer("basicapi").Start(r.Context(), "backend", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	latency := rand.Float64() * 10
+	time.Sleep(time.Duration(latency * float64(time.Millisecond)))
+	recordMetricsCtx(r.Context(), 1, latency)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
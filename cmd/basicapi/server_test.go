@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/httpmetrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// TestAPILabels checks that the backend metrics are labeled with the route
+// template and the allowed hosts, other hosts are labeled "other". The
+// instruments record to a pipeline of the test, the benchmarks set up
+// their own.
+func TestAPILabels(t *testing.T) {
+	proc := processor.New(simple.NewWithInexpensiveDistribution(), export.CumulativeExportKindSelector())
+	cont := basic.New(proc, basic.WithCollectPeriod(0))
+	otel.SetMeterProvider(cont.MeterProvider())
+	initSyncIntruments()
+
+	m, err := httpmetrics.New(httpmetrics.WithMeterProvider(cont.MeterProvider()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newAPI(m, map[string]bool{"localhost": true, "api.example.com": true})
+	for _, c := range []struct {
+		host string
+		path string
+	}{
+		{"localhost", "/api/list/foo"},
+		{"localhost:8080", "/api/items/1"},
+		{"LOCALHOST", "/api/items/2"},
+		{"evil.example.com", "/api/items/3"},
+		{"api.example.com:8080", "/api/list/bar"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		r.Host = c.host
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s%s: got status %d", c.host, c.path, w.Code)
+		}
+	}
+
+	if err := cont.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	err = proc.CheckpointSet().ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		if r.Descriptor().Name() != "request.count" {
+			return nil
+		}
+		sum, err := r.Aggregation().(aggregation.Sum).Sum()
+		got[r.Labels().Encoded(label.DefaultEncoder())] = sum.CoerceToFloat64(r.Descriptor().NumberKind())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		"host=localhost,path=/api/list/{name}":       1,
+		"host=localhost,path=/api/items/{id}":        2,
+		"host=other,path=/api/items/{id}":            1,
+		"host=api.example.com,path=/api/list/{name}": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestAPIHosts(t *testing.T) {
+	for _, c := range []struct {
+		env  string
+		want []string
+	}{
+		{"", []string{"localhost"}},
+		{"API.example.com, localhost ,", []string{"api.example.com", "localhost"}},
+	} {
+		t.Run(c.env, func(t *testing.T) {
+			defer os.Unsetenv(apiHostsEnv)
+			os.Setenv(apiHostsEnv, c.env)
+			got := apiHosts()
+			if len(got) != len(c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+			for _, h := range c.want {
+				if !got[h] {
+					t.Errorf("got %v, want %v", got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// benchdiff compares two runs of go test -bench and flags the regressions:
+//
+//	go test -run xxx -bench . -count 5 ./... > old.txt
+//	# change the SDK version or the options
+//	go test -run xxx -bench . -count 5 ./... > new.txt
+//	go run ./cmd/benchdiff old.txt new.txt
+//
+// The values of the runs of a benchmark are averaged, benchmarks are told
+// apart by package as found in the pkg: lines go test prints. A value is a
+// regression when it is worse than the old one by more than the threshold,
+// 10% by default. Lower values are better except for rates, units ending in
+// "/s". benchdiff exits with status 1 when there are regressions.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Optional regression threshold in percent, 10 by default
+const thresholdEnv = "BENCHDIFF_THRESHOLD"
+
+// key identifies a value of a benchmark.
+type key struct {
+	pkg  string
+	name string
+	unit string
+}
+
+// benchmark returns the name of the benchmark qualified by the last
+// element of its package, eg. "promserver.BenchmarkRender/10".
+func (k key) benchmark() string {
+	if k.pkg == "" {
+		return k.name
+	}
+	return path.Base(k.pkg) + "." + k.name
+}
+
+// results are the mean values of the benchmarks of a run.
+type results map[key]float64
+
+// parse reads the output of go test -bench, other lines are ignored. The
+// GOMAXPROCS suffix of the names is dropped so that runs on different
+// machines compare.
+func parse(r io.Reader) (results, error) {
+	res, err := parseRaw(r)
+	if err != nil {
+		return nil, err
+	}
+	suffix := procsSuffix(res)
+	if suffix == "" {
+		return res, nil
+	}
+	trimmed := results{}
+	for k, v := range res {
+		trimmed[key{pkg: k.pkg, name: strings.TrimSuffix(k.name, suffix), unit: k.unit}] = v
+	}
+	return trimmed, nil
+}
+
+// parseRaw returns the mean values by package and benchmark name as
+// printed.
+func parseRaw(r io.Reader) (results, error) {
+	sums := map[key]float64{}
+	counts := map[key]int{}
+	pkg := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "pkg:" {
+			pkg = fields[1]
+			continue
+		}
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		name := fields[0]
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("benchmark %s: invalid value %q", fields[0], fields[i])
+			}
+			k := key{pkg: pkg, name: name, unit: fields[i+1]}
+			sums[k] += v
+			counts[k]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	res := results{}
+	for k, sum := range sums {
+		res[k] = sum / float64(counts[k])
+	}
+	return res, nil
+}
+
+// procsSuffix returns the -N suffix go test adds to the names when
+// GOMAXPROCS is not 1, the numeric suffix all the names share. Names of
+// sub-benchmarks can end with numbers too, eg. "cached-10".
+func procsSuffix(res results) string {
+	suffix := ""
+	for k := range res {
+		i := strings.LastIndex(k.name, "-")
+		if i < 0 {
+			return ""
+		}
+		if _, err := strconv.Atoi(k.name[i+1:]); err != nil {
+			return ""
+		}
+		if suffix == "" {
+			suffix = k.name[i:]
+		} else if k.name[i:] != suffix {
+			return ""
+		}
+	}
+	return suffix
+}
+
+// change is the comparison of a value in two runs.
+type change struct {
+	key
+	before, after float64
+	// delta is the relative change of the value.
+	delta      float64
+	regression bool
+}
+
+// compare returns the changes of the values found in both runs, sorted by
+// package, name and unit.
+func compare(before, after results, threshold float64) []change {
+	var changes []change
+	for k, b := range before {
+		a, ok := after[k]
+		if !ok {
+			continue
+		}
+		c := change{key: k, before: b, after: a}
+		switch {
+		case a == b:
+		case b == 0:
+			c.delta = math.Inf(1)
+		default:
+			c.delta = (a - b) / b
+		}
+		worse := c.delta
+		if strings.HasSuffix(k.unit, "/s") {
+			worse = -worse
+		}
+		c.regression = worse > threshold
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].pkg != changes[j].pkg {
+			return changes[i].pkg < changes[j].pkg
+		}
+		if changes[i].name != changes[j].name {
+			return changes[i].name < changes[j].name
+		}
+		return changes[i].unit < changes[j].unit
+	})
+	return changes
+}
+
+// missing returns the names of the benchmarks of a run that are not in
+// the other.
+func missing(a, b results) []string {
+	names := map[string]bool{}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			names[k.benchmark()] = true
+		}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// printChanges writes the changes as a table and returns the number of
+// regressions.
+func printChanges(w io.Writer, changes []change) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\tunit\told\tnew\tdelta\t")
+	regressions := 0
+	for _, c := range changes {
+		flag := ""
+		if c.regression {
+			flag = "REGRESSION"
+			regressions++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%+.1f%%\t%s\n", c.benchmark(), c.unit, format(c.before), format(c.after), 100*c.delta, flag)
+	}
+	return regressions, tw.Flush()
+}
+
+// format rounds the means, to units from 100 and to 3 significant digits
+// below.
+func format(v float64) string {
+	if math.Abs(v) >= 100 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 3, 64)
+}
+
+func readResults(path string) (results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return res, nil
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: benchdiff old.txt new.txt")
+		os.Exit(2)
+	}
+	threshold := 10.0
+	if v := os.Getenv(thresholdEnv); v != "" {
+		var err error
+		if threshold, err = strconv.ParseFloat(v, 64); err != nil || threshold < 0 {
+			log.Fatalf("invalid %s %q", thresholdEnv, v)
+		}
+	}
+	before, err := readResults(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	after, err := readResults(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regressions, err := printChanges(os.Stdout, compare(before, after, threshold/100))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range missing(before, after) {
+		fmt.Printf("%s: only in %s\n", name, os.Args[1])
+	}
+	for _, name := range missing(after, before) {
+		fmt.Printf("%s: only in %s\n", name, os.Args[2])
+	}
+	if regressions > 0 {
+		fmt.Printf("%d regressions over %.1f%%\n", regressions, threshold)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+const before = `goos: linux
+goarch: amd64
+pkg: github.com/skonto/test-otel/pkg/promserver
+BenchmarkRender/10-8         	   10000	    100000 ns/op	   72000 B/op	     600 allocs/op
+BenchmarkRender/10-8         	   10000	    110000 ns/op	   72000 B/op	     600 allocs/op
+BenchmarkMarshal-8   	     100	      1000 ns/op	     100.0 MB/s
+BenchmarkRemoved-8   	     100	      1000 ns/op
+PASS
+ok  	github.com/skonto/test-otel/pkg/promserver	3.843s
+`
+
+const after = `pkg: github.com/skonto/test-otel/pkg/promserver
+BenchmarkRender/10-4         	   10000	    120000 ns/op	   72000 B/op	     650 allocs/op
+BenchmarkMarshal-4   	     100	      1000 ns/op	      80.0 MB/s
+BenchmarkAdded-4   	     100	      1000 ns/op
+`
+
+const promserver = "github.com/skonto/test-otel/pkg/promserver"
+
+func TestParse(t *testing.T) {
+	res, err := parse(strings.NewReader(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := results{
+		{promserver, "BenchmarkRender/10", "ns/op"}:     105000,
+		{promserver, "BenchmarkRender/10", "B/op"}:      72000,
+		{promserver, "BenchmarkRender/10", "allocs/op"}: 600,
+		{promserver, "BenchmarkMarshal", "ns/op"}:       1000,
+		{promserver, "BenchmarkMarshal", "MB/s"}:        100,
+		{promserver, "BenchmarkRemoved", "ns/op"}:       1000,
+	}
+	if len(res) != len(want) {
+		t.Errorf("got %v, want %v", res, want)
+	}
+	for k, v := range want {
+		if res[k] != v {
+			t.Errorf("%v: got %v, want %v", k, res[k], v)
+		}
+	}
+	if _, err := parse(strings.NewReader("BenchmarkX 10 fast ns/op\n")); err == nil {
+		t.Error("invalid value accepted")
+	}
+}
+
+// TestParsePackages checks that benchmarks with the same name in different
+// packages are not averaged together.
+func TestParsePackages(t *testing.T) {
+	res, err := parse(strings.NewReader(`pkg: github.com/skonto/test-otel/pkg/otlpfile
+BenchmarkExport-8   	     100	      1000 ns/op
+ok  	github.com/skonto/test-otel/pkg/otlpfile	1.0s
+pkg: github.com/skonto/test-otel/pkg/logexporter
+BenchmarkExport-8   	     100	      3000 ns/op
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := results{
+		{"github.com/skonto/test-otel/pkg/otlpfile", "BenchmarkExport", "ns/op"}:    1000,
+		{"github.com/skonto/test-otel/pkg/logexporter", "BenchmarkExport", "ns/op"}: 3000,
+	}
+	if len(res) != len(want) {
+		t.Errorf("got %v, want %v", res, want)
+	}
+	for k, v := range want {
+		if res[k] != v {
+			t.Errorf("%v: got %v, want %v", k, res[k], v)
+		}
+	}
+	if got := (key{pkg: "github.com/skonto/test-otel/pkg/otlpfile", name: "BenchmarkExport"}).benchmark(); got != "otlpfile.BenchmarkExport" {
+		t.Errorf("got benchmark %q", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	b, err := parse(strings.NewReader(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := parse(strings.NewReader(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[key]change{}
+	for _, c := range compare(b, a, 0.1) {
+		got[c.key] = c
+	}
+	for _, c := range []struct {
+		key        key
+		delta      float64
+		regression bool
+	}{
+		{key{promserver, "BenchmarkRender/10", "ns/op"}, 120000.0/105000 - 1, true},
+		// Within the threshold
+		{key{promserver, "BenchmarkRender/10", "B/op"}, 0, false},
+		{key{promserver, "BenchmarkRender/10", "allocs/op"}, 50.0 / 600, false},
+		// Lower rates are worse
+		{key{promserver, "BenchmarkMarshal", "MB/s"}, -0.2, true},
+		{key{promserver, "BenchmarkMarshal", "ns/op"}, 0, false},
+	} {
+		g, ok := got[c.key]
+		if !ok {
+			t.Errorf("%v: missing", c.key)
+			continue
+		}
+		if math.Abs(g.delta-c.delta) > 1e-9 || g.regression != c.regression {
+			t.Errorf("%v: got %+v, want delta %v regression %v", c.key, g, c.delta, c.regression)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("got %d changes, want 5", len(got))
+	}
+	if m := missing(b, a); len(m) != 1 || m[0] != "promserver.BenchmarkRemoved" {
+		t.Errorf("got missing %v", m)
+	}
+
+	var out bytes.Buffer
+	n, err := printChanges(&out, compare(b, a, 0.05))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || strings.Count(out.String(), "REGRESSION") != 3 {
+		t.Errorf("got %d regressions over 5%%:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "+14.3%") {
+		t.Errorf("missing the latency change:\n%s", out.String())
+	}
+}
+
+func TestProcsSuffix(t *testing.T) {
+	for _, c := range []struct {
+		output string
+		want   []string
+	}{
+		{"BenchmarkX/idle-100-16 1 1 ns/op\nBenchmarkY-16 1 1 ns/op\n", []string{"BenchmarkX/idle-100", "BenchmarkY"}},
+		// GOMAXPROCS=1, no suffix
+		{"BenchmarkX/idle-10 1 1 ns/op\nBenchmarkX/idle-100 1 1 ns/op\n", []string{"BenchmarkX/idle-10", "BenchmarkX/idle-100"}},
+		{"BenchmarkX/idle-10 1 1 ns/op\nBenchmarkY 1 1 ns/op\n", []string{"BenchmarkX/idle-10", "BenchmarkY"}},
+	} {
+		res, err := parse(strings.NewReader(c.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range c.want {
+			if _, ok := res[key{name: name, unit: "ns/op"}]; !ok {
+				t.Errorf("got %v, want %v", res, c.want)
+			}
+		}
+	}
+}
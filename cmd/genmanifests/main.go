@@ -0,0 +1,35 @@
+// genmanifests writes the Kubernetes manifests of the topology described in
+// a JSON file, see pkg/manifests:
+//
+//	go run ./cmd/genmanifests config/topology.json > config/collector.yaml
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/skonto/test-otel/pkg/manifests"
+)
+
+func generate(filename string) ([]byte, error) {
+	t, err := manifests.LoadTopology(filename)
+	if err != nil {
+		return nil, err
+	}
+	return manifests.Generate(t)
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: genmanifests topology.json")
+		os.Exit(2)
+	}
+	out, err := generate(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := os.Stdout.Write(out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// TestConfig checks that config/collector.yaml is generated from the
+// current config/topology.json.
+func TestConfig(t *testing.T) {
+	got, err := generate("../../config/topology.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("../../config/collector.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Error("config/collector.yaml is outdated, run go run ./cmd/genmanifests config/topology.json > config/collector.yaml")
+	}
+}
@@ -6,37 +6,114 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/skonto/test-otel/pkg/aggselector"
+	"github.com/skonto/test-otel/pkg/cardinality"
+	"github.com/skonto/test-otel/pkg/diskqueue"
+	"github.com/skonto/test-otel/pkg/failover"
+	"github.com/skonto/test-otel/pkg/fanout"
+	"github.com/skonto/test-otel/pkg/grpcmetrics"
+	"github.com/skonto/test-otel/pkg/httpmetrics"
+	"github.com/skonto/test-otel/pkg/logging"
 	"github.com/skonto/test-otel/pkg/memstats"
+	"github.com/skonto/test-otel/pkg/otlpfile"
+	"github.com/skonto/test-otel/pkg/otlphttp"
+	"github.com/skonto/test-otel/pkg/promserver"
+	"github.com/skonto/test-otel/pkg/relabel"
+	"github.com/skonto/test-otel/pkg/views"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/metric/prometheus"
 	"go.opentelemetry.io/otel/exporters/otlp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
 	"go.opentelemetry.io/otel/label"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
 	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
-	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/semconv"
 	"google.golang.org/grpc"
 )
 
 const (
+	// Comma separated list of collectors in priority order, metrics go to the
+	// first healthy one
 	oltpEndpointEnv = "OLTP_ENDPOINT"
+	// Optional second collector that receives a copy of all metrics eg. during migrations
+	oltpMirrorEndpointEnv = "OLTP_MIRROR_ENDPOINT"
+	// Optional directory where metrics are queued while the collector is unreachable
+	oltpBufferDirEnv = "OLTP_BUFFER_DIR"
+	// Optional format, text or json, to also print the pushed metrics to stdout
+	stdoutFormatEnv = "METRICS_STDOUT"
+	// Optional file the pushed metrics are also appended to as OTLP JSON lines
+	metricsFileEnv = "METRICS_FILE"
+	// Optional JSON file selecting the aggregation of each instrument, see pkg/aggselector
+	aggregationConfigEnv = "AGGREGATION_CONFIG"
+	// Optional histogram boundaries per instrument eg. "reconcile_latency=10,100,1000;http.*=0.1,1"
+	histogramViewsEnv = "HISTOGRAM_VIEWS"
+	// Optional number of distinct label sets allowed per instrument, see pkg/cardinality
+	cardinalityLimitEnv = "CARDINALITY_LIMIT"
+	// Optional JSON file with the rules dropping, renaming and rewriting labels, see pkg/relabel
+	labelRulesEnv = "LABEL_RULES"
+	// grpc (default) or http
+	oltpProtocolEnv = "OLTP_PROTOCOL"
 	collectPeriod   = 2 * time.Second
 	prometheusPort  = 17000
 )
 
-func initMetrics() {
+// initMetrics installs the pipeline as the global meter provider and
+// returns the server of the Prometheus exporter.
+func initMetrics() *promserver.Server {
 	ctx := context.Background()
-	driver := otlpgrpc.NewDriver(
-		otlpgrpc.WithInsecure(),
-		otlpgrpc.WithEndpoint(oltpEndpoint()),
-		otlpgrpc.WithDialOption(grpc.WithBlock()), // useful for testing
-	)
-	exp, err := otlp.NewExporter(ctx, driver)
-	handleErr(err, "failed to create exporter")
+	var err error
+	endpoints := oltpEndpoints()
+	failoverOpts := []failover.Option{failover.WithMeterProvider(otel.GetMeterProvider())}
+	bufferDir := os.Getenv(oltpBufferDirEnv)
+	if bufferDir != "" && len(endpoints) > 1 {
+		log.Fatalf("%s can not be combined with several endpoints in %s", oltpBufferDirEnv, oltpEndpointEnv)
+	}
+	for _, endpoint := range endpoints {
+		var otlpExp *otlp.Exporter
+		if bufferDir != "" {
+			otlpExp, err = otlp.NewExporter(ctx, diskqueue.NewDriver(bufferDir, oltpSender(endpoint),
+				diskqueue.WithMeterProvider(otel.GetMeterProvider())))
+			fmt.Printf("Buffering OTLP requests in %s\n", bufferDir)
+		} else {
+			otlpExp, err = newOTLPExporter(ctx, endpoint)
+		}
+		handleErr(err, "failed to create exporter")
+		failoverOpts = append(failoverOpts, failover.WithEndpoint(endpoint, otlpExp))
+	}
+	failoverExp, err := failover.New(failoverOpts...)
+	handleErr(err, "failed to create failover exporter")
+	var exp export.Exporter = failoverExp
+	destinations := []fanout.Option{fanout.WithDestination(endpoints[0], failoverExp, collectPeriod)}
+	if mirror := os.Getenv(oltpMirrorEndpointEnv); mirror != "" {
+		mirrorExp, err := newOTLPExporter(ctx, mirror)
+		handleErr(err, "failed to create mirror exporter")
+		destinations = append(destinations, fanout.WithDestination(mirror, mirrorExp, collectPeriod))
+		fmt.Printf("Mirroring OTLP to %s\n", mirror)
+	}
+	if format := os.Getenv(stdoutFormatEnv); format != "" {
+		opt := logging.WithFormat(logging.TextFormat)
+		if format == "json" {
+			opt = logging.WithFormat(logging.JSONFormat)
+		}
+		destinations = append(destinations, fanout.WithDestination("stdout", logging.New(opt), collectPeriod))
+	}
+	if path := os.Getenv(metricsFileEnv); path != "" {
+		fileExp, err := otlpfile.New(path)
+		handleErr(err, "failed to create file exporter")
+		destinations = append(destinations, fanout.WithDestination(path, fileExp, collectPeriod))
+		fmt.Printf("Writing OTLP JSON to %s\n", path)
+	}
+	if len(destinations) > 1 {
+		fanoutExp, err := fanout.New(append(destinations, fanout.WithMeterProvider(otel.GetMeterProvider()))...)
+		handleErr(err, "failed to create fan-out exporter")
+		exp = fanoutExp
+	}
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
 			// the service name used to display traces in backends
@@ -45,12 +122,21 @@ func initMetrics() {
 		),
 	)
 	handleErr(err, "failed to create resource")
-	cont := controller.New(
+	limiter, err := cardinality.New(
 		processor.New(
-			simple.NewWithExactDistribution(),
+			aggregatorSelector(),
 			exp,
 			processor.WithMemory(true),
 		),
+		cardinality.WithLimit(cardinalityLimit()),
+		cardinality.WithMeterProvider(otel.GetMeterProvider()),
+	)
+	handleErr(err, "failed to create cardinality limiter")
+	// Labels are rewritten before the limiter counts label sets
+	relabeler, err := relabel.New(limiter, labelRules())
+	handleErr(err, "invalid label rules")
+	cont := controller.New(
+		relabeler,
 		controller.WithPusher(exp),
 		controller.WithCollectPeriod(collectPeriod),
 		controller.WithResource(res),
@@ -62,38 +148,160 @@ func initMetrics() {
 	if err != nil {
 		log.Fatal("could not initialize prometheus:", err)
 	}
-	http.HandleFunc("/", promExporter.ServeHTTP)
+	promServer, err := promserver.New(promExporter, append([]promserver.Option{
+		promserver.WithAddress(fmt.Sprintf(":%d", prometheusPort)),
+		promserver.WithPath("/"),
+		promserver.WithMeterProvider(otel.GetMeterProvider()),
+	}, promserver.OptionsFromEnv()...)...)
+	handleErr(err, "could not create prometheus server")
+	handleErr(promServer.Start(), "could not start prometheus server")
 	go func() {
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", prometheusPort), nil))
+		if err := <-promServer.Err(); err != nil {
+			log.Fatal(err)
+		}
 	}()
 
 	otel.SetMeterProvider(cont.MeterProvider())
-	fmt.Printf("Prometheus server running on :%d\n", prometheusPort)
-	fmt.Printf("Exporting OTLP to %s\n", oltpEndpoint())
+	fmt.Printf("Prometheus server running on %s\n", promServer.URL())
+	fmt.Printf("Exporting OTLP to %s\n", strings.Join(endpoints, ", "))
+	return promServer
 }
 
 func main() {
 	fmt.Printf("Starting local runtimeplugin\n")
 	initMetrics()
-	if err := memstats.Start(
-		memstats.WithMinimumReadMemStatsInterval(time.Second),
-		memstats.WithLabels([]label.KeyValue{label.Key("app_name").String("knativememstats")}),
-		memstats.WithMetricPrefix("test_app"),
-	); err != nil {
+	if err := startMemstats(); err != nil {
 		panic(err)
 	}
 	// TODO add proper shutdown
 	select {}
 }
+
+func startMemstats() error {
+	return memstats.Start(
+		memstats.WithMinimumReadMemStatsInterval(time.Second),
+		memstats.WithLabels([]label.KeyValue{label.Key("app_name").String("knativememstats")}),
+		memstats.WithMetricPrefix("test_app"),
+	)
+}
 func handleErr(err error, message string) {
 	if err != nil {
 		log.Fatalf("%s: %v", message, err)
 	}
 }
 
-func oltpEndpoint() string {
-	if oltp := os.Getenv(oltpEndpointEnv); oltp != "" {
-		return oltp
+// newOTLPExporter waits at most a collect period for the collector to come
+// up, so that a backup endpoint that is down does not block startup.
+func newOTLPExporter(ctx context.Context, endpoint string) (*otlp.Exporter, error) {
+	ctx, cancel := context.WithTimeout(ctx, collectPeriod)
+	defer cancel()
+	return otlp.NewExporter(ctx, oltpDriver(endpoint))
+}
+
+func oltpDriver(endpoint string) otlp.ProtocolDriver {
+	if os.Getenv(oltpProtocolEnv) == "http" {
+		return otlphttp.NewDriver(
+			otlphttp.WithInsecure(),
+			otlphttp.WithEndpoint(endpoint),
+			otlphttp.WithCompression(otlphttp.GzipCompression),
+			otlphttp.WithTransportWrapper(collectorTransport),
+		)
+	}
+	return otlpgrpc.NewDriver(
+		otlpgrpc.WithInsecure(),
+		otlpgrpc.WithEndpoint(endpoint),
+		otlpgrpc.WithDialOption(append(collectorDialOptions(), grpc.WithBlock())...), // block is useful for testing
+	)
+}
+
+// collectorDialOptions record the RPCs made to the collectors.
+func collectorDialOptions() []grpc.DialOption {
+	rpcMetrics, err := grpcmetrics.NewClient(grpcmetrics.WithMeterProvider(otel.GetMeterProvider()))
+	handleErr(err, "failed to create gRPC metrics")
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(rpcMetrics.UnaryInterceptor()),
+		grpc.WithStreamInterceptor(rpcMetrics.StreamInterceptor()),
+	}
+}
+
+// collectorTransport records the requests sent to the collectors.
+func collectorTransport(base http.RoundTripper) http.RoundTripper {
+	t, err := httpmetrics.NewTransport(base, httpmetrics.WithMeterProvider(otel.GetMeterProvider()))
+	handleErr(err, "failed to create HTTP metrics")
+	return t
+}
+
+// oltpSender sends the requests replayed from the buffer directory.
+func oltpSender(endpoint string) diskqueue.Sender {
+	if os.Getenv(oltpProtocolEnv) == "http" {
+		return otlphttp.NewClient(
+			otlphttp.WithInsecure(),
+			otlphttp.WithEndpoint(endpoint),
+			otlphttp.WithCompression(otlphttp.GzipCompression),
+			otlphttp.WithTransportWrapper(collectorTransport),
+		)
+	}
+	conn, err := grpc.Dial(endpoint, append(collectorDialOptions(), grpc.WithInsecure())...)
+	handleErr(err, "failed to dial collector")
+	return diskqueue.NewGRPCSender(conn)
+}
+
+// aggregatorSelector keeps exact aggregation for every ValueRecorder unless
+// a configuration file says otherwise. Histogram views apply on top.
+func aggregatorSelector() export.AggregatorSelector {
+	cfg := aggselector.Config{Default: aggselector.Exact}
+	if filename := os.Getenv(aggregationConfigEnv); filename != "" {
+		var err error
+		cfg, err = aggselector.LoadConfig(filename)
+		handleErr(err, "failed to load aggregation config")
+	}
+	selector, err := aggselector.New(cfg)
+	handleErr(err, "invalid aggregation config")
+	histogramViews, err := views.Parse(os.Getenv(histogramViewsEnv))
+	handleErr(err, "invalid histogram views")
+	withViews, err := views.New(selector, histogramViews...)
+	handleErr(err, "invalid histogram views")
+	return withViews
+}
+
+// labelRules loads the label rules file if one is set.
+func labelRules() relabel.Config {
+	filename := os.Getenv(labelRulesEnv)
+	if filename == "" {
+		return relabel.Config{}
+	}
+	cfg, err := relabel.LoadConfig(filename)
+	handleErr(err, "failed to load label rules")
+	return cfg
+}
+
+// cardinalityLimit returns the limit set in the environment, or the
+// limiter's default.
+func cardinalityLimit() int {
+	limit := os.Getenv(cardinalityLimitEnv)
+	if limit == "" {
+		return cardinality.DefaultLimit
+	}
+	n, err := strconv.Atoi(limit)
+	if err == nil && n <= 0 {
+		err = fmt.Errorf("%d is not positive", n)
+	}
+	handleErr(err, "invalid cardinality limit")
+	return n
+}
+
+func oltpEndpoints() []string {
+	var endpoints []string
+	for _, oltp := range strings.Split(os.Getenv(oltpEndpointEnv), ",") {
+		if oltp = strings.TrimSpace(oltp); oltp != "" {
+			endpoints = append(endpoints, oltp)
+		}
+	}
+	if len(endpoints) > 0 {
+		return endpoints
+	}
+	if os.Getenv(oltpProtocolEnv) == "http" {
+		return []string{otlphttp.DefaultEndpoint}
 	}
-	return "0.0.0.0:55680"
+	return []string{"0.0.0.0:55680"}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/skonto/test-otel/pkg/otlptest"
+	"github.com/skonto/test-otel/pkg/parity"
+	"github.com/skonto/test-otel/pkg/promserver"
+)
+
+// Set to a file to write the JSON report of TestParity to.
+const parityReportEnv = "PARITY_REPORT"
+
+// TestParity runs the pipeline against a fake collector, scrapes the
+// Prometheus server right after a push and compares the two outputs. It
+// fails on any divergence other than the known issues.
+func TestParity(t *testing.T) {
+	c, err := otlptest.NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	setenv(t, oltpEndpointEnv, c.Endpoint())
+	setenv(t, promserver.AddressEnv, "127.0.0.1:0")
+	server := initMetrics()
+	defer server.Shutdown(context.Background())
+	if err := startMemstats(); err != nil {
+		t.Fatal(err)
+	}
+
+	report := scrapeAfterPush(t, c, server.URL())
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := os.Getenv(parityReportEnv); path != "" {
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range report.Divergences {
+		if !knownIssue(d) {
+			t.Errorf("unexpected divergence %+v", d)
+		}
+	}
+	if t.Failed() {
+		t.Logf("%s", data)
+	}
+}
+
+// knownIssue tells whether a divergence is one of the known issues of the
+// README: gauges pushed as non-monotonic sums and resource attributes
+// exposed as labels to Prometheus only.
+func knownIssue(d parity.Divergence) bool {
+	switch d.Kind {
+	case parity.Type:
+		return d.Prometheus == "gauge" && strings.HasSuffix(d.OTLP, "(non-monotonic)")
+	case parity.Labels:
+		return d.OTLP == "" && d.Prometheus == strings.Join(d.Resource, ",")
+	}
+	return false
+}
+
+// scrapeAfterPush compares a scrape with the push before it. The scrape is
+// retried when a push happens meanwhile, as both paths read the checkpoint
+// of the last collection.
+func scrapeAfterPush(t *testing.T, c *otlptest.Collector, url string) parity.Report {
+	t.Helper()
+	for attempt := 0; attempt < 3; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*collectPeriod)
+		c.Reset()
+		err := c.WaitForMetricsRequests(ctx, 1)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		families := scrape(t, url)
+		if len(c.MetricsRequests()) == 1 {
+			return parity.Compare(families, c.Metrics())
+		}
+	}
+	t.Fatal("could not scrape between two pushes")
+	return parity.Report{}
+}
+
+func scrape(t *testing.T, url string) map[string]*dto.MetricFamily {
+	t.Helper()
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	families, err := parity.ParsePrometheus(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "time"
+
+// cpuTime is not available on this platform, the CPU overhead is not
+// reported.
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
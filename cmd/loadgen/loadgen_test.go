@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	period := 100 * time.Second
+	for _, c := range []struct {
+		name string
+		want map[time.Duration]float64
+	}{
+		{profileConstant, map[time.Duration]float64{0: 1, 55 * time.Second: 1}},
+		{profileStep, map[time.Duration]float64{0: 0.25, 30 * time.Second: 0.5, 60 * time.Second: 0.75, 99 * time.Second: 1, 100 * time.Second: 0.25}},
+		{profileSpike, map[time.Duration]float64{0: 1, 9 * time.Second: 1, 10 * time.Second: 0.1, 110 * time.Second: 0.1}},
+		{profileSine, map[time.Duration]float64{0: 0.5, 25 * time.Second: 1, 50 * time.Second: 0.5, 75 * time.Second: 0}},
+	} {
+		p, err := newProfile(c.name, period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for elapsed, want := range c.want {
+			if got := p(elapsed); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s after %v: got %v, want %v", c.name, elapsed, got, want)
+			}
+		}
+	}
+	if _, err := newProfile("ramp", period); err == nil {
+		t.Error("unknown profile accepted")
+	}
+	if _, err := newProfile(profileSine, 0); err == nil {
+		t.Error("zero period accepted")
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	got, err := parseMix(" counter=2, histogram=1,observer=0,counter=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mix{kindCounter: 3, kindHistogram: 1, kindObserver: 0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, s := range []string{"gauge=1", "counter", "counter=-1", "observer=2", ""} {
+		if _, err := parseMix(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func TestChurn(t *testing.T) {
+	sets := newLabelSets(3)
+	seen := map[string]bool{}
+	for round := 0; round < 4; round++ {
+		for i := range sets.slots {
+			id := sets.get(i)[2].Value.AsString()
+			if seen[id] {
+				t.Fatalf("label set %s seen twice", id)
+			}
+			seen[id] = true
+		}
+		sets.churn(len(sets.slots))
+	}
+}
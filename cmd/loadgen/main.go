@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+const (
+	// Peak number of measurements recorded per second
+	qpsEnv = "LOADGEN_QPS"
+	// Number of goroutines recording
+	workersEnv = "LOADGEN_WORKERS"
+	// Number of distinct label sets each instrument is recorded with
+	cardinalityEnv = "LOADGEN_CARDINALITY"
+	// Number of label sets replaced by new ones per second
+	churnEnv = "LOADGEN_CHURN"
+	// Instruments by kind eg. "counter=2,updowncounter=1,histogram=1,observer=1"
+	instrumentsEnv = "LOADGEN_INSTRUMENTS"
+	// constant, step, spike or sine, see profile.go
+	profileEnv = "LOADGEN_PROFILE"
+	// Period of the profile eg. 1m
+	periodEnv = "LOADGEN_PERIOD"
+	// How long to run, until interrupted if unset
+	durationEnv = "LOADGEN_DURATION"
+	// How often metrics are collected and the overhead reported
+	reportIntervalEnv = "LOADGEN_REPORT_INTERVAL"
+)
+
+type config struct {
+	qps            float64
+	workers        int
+	cardinality    int
+	churn          float64
+	instruments    mix
+	profile        profile
+	duration       time.Duration
+	reportInterval time.Duration
+}
+
+func loadConfig() (config, error) {
+	cfg := config{}
+	var err error
+	if cfg.qps, err = envFloat(qpsEnv, 1000); err != nil {
+		return cfg, err
+	}
+	if cfg.workers, err = envInt(workersEnv, runtime.GOMAXPROCS(0)); err != nil {
+		return cfg, err
+	}
+	if cfg.cardinality, err = envInt(cardinalityEnv, 100); err != nil {
+		return cfg, err
+	}
+	if cfg.churn, err = envFloat(churnEnv, 0); err != nil {
+		return cfg, err
+	}
+	if cfg.instruments, err = parseMix(envString(instrumentsEnv, "counter=1,histogram=1")); err != nil {
+		return cfg, fmt.Errorf("%s: %w", instrumentsEnv, err)
+	}
+	period, err := envDuration(periodEnv, time.Minute)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.profile, err = newProfile(os.Getenv(profileEnv), period); err != nil {
+		return cfg, fmt.Errorf("%s: %w", profileEnv, err)
+	}
+	if cfg.duration, err = envDuration(durationEnv, 0); err != nil {
+		return cfg, err
+	}
+	if cfg.reportInterval, err = envDuration(reportIntervalEnv, 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.qps <= 0 || cfg.workers <= 0 || cfg.cardinality <= 0 || cfg.churn < 0 || cfg.reportInterval <= 0 {
+		return cfg, fmt.Errorf("%s, %s, %s and %s must be positive, %s must not be negative",
+			qpsEnv, workersEnv, cardinalityEnv, reportIntervalEnv, churnEnv)
+	}
+	return cfg, nil
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, nil
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	// Cumulative with memory, like a Prometheus pipeline, so that the series
+	// count includes the label sets churned out
+	proc := processor.New(simple.NewWithHistogramDistribution([]float64{1, 5, 10, 50, 100}),
+		export.CumulativeExportKindSelector(), processor.WithMemory(true))
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	sets := newLabelSets(cfg.cardinality)
+	recorders, err := newInstruments(cont.MeterProvider().Meter("github.com/skonto/test-otel/cmd/loadgen"), cfg.instruments, sets)
+	if err != nil {
+		log.Fatalf("failed to create instruments: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cfg.duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	fmt.Printf("Recording up to %.0f measurements/s with %d workers, %d label sets, %.1f replaced/s, instruments %v\n",
+		cfg.qps, cfg.workers, cfg.cardinality, cfg.churn, cfg.instruments)
+	g := &generator{qps: cfg.qps, profile: cfg.profile, sets: sets, rec: recorders}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.run(ctx, cfg.workers)
+	}()
+	go churn(ctx, sets, cfg.churn)
+
+	r := newReporter(cont, g)
+	ticker := time.NewTicker(cfg.reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			<-done
+			r.report()
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
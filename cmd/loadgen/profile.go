@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// profile returns the fraction of the peak rate to generate after elapsed.
+type profile func(elapsed time.Duration) float64
+
+// Names of the profiles, the shapes repeat every period.
+const (
+	// The peak rate all along
+	profileConstant = "constant"
+	// A quarter, half, three quarters then all of the peak rate, each for a
+	// quarter of the period
+	profileStep = "step"
+	// The peak rate for the first tenth of the period, a tenth of it for
+	// the rest
+	profileSpike = "spike"
+	// A sine wave between zero and the peak rate, starting at half of it
+	profileSine = "sine"
+)
+
+func newProfile(name string, period time.Duration) (profile, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("period %v is not positive", period)
+	}
+	phase := func(elapsed time.Duration) float64 {
+		return float64(elapsed%period) / float64(period)
+	}
+	switch name {
+	case profileConstant, "":
+		return func(time.Duration) float64 { return 1 }, nil
+	case profileStep:
+		return func(elapsed time.Duration) float64 {
+			return math.Floor(4*phase(elapsed)+1) / 4
+		}, nil
+	case profileSpike:
+		return func(elapsed time.Duration) float64 {
+			if phase(elapsed) < 0.1 {
+				return 1
+			}
+			return 0.1
+		}, nil
+	case profileSine:
+		return func(elapsed time.Duration) float64 {
+			return 0.5 + 0.5*math.Sin(2*math.Pi*phase(elapsed))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown profile %q, want %s, %s, %s or %s", name, profileConstant, profileStep, profileSpike, profileSine)
+}
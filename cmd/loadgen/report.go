@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+)
+
+// sample is what the overhead is computed from, between two reports.
+type sample struct {
+	time       time.Time
+	cpu        time.Duration
+	mallocs    uint64
+	totalAlloc uint64
+	offered    int64
+	recorded   int64
+	missed     int64
+}
+
+func (g *generator) sample() sample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	cpu, _ := cpuTime()
+	return sample{
+		time:       time.Now(),
+		cpu:        cpu,
+		mallocs:    mem.Mallocs,
+		totalAlloc: mem.TotalAlloc,
+		offered:    atomic.LoadInt64(&g.offered),
+		recorded:   atomic.LoadInt64(&g.recorded),
+		missed:     atomic.LoadInt64(&g.missed),
+	}
+}
+
+// reporter collects the metrics recorded and prints the overhead of
+// recording and collecting them since the previous report. The CPU time is
+// the process', the generator itself takes a small part of it.
+type reporter struct {
+	cont  *controller.Controller
+	g     *generator
+	start time.Time
+	last  sample
+}
+
+func newReporter(cont *controller.Controller, g *generator) *reporter {
+	last := g.sample()
+	return &reporter{cont: cont, g: g, start: last.time, last: last}
+}
+
+func (r *reporter) report() {
+	start := time.Now()
+	if err := r.cont.Collect(context.Background()); err != nil {
+		fmt.Printf("failed to collect: %v\n", err)
+		return
+	}
+	collect := time.Since(start)
+	series := 0
+	if err := r.cont.ForEach(export.CumulativeExportKindSelector(), func(export.Record) error {
+		series++
+		return nil
+	}); err != nil {
+		fmt.Printf("failed to count series: %v\n", err)
+		return
+	}
+
+	s := r.g.sample()
+	elapsed := s.time.Sub(r.last.time)
+	recorded := s.recorded - r.last.recorded
+	perOp := func(v float64) float64 {
+		if recorded == 0 {
+			return 0
+		}
+		return v / float64(recorded)
+	}
+	cpu := "n/a"
+	if _, ok := cpuTime(); ok {
+		used := s.cpu - r.last.cpu
+		cpu = fmt.Sprintf("%.2f cores %v/op", used.Seconds()/elapsed.Seconds(), time.Duration(perOp(float64(used))))
+	}
+	fmt.Printf("%v: offered=%.0f/s recorded=%.0f/s missed=%d cpu=%s allocs=%.1f/op bytes=%.0f/op series=%d collect=%v\n",
+		s.time.Sub(r.start).Round(time.Second),
+		float64(s.offered-r.last.offered)/elapsed.Seconds(),
+		float64(recorded)/elapsed.Seconds(),
+		s.missed-r.last.missed,
+		cpu,
+		perOp(float64(s.mallocs-r.last.mallocs)),
+		perOp(float64(s.totalAlloc-r.last.totalAlloc)),
+		series,
+		collect.Round(time.Microsecond))
+	r.last = s
+}
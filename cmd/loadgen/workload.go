@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Kinds of instruments of the mix.
+const (
+	kindCounter       = "counter"
+	kindUpDownCounter = "updowncounter"
+	kindHistogram     = "histogram"
+	kindObserver      = "observer"
+)
+
+// mix is the number of instruments of each kind.
+type mix map[string]int
+
+// parseMix parses "counter=2,histogram=1", kinds left out get no
+// instrument.
+func parseMix(s string) (mix, error) {
+	m := mix{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not kind=count", part)
+		}
+		kind := strings.TrimSpace(kv[0])
+		switch kind {
+		case kindCounter, kindUpDownCounter, kindHistogram, kindObserver:
+		default:
+			return nil, fmt.Errorf("unknown instrument kind %q", kind)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid count of %s: %q", kind, kv[1])
+		}
+		m[kind] += n
+	}
+	if m[kindCounter]+m[kindUpDownCounter]+m[kindHistogram] == 0 {
+		return nil, fmt.Errorf("%q has no synchronous instrument", s)
+	}
+	return m, nil
+}
+
+// labelSets are the label sets measurements pick from. Churn replaces them
+// with new ones while workers read them, each slot holds a
+// []label.KeyValue that is never modified. The SDK reorders the labels it
+// is given in place, they are recorded from copies.
+type labelSets struct {
+	slots []atomic.Value
+	// generation is the number of label sets replaced so far, it makes the
+	// values of the replacements unique.
+	generation int
+}
+
+func newLabelSets(n int) *labelSets {
+	s := &labelSets{slots: make([]atomic.Value, n)}
+	for i := range s.slots {
+		s.slots[i].Store(s.labels(i, 0))
+	}
+	return s
+}
+
+func (s *labelSets) labels(i, generation int) []label.KeyValue {
+	return []label.KeyValue{
+		label.String("service", "loadgen"),
+		label.String("route", "/route/"+strconv.Itoa(i%10)),
+		label.String("id", strconv.Itoa(generation)+"-"+strconv.Itoa(i)),
+	}
+}
+
+func (s *labelSets) get(i int) []label.KeyValue {
+	return s.slots[i].Load().([]label.KeyValue)
+}
+
+// churn replaces n label sets, in turn, with new ones. It is only called by
+// the churn goroutine.
+func (s *labelSets) churn(n int) {
+	for ; n > 0; n-- {
+		s.generation++
+		i := s.generation % len(s.slots)
+		s.slots[i].Store(s.labels(i, s.generation/len(s.slots)+1))
+	}
+}
+
+// recorder records one measurement, v is uniform in [0, 1).
+type recorder func(ctx context.Context, v float64, labels []label.KeyValue)
+
+// newInstruments creates the instruments of the mix and returns the
+// recorders of the synchronous ones. Observers observe every label set on
+// collection.
+func newInstruments(meter metric.Meter, m mix, sets *labelSets) ([]recorder, error) {
+	var recorders []recorder
+	for i := 0; i < m[kindCounter]; i++ {
+		c, err := meter.NewInt64Counter(fmt.Sprintf("loadgen.counter.%d", i))
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, func(ctx context.Context, _ float64, labels []label.KeyValue) {
+			c.Add(ctx, 1, labels...)
+		})
+	}
+	for i := 0; i < m[kindUpDownCounter]; i++ {
+		c, err := meter.NewInt64UpDownCounter(fmt.Sprintf("loadgen.updowncounter.%d", i))
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, func(ctx context.Context, v float64, labels []label.KeyValue) {
+			if v < 0.5 {
+				c.Add(ctx, 1, labels...)
+			} else {
+				c.Add(ctx, -1, labels...)
+			}
+		})
+	}
+	for i := 0; i < m[kindHistogram]; i++ {
+		r, err := meter.NewFloat64ValueRecorder(fmt.Sprintf("loadgen.histogram.%d", i))
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, func(ctx context.Context, v float64, labels []label.KeyValue) {
+			r.Record(ctx, 100*v, labels...)
+		})
+	}
+	for i := 0; i < m[kindObserver]; i++ {
+		var buf []label.KeyValue
+		_, err := meter.NewInt64ValueObserver(fmt.Sprintf("loadgen.observer.%d", i), func(_ context.Context, result metric.Int64ObserverResult) {
+			for j := range sets.slots {
+				buf = append(buf[:0], sets.get(j)...)
+				result.Observe(int64(j), buf...)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return recorders, nil
+}
+
+// dispatchInterval is how often the dispatcher hands measurements to the
+// workers.
+const dispatchInterval = 10 * time.Millisecond
+
+// generator hands out the measurements to record at the rate of a profile
+// and counts those recorded.
+type generator struct {
+	// offered counts the measurements due according to the profile.
+	offered  int64
+	recorded int64
+	// missed counts the measurements not handed out because the workers
+	// were busy, the workload is then CPU bound.
+	missed int64
+
+	qps     float64
+	profile profile
+	sets    *labelSets
+	rec     []recorder
+	work    chan int
+}
+
+// run starts the workers and dispatches measurements until ctx is done.
+func (g *generator) run(ctx context.Context, workers int) {
+	g.work = make(chan int, 2*workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			g.worker(ctx, rand.New(rand.NewSource(seed)))
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	start := time.Now()
+	last := start
+	var budget float64
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			close(g.work)
+			wg.Wait()
+			return
+		case now := <-ticker.C:
+			budget += g.qps * g.profile(now.Sub(start)) * now.Sub(last).Seconds()
+			last = now
+			n := int(budget)
+			if n == 0 {
+				continue
+			}
+			budget -= float64(n)
+			atomic.AddInt64(&g.offered, int64(n))
+			select {
+			case g.work <- n:
+			default:
+				atomic.AddInt64(&g.missed, int64(n))
+			}
+		}
+	}
+}
+
+// worker records the batches of measurements it receives, cycling through
+// the instruments with random label sets. The labels are copied to a
+// buffer that is reused, so that the generator does not allocate.
+func (g *generator) worker(ctx context.Context, rng *rand.Rand) {
+	next := 0
+	var buf []label.KeyValue
+	for n := range g.work {
+		for i := 0; i < n; i++ {
+			buf = append(buf[:0], g.sets.get(rng.Intn(len(g.sets.slots)))...)
+			g.rec[next](ctx, rng.Float64(), buf)
+			next = (next + 1) % len(g.rec)
+		}
+		atomic.AddInt64(&g.recorded, int64(n))
+	}
+}
+
+// churn replaces perSecond label sets every second until ctx is done.
+func churn(ctx context.Context, sets *labelSets, perSecond float64) {
+	if perSecond <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var budget float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			budget += perSecond
+			n := int(budget)
+			budget -= float64(n)
+			sets.churn(n)
+		}
+	}
+}
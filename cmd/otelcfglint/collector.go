@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sections are the top-level sections of a collector configuration holding
+// components.
+var sections = []string{"receivers", "processors", "exporters", "extensions"}
+
+// collector is a collector configuration.
+type collector struct {
+	root *yaml.Node
+	// offset is the line of the file the configuration starts after, for
+	// configurations embedded in ConfigMaps.
+	offset int
+	// listeners are filled by check.
+	listeners []listener
+}
+
+// listener is a port a component listens on.
+type listener struct {
+	component string
+	port
+	line int
+}
+
+func (c *collector) line(n *yaml.Node) int {
+	return c.offset + n.Line
+}
+
+// isCollector tells whether a document is a collector configuration.
+func isCollector(root *yaml.Node) bool {
+	for _, key := range []string{"receivers", "processors", "exporters", "extensions", "service"} {
+		if k, _ := lookup(root, key); k != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// componentType returns the type of a component named "type" or
+// "type/name".
+func componentType(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// singular returns the kind of the components of a section, eg. "receiver".
+func singular(section string) string {
+	return strings.TrimSuffix(section, "s")
+}
+
+// check reports the problems of the configuration and fills the listeners.
+func (c *collector) check(r *report) {
+	// defined tells by section and name whether a component is used.
+	defined := map[string]map[string]bool{}
+	for _, section := range sections {
+		defined[section] = map[string]bool{}
+		_, v := lookup(c.root, section)
+		if !isNull(v) && v.Kind != yaml.MappingNode {
+			r.errorf(c.line(v), "%s must be a mapping of components", section)
+		}
+		entries(v, func(k, v *yaml.Node) {
+			defined[section][k.Value] = false
+			c.checkComponent(r, section, k.Value, v)
+		})
+	}
+	entries(c.root, func(k, v *yaml.Node) {
+		switch k.Value {
+		case "receivers", "processors", "exporters", "extensions", "service":
+			return
+		}
+		for _, section := range sections {
+			if _, ok := dataTypes[section][componentType(k.Value)]; ok {
+				// Likely a component indented one level too few, it is
+				// checked to report all its problems at once.
+				r.errorf(c.line(k), "unknown top-level key %q, should it be indented under %s?", k.Value, section)
+				c.checkComponent(r, section, k.Value, v)
+				return
+			}
+		}
+		r.errorf(c.line(k), "unknown top-level key %q", k.Value)
+	})
+
+	service := value(c.root, "service")
+	if service == nil {
+		r.errorf(c.line(c.root), "service is not defined")
+	}
+	for _, item := range items(value(service, "extensions")) {
+		if _, ok := defined["extensions"][item.Value]; !ok {
+			r.errorf(c.line(item), "service.extensions: extension %q is not defined", item.Value)
+			continue
+		}
+		defined["extensions"][item.Value] = true
+	}
+	pipelines := value(service, "pipelines")
+	if service != nil && isEmpty(pipelines) {
+		r.errorf(c.line(service), "service has no pipelines")
+	}
+	entries(pipelines, func(k, v *yaml.Node) {
+		c.checkPipeline(r, k, v, defined)
+	})
+
+	for _, section := range sections {
+		entries(value(c.root, section), func(k, v *yaml.Node) {
+			if !defined[section][k.Value] {
+				r.warnf(c.line(k), "%s %q is defined but not used", singular(section), k.Value)
+				return
+			}
+			c.listen(r, section, k.Value, v)
+		})
+	}
+	if service != nil {
+		c.listenTelemetry(r, service)
+	}
+	c.checkConflicts(r)
+}
+
+// checkPipeline checks the components of a pipeline and marks them used.
+func (c *collector) checkPipeline(r *report, name, pipeline *yaml.Node, defined map[string]map[string]bool) {
+	dataType := componentType(name.Value)
+	known := dataType == traces || dataType == metrics || dataType == logs
+	if !known {
+		r.errorf(c.line(name), "pipeline %q: unknown type %q, must be traces, metrics or logs", name.Value, dataType)
+	}
+	for _, section := range []string{"receivers", "processors", "exporters"} {
+		list := items(value(pipeline, section))
+		if len(list) == 0 && section != "processors" {
+			r.errorf(c.line(name), "pipeline %q has no %s", name.Value, section)
+		}
+		for _, item := range list {
+			if _, ok := defined[section][item.Value]; !ok {
+				r.errorf(c.line(item), "pipeline %q: %s %q is not defined", name.Value, singular(section), item.Value)
+				continue
+			}
+			defined[section][item.Value] = true
+			if known && !supports(section, componentType(item.Value), dataType) {
+				r.errorf(c.line(item), "pipeline %q: %s %q does not support %s", name.Value, singular(section), item.Value, dataType)
+			}
+		}
+	}
+}
+
+// checkComponent checks the settings of the components known to be often
+// wrong.
+func (c *collector) checkComponent(r *report, section, name string, v *yaml.Node) {
+	if section != "receivers" || componentType(name) != "prometheus" {
+		return
+	}
+	scrapeConfigs := items(path(v, "config", "scrape_configs"))
+	if len(scrapeConfigs) == 0 {
+		r.errorf(c.line(v), "receiver %q has no scrape_configs", name)
+	}
+	for _, sc := range scrapeConfigs {
+		if !hasTargets(sc) {
+			r.errorf(c.line(sc), "receiver %q: scrape config %q has no targets", name, scalar(value(sc, "job_name")))
+		}
+	}
+}
+
+// hasTargets tells whether a Prometheus scrape config has static targets or
+// service discovery.
+func hasTargets(sc *yaml.Node) bool {
+	found := false
+	entries(sc, func(k, v *yaml.Node) {
+		if strings.HasSuffix(k.Value, "_sd_configs") && !isEmpty(v) {
+			found = true
+		}
+	})
+	for _, static := range items(value(sc, "static_configs")) {
+		if !isEmpty(value(static, "targets")) {
+			found = true
+		}
+	}
+	return found
+}
+
+// listen adds the ports a component listens on.
+func (c *collector) listen(r *report, section, name string, v *yaml.Node) {
+	typ := componentType(name)
+	if section == "exporters" && !servingExporters[typ] {
+		return
+	}
+	if section == "receivers" {
+		if protocols, ok := protocolPorts[typ]; ok {
+			entries(value(v, "protocols"), func(k, pv *yaml.Node) {
+				def, ok := protocols[k.Value]
+				if !ok {
+					r.errorf(c.line(k), "receiver %q: unknown protocol %q", name, k.Value)
+					return
+				}
+				c.addListener(r, fmt.Sprintf("receiver %q (%s)", name, k.Value), pv, k, def)
+			})
+			return
+		}
+	}
+	def, ok := defaultPorts[section][typ]
+	if !ok && value(v, "endpoint") == nil {
+		return
+	}
+	c.addListener(r, fmt.Sprintf("%s %q", singular(section), name), v, v, def)
+}
+
+// addListener adds the port of the endpoint or port setting of v, or the
+// default port when there is none. at is the node to report at when v is
+// null.
+func (c *collector) addListener(r *report, desc string, v, at *yaml.Node, def port) {
+	l := listener{component: desc, port: def, line: c.line(at)}
+	if _, n := lookup(v, "port"); n != nil {
+		p, ok := intValue(n)
+		if !ok {
+			r.errorf(c.line(n), "%s: invalid port %q", desc, n.Value)
+			return
+		}
+		l.number, l.line = p, c.line(n)
+	}
+	if _, n := lookup(v, "endpoint"); n != nil {
+		p, err := endpointPort(scalar(n))
+		if err != nil {
+			r.errorf(c.line(n), "%s: invalid endpoint %q: %v", desc, n.Value, err)
+			return
+		}
+		l.number, l.line = p, c.line(n)
+	}
+	if l.number == 0 {
+		r.errorf(l.line, "%s has no endpoint", desc)
+		return
+	}
+	c.listeners = append(c.listeners, l)
+}
+
+// listenTelemetry adds the port the collector serves its own metrics on.
+func (c *collector) listenTelemetry(r *report, service *yaml.Node) {
+	metricsNode := path(service, "telemetry", "metrics")
+	if scalar(value(metricsNode, "level")) == "none" {
+		return
+	}
+	l := listener{component: "service telemetry", port: port{number: telemetryPort}, line: c.line(service)}
+	if _, n := lookup(metricsNode, "address"); n != nil {
+		p, err := endpointPort(scalar(n))
+		if err != nil {
+			r.errorf(c.line(n), "service.telemetry.metrics: invalid address %q: %v", n.Value, err)
+			return
+		}
+		l.number, l.line = p, c.line(n)
+	}
+	c.listeners = append(c.listeners, l)
+}
+
+// endpointPort returns the port of a "host:port" endpoint.
+func endpointPort(endpoint string) (int, error) {
+	_, p, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(p)
+	if err != nil || n <= 0 || n > 65535 {
+		return 0, fmt.Errorf("invalid port %q", p)
+	}
+	return n, nil
+}
+
+// checkConflicts reports the components listening on the same port.
+func (c *collector) checkConflicts(r *report) {
+	seen := map[port]listener{}
+	for _, l := range c.listeners {
+		if first, ok := seen[l.port]; ok {
+			r.errorf(l.line, "%s and %s both listen on port %d", first.component, l.component, l.number)
+			continue
+		}
+		seen[l.port] = l
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// Data types of the pipelines.
+const (
+	traces  = "traces"
+	metrics = "metrics"
+	logs    = "logs"
+)
+
+var allTypes = []string{traces, metrics, logs}
+
+// dataTypes are the data types the known components support by section and
+// component type. Components not listed are not checked.
+var dataTypes = map[string]map[string][]string{
+	"receivers": {
+		"otlp":          allTypes,
+		"opencensus":    {traces, metrics},
+		"jaeger":        {traces},
+		"zipkin":        {traces},
+		"kafka":         {traces},
+		"prometheus":    {metrics},
+		"hostmetrics":   {metrics},
+		"fluentforward": {logs},
+	},
+	"processors": {
+		"batch":                 allTypes,
+		"memory_limiter":        allTypes,
+		"resource":              allTypes,
+		"attributes":            {traces, logs},
+		"span":                  {traces},
+		"probabilistic_sampler": {traces},
+		"filter":                {metrics},
+	},
+	"exporters": {
+		"otlp":                  allTypes,
+		"otlphttp":              allTypes,
+		"logging":               allTypes,
+		"file":                  allTypes,
+		"opencensus":            {traces, metrics},
+		"jaeger":                {traces},
+		"zipkin":                {traces},
+		"kafka":                 {traces},
+		"prometheus":            {metrics},
+		"prometheusremotewrite": {metrics},
+	},
+}
+
+// supports tells whether a component supports a data type, true when the
+// component is not known.
+func supports(section, typ, dataType string) bool {
+	types, ok := dataTypes[section][typ]
+	if !ok {
+		return true
+	}
+	for _, t := range types {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// port is a default port of a component.
+type port struct {
+	number int
+	udp    bool
+}
+
+// defaultPorts are the ports the known components listen on when no
+// endpoint is set, by section and component type. The ports of the
+// receivers with several protocols are by protocol.
+var defaultPorts = map[string]map[string]port{
+	"receivers": {
+		"opencensus": {number: 55678},
+		"zipkin":     {number: 9411},
+	},
+	"extensions": {
+		"health_check": {number: 13133},
+		"zpages":       {number: 55679},
+		"pprof":        {number: 1777},
+	},
+}
+
+var protocolPorts = map[string]map[string]port{
+	"otlp": {
+		"grpc": {number: 55680},
+		"http": {number: 55681},
+	},
+	"jaeger": {
+		"grpc":           {number: 14250},
+		"thrift_http":    {number: 14268},
+		"thrift_compact": {number: 6831, udp: true},
+		"thrift_binary":  {number: 6832, udp: true},
+	},
+}
+
+// servingExporters are the exporters listening on their endpoint, the
+// endpoint of the others is where they send to.
+var servingExporters = map[string]bool{"prometheus": true}
+
+// telemetryPort is the port the collector serves its own metrics on.
+const telemetryPort = 8888
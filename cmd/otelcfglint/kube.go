@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// file holds the documents of a file that are checked.
+type file struct {
+	collectors []*collector
+	// configMaps are the collector configurations of the ConfigMaps by name.
+	configMaps map[string][]*collector
+	workloads  []*workload
+	services   []*service
+}
+
+// workload is a Deployment, DaemonSet or StatefulSet.
+type workload struct {
+	kind, name string
+	labels     map[string]string
+	containers []podContainer
+	// ports are the ports of all the containers.
+	ports []containerPort
+}
+
+// podContainer is a container of a workload, the collector configurations
+// it mounts are compared with its ports only.
+type podContainer struct {
+	ports  []containerPort
+	probes []probe
+	// configMaps are the names of the ConfigMaps mounted.
+	configMaps []string
+}
+
+type containerPort struct {
+	port
+	name string
+	line int
+}
+
+// probe is the port of a liveness, readiness or startup probe, a number or
+// the name of a container port.
+type probe struct {
+	kind string
+	port *yaml.Node
+}
+
+type service struct {
+	name     string
+	selector map[string]string
+	ports    []servicePort
+}
+
+type servicePort struct {
+	port
+	// target is the targetPort, a number or the name of a container port,
+	// nil when it is the same as the port.
+	target *yaml.Node
+	line   int
+}
+
+// parseFile parses the documents of a file, collector configurations and
+// the Kubernetes objects they are deployed with. Other documents are
+// ignored.
+func parseFile(data []byte, r *report) (*file, error) {
+	f := &file{configMaps: map[string][]*collector{}}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err == io.EOF {
+			return f, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+		root := doc.Content[0]
+		switch kind := scalar(value(root, "kind")); kind {
+		case "ConfigMap":
+			f.parseConfigMap(root, r)
+		case "Deployment", "DaemonSet", "StatefulSet":
+			f.workloads = append(f.workloads, parseWorkload(kind, root))
+		case "Service":
+			f.services = append(f.services, parseService(root))
+		case "":
+			if isCollector(root) {
+				f.collectors = append(f.collectors, &collector{root: root})
+			}
+		}
+	}
+}
+
+// parseConfigMap adds the collector configurations of a ConfigMap.
+func (f *file) parseConfigMap(root *yaml.Node, r *report) {
+	name := scalar(path(root, "metadata", "name"))
+	entries(value(root, "data"), func(k, v *yaml.Node) {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(v.Value), &doc); err != nil {
+			if strings.Contains(v.Value, "receivers:") {
+				r.errorf(v.Line, "ConfigMap %q: %s: %v", name, k.Value, err)
+			}
+			return
+		}
+		if len(doc.Content) == 0 || !isCollector(doc.Content[0]) {
+			return
+		}
+		// The lines of a block scalar start after its indicator line.
+		c := &collector{root: doc.Content[0], offset: v.Line}
+		f.collectors = append(f.collectors, c)
+		f.configMaps[name] = append(f.configMaps[name], c)
+	})
+}
+
+func parseWorkload(kind string, root *yaml.Node) *workload {
+	w := &workload{
+		kind:   kind,
+		name:   scalar(path(root, "metadata", "name")),
+		labels: stringMap(path(root, "spec", "template", "metadata", "labels")),
+	}
+	spec := path(root, "spec", "template", "spec")
+	// configMaps are the ConfigMaps by volume name.
+	configMaps := map[string]string{}
+	for _, volume := range items(value(spec, "volumes")) {
+		if name := scalar(path(volume, "configMap", "name")); name != "" {
+			configMaps[scalar(value(volume, "name"))] = name
+		}
+	}
+	for _, container := range items(value(spec, "containers")) {
+		var c podContainer
+		for _, p := range items(value(container, "ports")) {
+			n, ok := intValue(value(p, "containerPort"))
+			if !ok {
+				continue
+			}
+			c.ports = append(c.ports, containerPort{
+				port: port{number: n, udp: scalar(value(p, "protocol")) == "UDP"},
+				name: scalar(value(p, "name")),
+				line: p.Line,
+			})
+		}
+		for _, kind := range []string{"livenessProbe", "readinessProbe", "startupProbe"} {
+			for _, handler := range []string{"httpGet", "tcpSocket"} {
+				if p := path(container, kind, handler, "port"); p != nil {
+					c.probes = append(c.probes, probe{kind: kind, port: p})
+				}
+			}
+		}
+		for _, mount := range items(value(container, "volumeMounts")) {
+			if name, ok := configMaps[scalar(value(mount, "name"))]; ok {
+				c.configMaps = append(c.configMaps, name)
+			}
+		}
+		w.containers = append(w.containers, c)
+		w.ports = append(w.ports, c.ports...)
+	}
+	return w
+}
+
+func parseService(root *yaml.Node) *service {
+	s := &service{
+		name:     scalar(path(root, "metadata", "name")),
+		selector: stringMap(path(root, "spec", "selector")),
+	}
+	for _, p := range items(path(root, "spec", "ports")) {
+		n, ok := intValue(value(p, "port"))
+		if !ok {
+			continue
+		}
+		s.ports = append(s.ports, servicePort{
+			port:   port{number: n, udp: scalar(value(p, "protocol")) == "UDP"},
+			target: value(p, "targetPort"),
+			line:   p.Line,
+		})
+	}
+	return s
+}
+
+// check checks the collector configurations, then the objects deploying
+// them.
+func (f *file) check(r *report) {
+	for _, c := range f.collectors {
+		c.check(r)
+	}
+	for _, w := range f.workloads {
+		f.checkWorkload(r, w)
+	}
+	for _, s := range f.services {
+		f.checkService(r, s)
+	}
+}
+
+// checkWorkload compares the ports of the containers of a workload with
+// those of the collector configurations they mount.
+func (f *file) checkWorkload(r *report, w *workload) {
+	seen := map[port]bool{}
+	for _, p := range w.ports {
+		if seen[p.port] {
+			r.errorf(p.line, "%s %q exposes port %d twice", w.kind, w.name, p.number)
+		}
+		seen[p.port] = true
+	}
+	for _, c := range w.containers {
+		f.checkContainer(r, w, c)
+	}
+}
+
+func (f *file) checkContainer(r *report, w *workload, pc podContainer) {
+	var collectors []*collector
+	for _, name := range pc.configMaps {
+		collectors = append(collectors, f.configMaps[name]...)
+	}
+	if len(collectors) == 0 {
+		return
+	}
+	exposed := map[port]bool{}
+	for _, p := range pc.ports {
+		exposed[p.port] = true
+	}
+	listening := map[port]bool{}
+	for _, c := range collectors {
+		for _, l := range c.listeners {
+			listening[l.port] = true
+		}
+	}
+	// The kubelet probes ports whether they are exposed or not.
+	probed := map[port]bool{}
+	for _, p := range pc.probes {
+		n, ok := resolve(pc.ports, p.port, false)
+		if !ok {
+			r.errorf(p.port.Line, "%s of %s %q: no container port named %q", p.kind, w.kind, w.name, p.port.Value)
+			continue
+		}
+		probed[port{number: n}] = true
+		if !listening[port{number: n}] {
+			r.errorf(p.port.Line, "%s of %s %q checks port %d but no component listens on it", p.kind, w.kind, w.name, n)
+		}
+	}
+	for _, c := range collectors {
+		for _, l := range c.listeners {
+			if !exposed[l.port] && !probed[l.port] {
+				r.warnf(l.line, "%s listens on port %d, which %s %q does not expose", l.component, l.number, w.kind, w.name)
+			}
+		}
+	}
+	for _, p := range pc.ports {
+		if !listening[p.port] {
+			r.warnf(p.line, "%s %q exposes port %d but no component listens on it", w.kind, w.name, p.number)
+		}
+	}
+}
+
+// resolve returns the number of a port given by number or by the name of
+// one of ports.
+func resolve(ports []containerPort, n *yaml.Node, udp bool) (int, bool) {
+	if number, ok := intValue(n); ok {
+		return number, true
+	}
+	for _, p := range ports {
+		if p.name == n.Value && p.udp == udp {
+			return p.number, true
+		}
+	}
+	return 0, false
+}
+
+// checkService checks that the workloads a Service selects expose the ports
+// it targets.
+func (f *file) checkService(r *report, s *service) {
+	seen := map[port]bool{}
+	for _, p := range s.ports {
+		if seen[p.port] {
+			r.errorf(p.line, "Service %q has port %d twice", s.name, p.number)
+		}
+		seen[p.port] = true
+	}
+	for _, w := range f.workloads {
+		if !selects(s.selector, w.labels) {
+			continue
+		}
+		exposed := map[port]bool{}
+		for _, p := range w.ports {
+			exposed[p.port] = true
+		}
+		for _, p := range s.ports {
+			target := p.number
+			if p.target != nil {
+				n, ok := resolve(w.ports, p.target, p.udp)
+				if !ok {
+					r.errorf(p.target.Line, "Service %q port %d targets port %q, which %s %q does not name", s.name, p.number, p.target.Value, w.kind, w.name)
+					continue
+				}
+				target = n
+			}
+			if !exposed[port{number: target, udp: p.udp}] {
+				r.errorf(p.line, "Service %q port %d targets port %d, which %s %q does not expose", s.name, p.number, target, w.kind, w.name)
+			}
+		}
+	}
+}
+
+// selects tells whether a Service selector matches the labels of pods.
+func selects(selector, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
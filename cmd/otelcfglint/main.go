@@ -0,0 +1,98 @@
+// otelcfglint checks OpenTelemetry Collector configurations before they are
+// deployed:
+//
+//	go run ./cmd/otelcfglint local.yaml otel-config.yaml
+//
+// A file is either a collector configuration or Kubernetes manifests, the
+// collector configurations in the ConfigMaps are checked as well. It reports
+// components used but not defined or defined but not used, components in
+// pipelines of a type they do not support, misplaced sections, scrape configs
+// without targets and ports that collide, or that the Deployments mounting a
+// configuration and the Services in front of them do not match. otelcfglint
+// exits with status 1 when there are errors, warnings alone do not fail.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Levels of the findings.
+const (
+	levelError   = "error"
+	levelWarning = "warning"
+)
+
+// finding is a problem found at a line of a file.
+type finding struct {
+	line  int
+	level string
+	msg   string
+}
+
+// report collects the findings of a file.
+type report struct {
+	findings []finding
+}
+
+func (r *report) errorf(line int, format string, args ...interface{}) {
+	r.findings = append(r.findings, finding{line: line, level: levelError, msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *report) warnf(line int, format string, args ...interface{}) {
+	r.findings = append(r.findings, finding{line: line, level: levelWarning, msg: fmt.Sprintf(format, args...)})
+}
+
+// lint returns the findings of a file sorted by line.
+func lint(data []byte) ([]finding, error) {
+	r := &report{}
+	f, err := parseFile(data, r)
+	if err != nil {
+		return nil, err
+	}
+	f.check(r)
+	sort.SliceStable(r.findings, func(i, j int) bool {
+		return r.findings[i].line < r.findings[j].line
+	})
+	return r.findings, nil
+}
+
+// printFindings writes the findings of a file and returns the number of
+// errors.
+func printFindings(w io.Writer, path string, findings []finding) int {
+	errors := 0
+	for _, f := range findings {
+		if f.level == levelError {
+			errors++
+		}
+		fmt.Fprintf(w, "%s:%d: %s: %s\n", path, f.line, f.level, f.msg)
+	}
+	return errors
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: otelcfglint file.yaml...")
+		os.Exit(2)
+	}
+	errors := 0
+	for _, path := range os.Args[1:] {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		findings, err := lint(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(2)
+		}
+		errors += printFindings(os.Stdout, path, findings)
+	}
+	if errors > 0 {
+		os.Exit(1)
+	}
+}
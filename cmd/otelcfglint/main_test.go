@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// findingStrings formats the findings like printFindings without the path.
+func findingStrings(t *testing.T, config string) []string {
+	t.Helper()
+	findings, err := lint([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, f := range findings {
+		res = append(res, fmt.Sprintf("%d: %s: %s", f.line, f.level, f.msg))
+	}
+	return res
+}
+
+func checkFindings(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValid(t *testing.T) {
+	config := `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+  prometheus:
+    config:
+      scrape_configs:
+        - job_name: self
+          static_configs:
+            - targets: ['localhost:8888']
+processors:
+  batch:
+exporters:
+  logging:
+  prometheus:
+    endpoint: 0.0.0.0:8889
+extensions:
+  health_check:
+service:
+  extensions: [health_check]
+  pipelines:
+    traces:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [logging]
+    metrics/prom:
+      receivers: [otlp, prometheus]
+      exporters: [prometheus]
+`
+	checkFindings(t, findingStrings(t, config), nil)
+}
+
+func TestComponents(t *testing.T) {
+	config := `receivers:
+  otlp:
+    protocols:
+      grpc:
+  jaeger:
+    protocols:
+      grpc:
+processors:
+  batch:
+exporters:
+  prometheus:
+    endpoint: 0.0.0.0:8889
+  logging:
+service:
+  extensions: [zpages]
+  pipelines:
+    metrics:
+      receivers: [otlp, jaeger]
+      exporters: [prometheus, otlp]
+    spans:
+      receivers: [otlp]
+      exporters: [logging]
+    logs:
+      receivers: [otlp]
+`
+	checkFindings(t, findingStrings(t, config), []string{
+		`9: warning: processor "batch" is defined but not used`,
+		`15: error: service.extensions: extension "zpages" is not defined`,
+		`18: error: pipeline "metrics": receiver "jaeger" does not support metrics`,
+		`19: error: pipeline "metrics": exporter "otlp" is not defined`,
+		`20: error: pipeline "spans": unknown type "spans", must be traces, metrics or logs`,
+		`23: error: pipeline "logs" has no exporters`,
+	})
+}
+
+func TestPorts(t *testing.T) {
+	config := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: conf
+data:
+  config.yaml: |
+    receivers:
+      otlp:
+        protocols:
+          grpc:
+            endpoint: 0.0.0.0:9411
+      zipkin:
+    exporters:
+      logging:
+    service:
+      pipelines:
+        traces:
+          receivers: [otlp, zipkin]
+          exporters: [logging]
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: collector
+spec:
+  selector:
+    app: collector
+  ports:
+  - port: 9411
+  - port: 4317
+    targetPort: otlp
+  - port: 8888
+    targetPort: 18888
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: collector
+spec:
+  template:
+    metadata:
+      labels:
+        app: collector
+    spec:
+      containers:
+      - name: collector
+        ports:
+        - containerPort: 9411
+        - containerPort: 8888
+        - containerPort: 55679
+        livenessProbe:
+          httpGet:
+            port: 13133
+        volumeMounts:
+        - name: conf
+          mountPath: /conf
+      volumes:
+      - name: conf
+        configMap:
+          name: conf
+`
+	checkFindings(t, findingStrings(t, config), []string{
+		`12: error: receiver "otlp" (grpc) and receiver "zipkin" both listen on port 9411`,
+		`31: error: Service "collector" port 4317 targets port "otlp", which Deployment "collector" does not name`,
+		`32: error: Service "collector" port 8888 targets port 18888, which Deployment "collector" does not expose`,
+		`50: warning: Deployment "collector" exposes port 55679 but no component listens on it`,
+		`53: error: livenessProbe of Deployment "collector" checks port 13133 but no component listens on it`,
+	})
+}
+
+// TestSidecar checks that a collector configuration is only compared with
+// the ports of the container mounting it, and that the endpoints exporters
+// send to are not taken for listening ports.
+func TestSidecar(t *testing.T) {
+	config := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: conf
+data:
+  config.yaml: |
+    receivers:
+      otlp:
+        protocols:
+          grpc:
+            endpoint: 0.0.0.0:55680
+    exporters:
+      otlp:
+        endpoint: backend:4317
+    service:
+      pipelines:
+        traces:
+          receivers: [otlp]
+          exporters: [otlp]
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    metadata:
+      labels:
+        app: app
+    spec:
+      containers:
+      - name: app
+        ports:
+        - containerPort: 17000
+      - name: collector
+        ports:
+        - containerPort: 55680
+        - containerPort: 8888
+        volumeMounts:
+        - name: conf
+          mountPath: /conf
+      volumes:
+      - name: conf
+        configMap:
+          name: conf
+`
+	checkFindings(t, findingStrings(t, config), nil)
+}
+
+// TestRepoConfigs checks that the mistakes of the configurations of the
+// repository are found.
+func TestRepoConfigs(t *testing.T) {
+	data, err := ioutil.ReadFile("../../otel-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := findingStrings(t, string(data))
+	for _, want := range []string{
+		`20: error: unknown top-level key "prometheus", should it be indented under receivers?`,
+		`23: error: receiver "prometheus": scrape config "otel-collector" has no targets`,
+		`28: warning: processor "memory_limiter" is defined but not used`,
+		`39: error: service.extensions: extension "zpages" is not defined`,
+		`42: error: pipeline "traces": receiver "opencensus" is not defined`,
+		`46: error: pipeline "metrics": receiver "prometheus" is not defined`,
+		`18: warning: exporter "prometheus" listens on port 8889, which Deployment "otel-collector" does not expose`,
+		`111: warning: Deployment "otel-collector" exposes port 55679 but no component listens on it`,
+	} {
+		found := false
+		for _, f := range findings {
+			found = found || f == want
+		}
+		if !found {
+			t.Errorf("missing finding %q in\n%s", want, strings.Join(findings, "\n"))
+		}
+	}
+
+	var buf bytes.Buffer
+	if errors := printFindings(&buf, "otel-config.yaml", []finding{{line: 3, level: levelError, msg: "m"}, {line: 4, level: levelWarning, msg: "w"}}); errors != 1 {
+		t.Errorf("got %d errors, want 1", errors)
+	}
+	if got, want := buf.String(), "otel-config.yaml:3: error: m\notel-config.yaml:4: warning: w\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestGeneratedManifests checks that the manifests generated by
+// pkg/manifests are consistent.
+func TestGeneratedManifests(t *testing.T) {
+	paths, err := filepath.Glob("../../pkg/manifests/testdata/*.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no generated manifests: %v", err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findings := findingStrings(t, string(data)); len(findings) > 0 {
+			t.Errorf("%s:\n%s", path, strings.Join(findings, "\n"))
+		}
+	}
+}
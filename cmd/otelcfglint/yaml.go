@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lookup returns the key and value nodes of a mapping entry, nil when n is
+// not a mapping or has no such key.
+func lookup(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// value returns the value node of a mapping entry.
+func value(n *yaml.Node, key string) *yaml.Node {
+	_, v := lookup(n, key)
+	return v
+}
+
+// path returns the value node at a path of mapping keys.
+func path(n *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		if n = value(n, key); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// entries calls f with the keys and values of a mapping in order.
+func entries(n *yaml.Node, f func(k, v *yaml.Node)) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		f(n.Content[i], n.Content[i+1])
+	}
+}
+
+// items returns the items of a sequence, nil for other nodes.
+func items(n *yaml.Node) []*yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return n.Content
+}
+
+// scalar returns the value of a scalar node, "" for other nodes.
+func scalar(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+// isNull tells whether a node is missing or an empty value, like the
+// "grpc:" of a protocol enabled with its defaults.
+func isNull(n *yaml.Node) bool {
+	return n == nil || n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+// isEmpty tells whether a node is null or an empty mapping or sequence.
+func isEmpty(n *yaml.Node) bool {
+	return isNull(n) || (n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode) && len(n.Content) == 0
+}
+
+// stringMap returns the string values of a mapping such as labels.
+func stringMap(n *yaml.Node) map[string]string {
+	m := map[string]string{}
+	entries(n, func(k, v *yaml.Node) {
+		m[k.Value] = scalar(v)
+	})
+	return m
+}
+
+// intValue returns the value of an integer scalar.
+func intValue(n *yaml.Node) (int, bool) {
+	if n == nil || n.Kind != yaml.ScalarNode || n.Tag != "!!int" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(n.Value)
+	return i, err == nil
+}
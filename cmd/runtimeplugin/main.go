@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"sync"
 	"time"
 
+	"github.com/skonto/test-otel/pkg/promserver"
 	"go.opentelemetry.io/contrib/instrumentation/runtime"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/metric/prometheus"
@@ -27,12 +27,24 @@ func initMeter() {
 	if err != nil {
 		log.Panicf("failed to initialize prometheus exporter %v", err)
 	}
-	http.HandleFunc("/metrics", exporter.ServeHTTP)
+	srv, err := promserver.New(exporter, append([]promserver.Option{
+		promserver.WithAddress(":9090"),
+		promserver.WithPath("/metrics"),
+		promserver.WithMeterProvider(otel.GetMeterProvider()),
+	}, promserver.OptionsFromEnv()...)...)
+	if err != nil {
+		log.Panicf("failed to create prometheus server %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		log.Panicf("failed to start prometheus server %v", err)
+	}
 	go func() {
-		_ = http.ListenAndServe(":9090", nil)
+		if err := <-srv.Err(); err != nil {
+			log.Fatal(err)
+		}
 	}()
 
-	fmt.Println("Prometheus server running on :9090")
+	fmt.Printf("Prometheus server running on %s\n", srv.URL())
 }
 
 func main() {
@@ -3,10 +3,15 @@ module github.com/skonto/test-otel
 go 1.14
 
 require (
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.15.0
 	go.opentelemetry.io/contrib/instrumentation/runtime v0.16.0
 	go.opentelemetry.io/otel v0.16.0
 	go.opentelemetry.io/otel/exporters/metric/prometheus v0.16.0
 	go.opentelemetry.io/otel/exporters/otlp v0.16.0
 	go.opentelemetry.io/otel/sdk v0.16.0
-	google.golang.org/grpc v1.34.0
+	go.opentelemetry.io/proto/otlp v0.7.0
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
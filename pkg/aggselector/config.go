@@ -0,0 +1,96 @@
+package aggselector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Aggregation names accepted in a Config.
+const (
+	Exact          = "exact"
+	Histogram      = "histogram"
+	MinMaxSumCount = "minmaxsumcount"
+	LastValue      = "lastvalue"
+	Sum            = "sum"
+)
+
+// Config describes which aggregation each instrument gets.
+//
+// Rules are evaluated in order and the first one matching an instrument
+// decides its aggregation. ValueRecorders that no rule matches get Default,
+// other instruments keep their natural aggregation: lastvalue for
+// ValueObservers and sum for the rest.
+type Config struct {
+	// Default is the aggregation of ValueRecorders without a rule,
+	// minmaxsumcount if empty.
+	Default string `json:"default,omitempty"`
+	// HistogramBoundaries are the bucket boundaries of histogram
+	// aggregations, DefaultHistogramBoundaries if empty.
+	HistogramBoundaries []float64 `json:"histogram_boundaries,omitempty"`
+	Rules               []Rule    `json:"rules,omitempty"`
+}
+
+// Rule selects an aggregation for the instruments matching both its name
+// pattern and one of its kinds.
+type Rule struct {
+	// Name is a path.Match pattern, eg. "http.server.*". Empty matches
+	// every name.
+	Name string `json:"name,omitempty"`
+	// Kinds restricts the rule to instrument kinds, eg. "valuerecorder"
+	// or "sumobserver". Empty matches every kind the aggregation applies
+	// to: only sum applies to counters and sum observers.
+	Kinds       []string `json:"kinds,omitempty"`
+	Aggregation string   `json:"aggregation"`
+}
+
+// LoadConfig reads a JSON encoded Config from a file.
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, fmt.Errorf("aggselector: %w", err)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("aggselector: %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+var instrumentKinds = map[string]metric.InstrumentKind{
+	"counter":           metric.CounterInstrumentKind,
+	"updowncounter":     metric.UpDownCounterInstrumentKind,
+	"valuerecorder":     metric.ValueRecorderInstrumentKind,
+	"sumobserver":       metric.SumObserverInstrumentKind,
+	"updownsumobserver": metric.UpDownSumObserverInstrumentKind,
+	"valueobserver":     metric.ValueObserverInstrumentKind,
+}
+
+// parseKind accepts the lower case kind names, with or without the
+// InstrumentKind suffix of metric.InstrumentKind.String().
+func parseKind(name string) (metric.InstrumentKind, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), "instrumentkind")
+	if kind, ok := instrumentKinds[name]; ok {
+		return kind, nil
+	}
+	return 0, fmt.Errorf("aggselector: unknown instrument kind %q", name)
+}
+
+func validAggregation(name string) error {
+	switch name {
+	case Exact, Histogram, MinMaxSumCount, LastValue, Sum:
+		return nil
+	}
+	return fmt.Errorf("aggselector: unknown aggregation %q", name)
+}
+
+func validPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("aggselector: invalid name pattern %q: %w", pattern, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package aggselector provides an export.AggregatorSelector that picks the
+// aggregation of every instrument from configuration, by name pattern and
+// instrument kind, instead of using the same one for all the instruments of
+// a kind like the selectors of the SDK's simple package do. Hot
+// ValueRecorders can use a cheap aggregation while exact points are only
+// kept where they are needed.
+package aggselector
+
+import (
+	"fmt"
+	"path"
+
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/exact"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/lastvalue"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/minmaxsumcount"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/sum"
+)
+
+// DefaultHistogramBoundaries are the bucket boundaries used when the
+// configuration has none, the defaults of the Prometheus client libraries
+// in milliseconds, the unit the instruments of this repository record.
+var DefaultHistogramBoundaries = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+type rule struct {
+	name        string
+	kinds       map[metric.InstrumentKind]bool
+	aggregation string
+}
+
+// Selector is an export.AggregatorSelector driven by a Config.
+type Selector struct {
+	defaultAggregation string
+	boundaries         []float64
+	rules              []rule
+}
+
+var _ export.AggregatorSelector = (*Selector)(nil)
+
+// New validates cfg and returns a selector applying it.
+func New(cfg Config) (*Selector, error) {
+	s := &Selector{
+		defaultAggregation: MinMaxSumCount,
+		boundaries:         DefaultHistogramBoundaries,
+	}
+	if cfg.Default != "" {
+		if err := validAggregation(cfg.Default); err != nil {
+			return nil, err
+		}
+		s.defaultAggregation = cfg.Default
+	}
+	if len(cfg.HistogramBoundaries) > 0 {
+		s.boundaries = cfg.HistogramBoundaries
+	}
+	for _, r := range cfg.Rules {
+		if err := validAggregation(r.Aggregation); err != nil {
+			return nil, err
+		}
+		if err := validPattern(r.Name); err != nil {
+			return nil, err
+		}
+		compiled := rule{name: r.Name, aggregation: r.Aggregation}
+		for _, k := range r.Kinds {
+			kind, err := parseKind(k)
+			if err != nil {
+				return nil, err
+			}
+			if compiled.kinds == nil {
+				compiled.kinds = map[metric.InstrumentKind]bool{}
+			}
+			if !compatible(kind, r.Aggregation) {
+				return nil, fmt.Errorf("aggselector: aggregation %q does not apply to %s instruments", r.Aggregation, k)
+			}
+			compiled.kinds[kind] = true
+		}
+		s.rules = append(s.rules, compiled)
+	}
+	return s, nil
+}
+
+// Aggregation returns the name of the aggregation selected for an
+// instrument.
+func (s *Selector) Aggregation(desc *metric.Descriptor) string {
+	for _, r := range s.rules {
+		if r.matches(desc) {
+			return r.aggregation
+		}
+	}
+	switch desc.InstrumentKind() {
+	case metric.ValueRecorderInstrumentKind:
+		return s.defaultAggregation
+	case metric.ValueObserverInstrumentKind:
+		return LastValue
+	default:
+		return Sum
+	}
+}
+
+// AggregatorFor implements export.AggregatorSelector.
+func (s *Selector) AggregatorFor(desc *metric.Descriptor, aggPtrs ...*export.Aggregator) {
+	switch s.Aggregation(desc) {
+	case Exact:
+		aggs := exact.New(len(aggPtrs))
+		for i := range aggPtrs {
+			*aggPtrs[i] = &aggs[i]
+		}
+	case Histogram:
+		aggs := histogram.New(len(aggPtrs), desc, s.boundaries)
+		for i := range aggPtrs {
+			*aggPtrs[i] = &aggs[i]
+		}
+	case MinMaxSumCount:
+		aggs := minmaxsumcount.New(len(aggPtrs), desc)
+		for i := range aggPtrs {
+			*aggPtrs[i] = &aggs[i]
+		}
+	case LastValue:
+		aggs := lastvalue.New(len(aggPtrs))
+		for i := range aggPtrs {
+			*aggPtrs[i] = &aggs[i]
+		}
+	default:
+		aggs := sum.New(len(aggPtrs))
+		for i := range aggPtrs {
+			*aggPtrs[i] = &aggs[i]
+		}
+	}
+}
+
+// compatible tells whether an aggregation applies to an instrument kind.
+// Adding instruments, counters and sum observers, are summed: their other
+// aggregations would be exported as gauges or distributions of increments.
+func compatible(kind metric.InstrumentKind, aggregation string) bool {
+	return aggregation == Sum || kind.Grouping()
+}
+
+func (r rule) matches(desc *metric.Descriptor) bool {
+	kind := desc.InstrumentKind()
+	if r.kinds != nil && !r.kinds[kind] {
+		return false
+	}
+	// Rules without kinds only match the kinds their aggregation applies to.
+	if !compatible(kind, r.aggregation) {
+		return false
+	}
+	if r.name == "" {
+		return true
+	}
+	// Patterns are validated by New.
+	ok, _ := path.Match(r.name, desc.Name())
+	return ok
+}
@@ -0,0 +1,150 @@
+package aggselector
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+)
+
+var testConfig = Config{
+	Default: Histogram,
+	Rules: []Rule{
+		{Name: "test_app.go.*", Kinds: []string{"valueobserver"}, Aggregation: Sum},
+		{Name: "request.latency", Aggregation: Exact},
+		{Name: "*.size", Kinds: []string{"valuerecorder", "ValueObserverInstrumentKind"}, Aggregation: MinMaxSumCount},
+		{Name: "*.usage", Aggregation: LastValue},
+		{Kinds: []string{"updownsumobserver"}, Aggregation: Sum},
+	},
+}
+
+func TestAggregation(t *testing.T) {
+	s, err := New(testConfig)
+	if err != nil {
+		t.Fatal("invalid config:", err)
+	}
+	tests := []struct {
+		name string
+		kind metric.InstrumentKind
+		want string
+	}{
+		{name: "test_app.go.heap_alloc", kind: metric.ValueObserverInstrumentKind, want: Sum},
+		{name: "test_app.go.num_gc", kind: metric.SumObserverInstrumentKind, want: Sum},
+		{name: "request.latency", kind: metric.ValueRecorderInstrumentKind, want: Exact},
+		{name: "response.size", kind: metric.ValueRecorderInstrumentKind, want: MinMaxSumCount},
+		{name: "queue.size", kind: metric.ValueObserverInstrumentKind, want: MinMaxSumCount},
+		{name: "queue.size", kind: metric.CounterInstrumentKind, want: Sum},
+		{name: "cpu.usage", kind: metric.ValueRecorderInstrumentKind, want: LastValue},
+		{name: "cpu.usage", kind: metric.CounterInstrumentKind, want: Sum},
+		{name: "disk.usage", kind: metric.UpDownSumObserverInstrumentKind, want: Sum},
+		{name: "db.latency", kind: metric.ValueRecorderInstrumentKind, want: Histogram},
+		{name: "temperature", kind: metric.ValueObserverInstrumentKind, want: LastValue},
+	}
+	for _, tc := range tests {
+		desc := metric.NewDescriptor(tc.name, tc.kind, number.Float64Kind)
+		if got := s.Aggregation(&desc); got != tc.want {
+			t.Errorf("%s %s: got %s, want %s", tc.kind, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAggregatorFor(t *testing.T) {
+	s, err := New(testConfig)
+	if err != nil {
+		t.Fatal("invalid config:", err)
+	}
+	proc := processor.New(s, export.CumulativeExportKindSelector())
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	meter := metric.Must(cont.MeterProvider().Meter("test"))
+	ctx := context.Background()
+	meter.NewFloat64ValueRecorder("request.latency").Record(ctx, 1)
+	meter.NewFloat64ValueRecorder("db.latency").Record(ctx, 1)
+	meter.NewInt64ValueRecorder("response.size").Record(ctx, 1)
+	meter.NewInt64Counter("request.count").Add(ctx, 1)
+	if err := cont.Collect(ctx); err != nil {
+		t.Fatal("failed to collect:", err)
+	}
+
+	want := map[string]aggregation.Kind{
+		"request.latency": aggregation.ExactKind,
+		"db.latency":      aggregation.HistogramKind,
+		"response.size":   aggregation.MinMaxSumCountKind,
+		"request.count":   aggregation.SumKind,
+	}
+	got := map[string]aggregation.Kind{}
+	err = proc.CheckpointSet().ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		got[r.Descriptor().Name()] = r.Aggregation().Kind()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, kind := range want {
+		if got[name] != kind {
+			t.Errorf("%s: got aggregation %s, want %s", name, got[name], kind)
+		}
+	}
+	// The default boundaries apply to histograms.
+	err = proc.CheckpointSet().ForEach(export.CumulativeExportKindSelector(), func(r export.Record) error {
+		if h, ok := r.Aggregation().(aggregation.Histogram); ok {
+			buckets, err := h.Histogram()
+			if err != nil {
+				return err
+			}
+			if len(buckets.Boundaries) != len(DefaultHistogramBoundaries) {
+				t.Errorf("got boundaries %v, want %v", buckets.Boundaries, DefaultHistogramBoundaries)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "default", cfg: Config{Default: "median"}},
+		{name: "aggregation", cfg: Config{Rules: []Rule{{Name: "x", Aggregation: "gauge"}}}},
+		{name: "kind", cfg: Config{Rules: []Rule{{Kinds: []string{"gauge"}, Aggregation: Sum}}}},
+		{name: "pattern", cfg: Config{Rules: []Rule{{Name: "[", Aggregation: Sum}}}},
+		{name: "histogram counter", cfg: Config{Rules: []Rule{{Kinds: []string{"counter"}, Aggregation: Histogram}}}},
+		{name: "lastvalue sumobserver", cfg: Config{Rules: []Rule{{Kinds: []string{"valueobserver", "sumobserver"}, Aggregation: LastValue}}}},
+	}
+	for _, tc := range tests {
+		if _, err := New(tc.cfg); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aggselector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "aggregation.json")
+	raw := `{"default": "histogram", "histogram_boundaries": [1, 10], "rules": [{"name": "request.*", "kinds": ["valuerecorder"], "aggregation": "exact"}]}`
+	if err := ioutil.WriteFile(filename, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal("failed to load config:", err)
+	}
+	if cfg.Default != Histogram || len(cfg.HistogramBoundaries) != 2 || len(cfg.Rules) != 1 || cfg.Rules[0].Aggregation != Exact {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
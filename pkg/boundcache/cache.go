@@ -0,0 +1,211 @@
+// Package boundcache records through bound instruments without the callers
+// managing Bind and Unbind. Recording on a bound instrument avoids building
+// a label set per call, which dominates the cost of unbound recording.
+//
+// The wrappers keep the bound instrument of every label set they see, up to
+// a maximum size. Bindings idle for longer than a TTL are unbound by a
+// background goroutine, and when the cache is full an approximation of the
+// least recently used one is unbound to make room, unless it was used since
+// the last tick of the cache clock, every TTL/4. Label sets that find no
+// room record unbound.
+//
+// Label sets are cached by their labels in the order given, callers
+// recording the same labels in different orders get one binding per order.
+// Calls with more than MaxLabels labels are not cached and record unbound.
+package boundcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// DefaultMaxSize is the number of label sets kept bound by default.
+	DefaultMaxSize = 1000
+	// DefaultTTL is how long an unused binding is kept by default.
+	DefaultTTL = 5 * time.Minute
+	// MaxLabels is the largest number of labels of a cached label set.
+	MaxLabels = 4
+)
+
+// evictionSamples is the number of bindings sampled to pick the one to
+// evict when the cache is full. Like Redis' approximated LRU this avoids
+// ordering all the bindings on every insert.
+const evictionSamples = 5
+
+// key identifies a label set without allocating, KeyValues are comparable.
+type key struct {
+	n   int
+	kvs [MaxLabels]label.KeyValue
+}
+
+type unbinder interface {
+	Unbind()
+}
+
+type entry struct {
+	bound unbinder
+	// lastUsed is the cache clock when the binding was last used.
+	lastUsed int64
+}
+
+// cache is the state shared by the instrument wrappers. Recording holds
+// the read lock, evictions unbind under the write lock so that no binding
+// is used after being unbound.
+type cache struct {
+	cfg  config
+	bind func(kvs []label.KeyValue) unbinder
+
+	// now is a coarse clock advanced by the janitor, cheaper to read on
+	// every call than time.Now.
+	now int64
+
+	mu      sync.RWMutex
+	entries map[key]*entry
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newCache(bind func(kvs []label.KeyValue) unbinder, opts ...Option) *cache {
+	c := &cache{
+		cfg:     newConfig(opts...),
+		bind:    bind,
+		now:     time.Now().UnixNano(),
+		entries: map[key]*entry{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// makeKey returns the key of labels, false if there are too many labels.
+func makeKey(labels []label.KeyValue) (key, bool) {
+	var k key
+	if len(labels) > MaxLabels {
+		return k, false
+	}
+	k.n = copy(k.kvs[:], labels)
+	return k, true
+}
+
+// with calls f with the binding of k while holding the read lock. It
+// returns false if k is not cached, the cache is closed or full of
+// bindings in use.
+func (c *cache) with(k key, f func(unbinder)) bool {
+	c.mu.RLock()
+	if e, ok := c.entries[k]; ok {
+		atomic.StoreInt64(&e.lastUsed, atomic.LoadInt64(&c.now))
+		f(e.bound)
+		c.mu.RUnlock()
+		return true
+	}
+	c.mu.RUnlock()
+	return c.insert(k, f)
+}
+
+func (c *cache) insert(k key, f func(unbinder)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	e, ok := c.entries[k]
+	if !ok {
+		if len(c.entries) >= c.cfg.maxSize && !c.evictOldest() {
+			return false
+		}
+		kvs := make([]label.KeyValue, k.n)
+		copy(kvs, k.kvs[:k.n])
+		e = &entry{bound: c.bind(kvs)}
+		c.entries[k] = e
+	}
+	atomic.StoreInt64(&e.lastUsed, atomic.LoadInt64(&c.now))
+	f(e.bound)
+	return true
+}
+
+// evictOldest unbinds the least recently used of a few bindings, map
+// iteration starts at a random entry. Bindings used since the last clock
+// tick are not evicted, a burst of new label sets records unbound instead
+// of churning the bindings in use. Called with the write lock held.
+func (c *cache) evictOldest() bool {
+	var (
+		oldest   key
+		lastUsed int64
+		sampled  int
+	)
+	for k, e := range c.entries {
+		if used := atomic.LoadInt64(&e.lastUsed); sampled == 0 || used < lastUsed {
+			oldest, lastUsed = k, used
+		}
+		if sampled++; sampled == evictionSamples {
+			break
+		}
+	}
+	if lastUsed >= atomic.LoadInt64(&c.now) {
+		return false
+	}
+	c.entries[oldest].bound.Unbind()
+	delete(c.entries, oldest)
+	return true
+}
+
+// sweep advances the clock to now and unbinds the bindings idle for
+// longer than the TTL.
+func (c *cache) sweep(now int64) {
+	atomic.StoreInt64(&c.now, now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now-atomic.LoadInt64(&e.lastUsed) > int64(c.cfg.ttl) {
+			e.bound.Unbind()
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *cache) run() {
+	defer close(c.done)
+	// The clock resolution bounds how late idle bindings are evicted.
+	ticker := time.NewTicker(c.cfg.ttl / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.sweep(now.UnixNano())
+		}
+	}
+}
+
+// len returns the number of cached bindings.
+func (c *cache) len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// close stops the janitor and unbinds every binding, later calls record
+// unbound.
+func (c *cache) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	for k, e := range c.entries {
+		e.bound.Unbind()
+		delete(c.entries, k)
+	}
+	c.mu.Unlock()
+	close(c.stop)
+	<-c.done
+}
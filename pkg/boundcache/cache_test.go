@@ -0,0 +1,157 @@
+package boundcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+func TestRecord(t *testing.T) {
+	p := metrictest.NewPipeline()
+	ctx := context.Background()
+	counter := NewInt64Counter(metric.Must(p.Meter()).NewInt64Counter("requests"))
+	defer counter.Close()
+	latency := NewFloat64ValueRecorder(metric.Must(p.Meter()).NewFloat64ValueRecorder("latency"))
+	defer latency.Close()
+	many := []label.KeyValue{label.Int("a", 1), label.Int("b", 2), label.Int("c", 3), label.Int("d", 4), label.Int("e", 5)}
+	for i := 0; i < 3; i++ {
+		counter.Add(ctx, 1, label.String("path", "/a"))
+		counter.Add(ctx, 2, label.String("path", "/b"), label.String("host", "x"))
+		counter.Add(ctx, 3)
+		counter.Add(ctx, 4, many...)
+		latency.Record(ctx, 1, label.String("path", "/a"))
+	}
+	if n := counter.Len(); n != 3 {
+		t.Errorf("got %d bound label sets, want 3 as too many labels are not cached", n)
+	}
+	want := map[string]float64{
+		"requests{path=/a}":             3,
+		"requests{host=x,path=/b}":      6,
+		"requests{}":                    9,
+		"requests{a=1,b=2,c=3,d=4,e=5}": 12,
+		"latency{path=/a}":              3,
+		"latency{path=/a}.count":        3,
+	}
+	if got := p.Values(t); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	p := metrictest.NewPipeline()
+	ctx := context.Background()
+	// No larger than the number of sampled bindings, eviction is exact.
+	counter := NewInt64Counter(metric.Must(p.Meter()).NewInt64Counter("requests"), WithMaxSize(evictionSamples))
+	defer counter.Close()
+	for i := 0; i < evictionSamples; i++ {
+		atomic.StoreInt64(&counter.cache.now, int64(i))
+		counter.Add(ctx, 1, label.Int("id", i))
+	}
+	// Label set 0 is used again, 1 becomes the oldest one.
+	atomic.StoreInt64(&counter.cache.now, evictionSamples)
+	counter.Add(ctx, 1, label.Int("id", 0))
+	counter.Add(ctx, 1, label.Int("id", evictionSamples))
+	if n := counter.Len(); n != evictionSamples {
+		t.Errorf("got %d bound label sets, want %d", n, evictionSamples)
+	}
+	if k, _ := makeKey([]label.KeyValue{label.Int("id", 1)}); counter.cache.entries[k] != nil {
+		t.Error("the least recently used label set is still bound")
+	}
+	// Evicted label sets are bound again and keep their values.
+	counter.Add(ctx, 1, label.Int("id", 1))
+	got := p.Values(t)
+	if got["requests{id=0}"] != 2 || got["requests{id=1}"] != 2 || len(got) != evictionSamples+1 {
+		t.Errorf("unexpected values %v", got)
+	}
+}
+
+func TestEvictIdle(t *testing.T) {
+	p := metrictest.NewPipeline()
+	ctx := context.Background()
+	counter := NewInt64Counter(metric.Must(p.Meter()).NewInt64Counter("requests"), WithTTL(time.Minute))
+	defer counter.Close()
+	start := atomic.LoadInt64(&counter.cache.now)
+	counter.Add(ctx, 1, label.String("path", "/a"))
+	counter.cache.sweep(start + int64(45*time.Second))
+	counter.Add(ctx, 1, label.String("path", "/b"))
+	counter.cache.sweep(start + int64(90*time.Second))
+	if n := counter.Len(); n != 1 {
+		t.Errorf("got %d bound label sets, want only the recently used one", n)
+	}
+	counter.Add(ctx, 1, label.String("path", "/a"))
+	want := map[string]float64{"requests{path=/a}": 2, "requests{path=/b}": 1}
+	if got := p.Values(t); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClose(t *testing.T) {
+	p := metrictest.NewPipeline()
+	ctx := context.Background()
+	counter := NewInt64Counter(metric.Must(p.Meter()).NewInt64Counter("requests"))
+	counter.Add(ctx, 1, label.String("path", "/a"))
+	counter.Close()
+	counter.Close()
+	if n := counter.Len(); n != 0 {
+		t.Errorf("got %d bound label sets after close", n)
+	}
+	counter.Add(ctx, 1, label.String("path", "/a"))
+	want := map[string]float64{"requests{path=/a}": 2}
+	if got := p.Values(t); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestConcurrentEviction checks that no value is lost while bindings are
+// evicted and collections run concurrently.
+func TestConcurrentEviction(t *testing.T) {
+	p := metrictest.NewPipeline()
+	ctx := context.Background()
+	counter := NewInt64Counter(metric.Must(p.Meter()).NewInt64Counter("requests"), WithMaxSize(4))
+	defer counter.Close()
+	const workers, adds = 8, 2000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				counter.Add(ctx, 1, label.Int("id", (w+i)%8))
+				if i%100 == 0 {
+					counter.cache.sweep(time.Now().Add(time.Hour).UnixNano())
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = p.Controller.Collect(ctx)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-collected
+
+	var total float64
+	for _, v := range p.Values(t) {
+		total += v
+	}
+	if total != workers*adds {
+		t.Errorf("got a total of %v, want %d", total, workers*adds)
+	}
+}
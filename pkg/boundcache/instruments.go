@@ -0,0 +1,86 @@
+package boundcache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Int64Counter records on an Int64Counter through cached bindings.
+type Int64Counter struct {
+	inst  metric.Int64Counter
+	cache *cache
+}
+
+// NewInt64Counter wraps a counter. Close releases its bindings.
+func NewInt64Counter(inst metric.Int64Counter, opts ...Option) *Int64Counter {
+	return &Int64Counter{
+		inst: inst,
+		cache: newCache(func(kvs []label.KeyValue) unbinder {
+			return inst.Bind(kvs...)
+		}, opts...),
+	}
+}
+
+// Add adds value to the counter with the given labels.
+func (c *Int64Counter) Add(ctx context.Context, value int64, labels ...label.KeyValue) {
+	if k, ok := makeKey(labels); ok && c.cache.with(k, func(b unbinder) {
+		b.(metric.BoundInt64Counter).Add(ctx, value)
+	}) {
+		return
+	}
+	// Copied so that labels does not escape, and does not allocate, on the
+	// cached path.
+	c.inst.Add(ctx, value, append([]label.KeyValue(nil), labels...)...)
+}
+
+// Len returns the number of label sets currently bound.
+func (c *Int64Counter) Len() int {
+	return c.cache.len()
+}
+
+// Close unbinds every label set and stops evicting idle ones. Later calls
+// to Add record unbound.
+func (c *Int64Counter) Close() {
+	c.cache.close()
+}
+
+// Float64ValueRecorder records on a Float64ValueRecorder through cached
+// bindings.
+type Float64ValueRecorder struct {
+	inst  metric.Float64ValueRecorder
+	cache *cache
+}
+
+// NewFloat64ValueRecorder wraps a ValueRecorder. Close releases its
+// bindings.
+func NewFloat64ValueRecorder(inst metric.Float64ValueRecorder, opts ...Option) *Float64ValueRecorder {
+	return &Float64ValueRecorder{
+		inst: inst,
+		cache: newCache(func(kvs []label.KeyValue) unbinder {
+			return inst.Bind(kvs...)
+		}, opts...),
+	}
+}
+
+// Record records value with the given labels.
+func (r *Float64ValueRecorder) Record(ctx context.Context, value float64, labels ...label.KeyValue) {
+	if k, ok := makeKey(labels); ok && r.cache.with(k, func(b unbinder) {
+		b.(metric.BoundFloat64ValueRecorder).Record(ctx, value)
+	}) {
+		return
+	}
+	r.inst.Record(ctx, value, append([]label.KeyValue(nil), labels...)...)
+}
+
+// Len returns the number of label sets currently bound.
+func (r *Float64ValueRecorder) Len() int {
+	return r.cache.len()
+}
+
+// Close unbinds every label set and stops evicting idle ones. Later calls
+// to Record record unbound.
+func (r *Float64ValueRecorder) Close() {
+	r.cache.close()
+}
@@ -0,0 +1,57 @@
+package boundcache
+
+import "time"
+
+// config contains the settings of a cache.
+type config struct {
+	maxSize int
+	ttl     time.Duration
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		maxSize: DefaultMaxSize,
+		ttl:     DefaultTTL,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the cache.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithMaxSize sets the number of label sets kept bound, DefaultMaxSize by
+// default. When full the least recently used bindings are evicted. Non
+// positive values are ignored.
+func WithMaxSize(n int) Option {
+	return maxSizeOption(n)
+}
+
+// WithTTL sets how long a binding is kept without being used, DefaultTTL by
+// default. Non positive values are ignored.
+func WithTTL(ttl time.Duration) Option {
+	return ttlOption(ttl)
+}
+
+type maxSizeOption int
+
+type ttlOption time.Duration
+
+// Apply implements Option.
+func (o maxSizeOption) Apply(c *config) {
+	if o > 0 {
+		c.maxSize = int(o)
+	}
+}
+
+func (o ttlOption) Apply(c *config) {
+	if o > 0 {
+		c.ttl = time.Duration(o)
+	}
+}
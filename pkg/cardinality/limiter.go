@@ -0,0 +1,162 @@
+// Package cardinality caps the number of distinct label sets each
+// instrument exports, so that a label recorded with unbounded values, eg. a
+// path carrying IDs, cannot explode the number of series in the backend.
+//
+// The Limiter wraps the processor of a pipeline. The first label sets of an
+// instrument, up to the limit, are exported as usual. The following ones are
+// folded into a single series labeled with the overflow label, or dropped.
+// Each offending instrument is reported once through otel.Handle.
+package cardinality
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// DefaultLimit is the number of distinct label sets allowed per instrument
+// by default.
+const DefaultLimit = 1000
+
+// OverflowLabel labels the series label sets over the limit are folded
+// into.
+var OverflowLabel = label.Bool("otel.metric.overflow", true)
+
+// Actions taken on label sets over the limit, reported as the action label
+// of cardinality.overflow.
+const (
+	ActionFolded  = "folded"
+	ActionDropped = "dropped"
+)
+
+type instrumentState struct {
+	limit int
+	seen  map[label.Distinct]struct{}
+	// collection are the distinct label sets over the limit in the
+	// current collection, added to overflowed once it finishes so that the
+	// limiter does not keep every label set it refuses.
+	collection map[label.Distinct]struct{}
+	overflowed int64
+	reported   bool
+}
+
+// Limiter is an export.Checkpointer enforcing a label set limit on the
+// accumulations it passes to the wrapped Checkpointer.
+type Limiter struct {
+	export.Checkpointer
+	cfg      config
+	overflow label.Set
+
+	mu          sync.Mutex
+	instruments map[*metric.Descriptor]*instrumentState
+}
+
+var _ export.Checkpointer = (*Limiter)(nil)
+
+// New wraps a Checkpointer, usually a processor from the SDK's
+// processor/basic package.
+func New(next export.Checkpointer, opts ...Option) (*Limiter, error) {
+	l := &Limiter{
+		Checkpointer: next,
+		cfg:          newConfig(opts...),
+		instruments:  map[*metric.Descriptor]*instrumentState{},
+	}
+	l.overflow = label.NewSet(l.cfg.overflow)
+	if err := l.registerMetrics(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Process implements export.Processor. Accumulations with a label set over
+// the limit of their instrument are folded or dropped, the wrapped
+// processor merges the folded ones into a single series.
+func (l *Limiter) Process(accum export.Accumulation) error {
+	desc := accum.Descriptor()
+	distinct := accum.Labels().Equivalent()
+
+	l.mu.Lock()
+	state, ok := l.instruments[desc]
+	if !ok {
+		limit := l.cfg.limit
+		if n, ok := l.cfg.limits[desc.Name()]; ok {
+			limit = n
+		}
+		state = &instrumentState{
+			limit:      limit,
+			seen:       map[label.Distinct]struct{}{},
+			collection: map[label.Distinct]struct{}{},
+		}
+		l.instruments[desc] = state
+	}
+	if _, ok := state.seen[distinct]; ok || len(state.seen) < state.limit {
+		state.seen[distinct] = struct{}{}
+		l.mu.Unlock()
+		return l.Checkpointer.Process(accum)
+	}
+	state.collection[distinct] = struct{}{}
+	report := !state.reported
+	state.reported = true
+	l.mu.Unlock()
+
+	if report {
+		otel.Handle(fmt.Errorf("cardinality: instrument %s exceeded %d label sets, further label sets are %s",
+			desc.Name(), state.limit, l.action()))
+	}
+	if l.cfg.drop {
+		return nil
+	}
+	return l.Checkpointer.Process(export.NewAccumulation(desc, &l.overflow, accum.Resource(), accum.Aggregator()))
+}
+
+// FinishCollection implements export.Checkpointer, it counts the label sets
+// over the limit in the collection.
+func (l *Limiter) FinishCollection() error {
+	l.mu.Lock()
+	for _, state := range l.instruments {
+		if len(state.collection) > 0 {
+			state.overflowed += int64(len(state.collection))
+			state.collection = map[label.Distinct]struct{}{}
+		}
+	}
+	l.mu.Unlock()
+	return l.Checkpointer.FinishCollection()
+}
+
+func (l *Limiter) action() string {
+	if l.cfg.drop {
+		return ActionDropped
+	}
+	return ActionFolded
+}
+
+// registerMetrics reports cardinality.overflow{instrument,action}, the
+// number of distinct label sets folded or dropped per instrument in each
+// collection, summed over the collections.
+func (l *Limiter) registerMetrics() error {
+	if l.cfg.meterProvider == nil {
+		return nil
+	}
+	instrumentKey := label.Key("instrument")
+	actionKey := label.Key("action")
+	_, err := l.cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/cardinality").NewInt64SumObserver(
+		"cardinality.overflow",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for desc, state := range l.instruments {
+				if !state.reported {
+					continue
+				}
+				result.Observe(state.overflowed, instrumentKey.String(desc.Name()), actionKey.String(l.action()))
+			}
+		},
+		metric.WithDescription("The number of label sets over the cardinality limit of an instrument, per collection"),
+	)
+	return err
+}
@@ -0,0 +1,150 @@
+package cardinality
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// errors records what the limiter reports through otel.Handle, the global
+// error handler can only be set once.
+var errors = &errorRecorder{}
+
+func init() {
+	otel.SetErrorHandler(errors)
+}
+
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) Handle(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *errorRecorder) count(substr string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, err := range r.errs {
+		if strings.Contains(err.Error(), substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// newPipeline returns a pipeline limited by a limiter with opts.
+func newPipeline(t *testing.T, opts ...Option) metrictest.Pipeline {
+	return metrictest.NewPipeline(metrictest.WithWrap(func(next export.Checkpointer) export.Checkpointer {
+		limit, err := New(next, opts...)
+		if err != nil {
+			t.Fatal("failed to create limiter:", err)
+		}
+		return limit
+	}))
+}
+
+func TestFold(t *testing.T) {
+	p := newPipeline(t, WithLimit(2))
+	ctx := context.Background()
+	counter := metric.Must(p.Meter()).NewInt64Counter("fold.count")
+	// The accumulator does not process label sets in recording order, the
+	// first ones are collected before the limit is reached.
+	counter.Add(ctx, 1, label.String("path", "/api/0"))
+	counter.Add(ctx, 2, label.String("path", "/api/1"))
+	p.Sums(t, "fold.count")
+	for i := 2; i < 5; i++ {
+		counter.Add(ctx, int64(i+1), label.String("path", fmt.Sprint("/api/", i)))
+	}
+	want := map[string]float64{
+		"path=/api/0":               1,
+		"path=/api/1":               2,
+		"otel.metric.overflow=true": 3 + 4 + 5,
+	}
+	got := p.Sums(t, "fold.count")
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Admitted label sets stay admitted, the others keep folding.
+	counter.Add(ctx, 10, label.String("path", "/api/1"))
+	counter.Add(ctx, 10, label.String("path", "/api/9"))
+	want["path=/api/1"] += 10
+	want["otel.metric.overflow=true"] += 10
+	got = p.Sums(t, "fold.count")
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := errors.count("fold.count exceeded 2 label sets"); n != 1 {
+		t.Errorf("instrument reported %d times, want once", n)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	p := newPipeline(t, WithLimit(1), WithDropOverflow())
+	ctx := context.Background()
+	counter := metric.Must(p.Meter()).NewInt64Counter("drop.count")
+	counter.Add(ctx, 1, label.String("path", "/a"))
+	p.Sums(t, "drop.count")
+	counter.Add(ctx, 2, label.String("path", "/b"))
+	want := map[string]float64{"path=/a": 1}
+	if got := p.Sums(t, "drop.count"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if n := errors.count("drop.count exceeded 1 label sets, further label sets are dropped"); n != 1 {
+		t.Errorf("instrument reported %d times, want once", n)
+	}
+}
+
+func TestInstrumentLimit(t *testing.T) {
+	p := newPipeline(t, WithLimit(1), WithInstrumentLimit("wide.count", 3), WithOverflowLabel(label.String("path", "other")))
+	ctx := context.Background()
+	wide := metric.Must(p.Meter()).NewInt64Counter("wide.count")
+	narrow := metric.Must(p.Meter()).NewInt64Counter("narrow.count")
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		wide.Add(ctx, 1, label.String("path", path))
+		narrow.Add(ctx, 1, label.String("path", path))
+	}
+	if got := p.Sums(t, "wide.count"); len(got) != 4 || got["path=other"] != 1 {
+		t.Errorf("wide.count: got %v, want 3 label sets and one folded", got)
+	}
+	if got := p.Sums(t, "narrow.count"); len(got) != 2 || got["path=other"] != 3 {
+		t.Errorf("narrow.count: got %v, want 1 label set and three folded", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	self := newPipeline(t)
+	p := newPipeline(t, WithLimit(1), WithMeterProvider(self.MeterProvider()))
+	ctx := context.Background()
+	counter := metric.Must(p.Meter()).NewInt64Counter("metrics.count")
+	// The label sets over the limit are counted once per collection.
+	counter.Add(ctx, 1, label.Int("id", 0))
+	p.Sums(t, "metrics.count")
+	for i := 1; i < 4; i++ {
+		counter.Add(ctx, 1, label.Int("id", i))
+		counter.Add(ctx, 1, label.Int("id", i))
+	}
+	p.Sums(t, "metrics.count")
+	for i := 1; i < 3; i++ {
+		counter.Add(ctx, 1, label.Int("id", i))
+	}
+	p.Sums(t, "metrics.count")
+
+	want := map[string]float64{"action=folded,instrument=metrics.count": 3 + 2}
+	if got := self.Sums(t, "cardinality.overflow"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package cardinality
+
+import (
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// config contains the settings of the limiter.
+type config struct {
+	limit         int
+	limits        map[string]int
+	overflow      label.KeyValue
+	drop          bool
+	meterProvider metric.MeterProvider
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		limit:    DefaultLimit,
+		limits:   map[string]int{},
+		overflow: OverflowLabel,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the limiter.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithLimit sets the number of distinct label sets allowed per instrument,
+// DefaultLimit by default. Non positive values are ignored.
+func WithLimit(n int) Option {
+	return limitOption(n)
+}
+
+// WithInstrumentLimit overrides the limit of the instruments with the given
+// name. Non positive values are ignored.
+func WithInstrumentLimit(name string, n int) Option {
+	return instrumentLimitOption{name: name, limit: n}
+}
+
+// WithOverflowLabel sets the label that replaces the labels of the folded
+// label sets, OverflowLabel by default.
+func WithOverflowLabel(kv label.KeyValue) Option {
+	return overflowLabelOption(kv)
+}
+
+// WithDropOverflow drops the label sets over the limit instead of folding
+// them into the overflow series.
+func WithDropOverflow() Option {
+	return dropOption{}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the
+// folded and dropped label sets. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type limitOption int
+
+type instrumentLimitOption struct {
+	name  string
+	limit int
+}
+
+type overflowLabelOption label.KeyValue
+
+type dropOption struct{}
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o limitOption) Apply(c *config) {
+	if o > 0 {
+		c.limit = int(o)
+	}
+}
+
+func (o instrumentLimitOption) Apply(c *config) {
+	if o.limit > 0 {
+		c.limits[o.name] = o.limit
+	}
+}
+
+func (o overflowLabelOption) Apply(c *config) {
+	c.overflow = label.KeyValue(o)
+}
+
+func (dropOption) Apply(c *config) {
+	c.drop = true
+}
+
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
@@ -0,0 +1,160 @@
+package checkpoint
+
+import (
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/number"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+)
+
+// ErrUnsupportedAggregation is returned by Freeze for aggregations that do
+// not implement any of the interfaces of the aggregation package.
+var ErrUnsupportedAggregation = fmt.Errorf("unsupported aggregation")
+
+// Freeze copies the current state of an aggregation into an immutable value
+// that stays valid after the processor moves on to the next collection.
+// The most specific interface is tested first, see aggregation.Kind.
+func Freeze(agg aggregation.Aggregation) (aggregation.Aggregation, error) {
+	switch a := agg.(type) {
+	case aggregation.Points:
+		pts, err := a.Points()
+		if err != nil {
+			return nil, err
+		}
+		cp := make([]aggregation.Point, len(pts))
+		copy(cp, pts)
+		return NewPoints(a.Kind(), cp), nil
+
+	case aggregation.Histogram:
+		count, err := a.Count()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		b, err := a.Histogram()
+		if err != nil {
+			return nil, err
+		}
+		buckets := aggregation.Buckets{
+			Boundaries: append([]float64(nil), b.Boundaries...),
+			Counts:     append([]uint64(nil), b.Counts...),
+		}
+		return NewHistogram(a.Kind(), sum, count, buckets), nil
+
+	case aggregation.MinMaxSumCount:
+		min, err := a.Min()
+		if err != nil {
+			return nil, err
+		}
+		max, err := a.Max()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		count, err := a.Count()
+		if err != nil {
+			return nil, err
+		}
+		return NewMinMaxSumCount(a.Kind(), min, max, sum, count), nil
+
+	case aggregation.LastValue:
+		n, t, err := a.LastValue()
+		if err != nil {
+			return nil, err
+		}
+		return NewLastValue(a.Kind(), n, t), nil
+
+	case aggregation.Sum:
+		n, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		return NewSum(a.Kind(), n), nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedAggregation, agg)
+}
+
+// NewSum returns an immutable aggregation.Sum.
+func NewSum(kind aggregation.Kind, sum number.Number) aggregation.Sum {
+	return sumAgg{kind: kind, sum: sum}
+}
+
+// NewLastValue returns an immutable aggregation.LastValue.
+func NewLastValue(kind aggregation.Kind, value number.Number, t time.Time) aggregation.LastValue {
+	return lastValueAgg{kind: kind, value: value, time: t}
+}
+
+// NewMinMaxSumCount returns an immutable aggregation.MinMaxSumCount.
+func NewMinMaxSumCount(kind aggregation.Kind, min, max, sum number.Number, count uint64) aggregation.MinMaxSumCount {
+	return minMaxSumCountAgg{kind: kind, min: min, max: max, sum: sum, count: count}
+}
+
+// NewHistogram returns an immutable aggregation.Histogram.
+func NewHistogram(kind aggregation.Kind, sum number.Number, count uint64, buckets aggregation.Buckets) aggregation.Histogram {
+	return histogramAgg{kind: kind, sum: sum, count: count, buckets: buckets}
+}
+
+// NewPoints returns an immutable aggregation.Points. Like the exact
+// aggregator it also exposes the number of points.
+func NewPoints(kind aggregation.Kind, points []aggregation.Point) aggregation.Points {
+	return pointsAgg{kind: kind, points: points}
+}
+
+type sumAgg struct {
+	kind aggregation.Kind
+	sum  number.Number
+}
+
+func (a sumAgg) Kind() aggregation.Kind      { return a.kind }
+func (a sumAgg) Sum() (number.Number, error) { return a.sum, nil }
+
+type lastValueAgg struct {
+	kind  aggregation.Kind
+	value number.Number
+	time  time.Time
+}
+
+func (a lastValueAgg) Kind() aggregation.Kind { return a.kind }
+func (a lastValueAgg) LastValue() (number.Number, time.Time, error) {
+	return a.value, a.time, nil
+}
+
+type minMaxSumCountAgg struct {
+	kind          aggregation.Kind
+	min, max, sum number.Number
+	count         uint64
+}
+
+func (a minMaxSumCountAgg) Kind() aggregation.Kind      { return a.kind }
+func (a minMaxSumCountAgg) Min() (number.Number, error) { return a.min, nil }
+func (a minMaxSumCountAgg) Max() (number.Number, error) { return a.max, nil }
+func (a minMaxSumCountAgg) Sum() (number.Number, error) { return a.sum, nil }
+func (a minMaxSumCountAgg) Count() (uint64, error)      { return a.count, nil }
+
+type histogramAgg struct {
+	kind    aggregation.Kind
+	sum     number.Number
+	count   uint64
+	buckets aggregation.Buckets
+}
+
+func (a histogramAgg) Kind() aggregation.Kind                  { return a.kind }
+func (a histogramAgg) Sum() (number.Number, error)             { return a.sum, nil }
+func (a histogramAgg) Count() (uint64, error)                  { return a.count, nil }
+func (a histogramAgg) Histogram() (aggregation.Buckets, error) { return a.buckets, nil }
+
+type pointsAgg struct {
+	kind   aggregation.Kind
+	points []aggregation.Point
+}
+
+func (a pointsAgg) Kind() aggregation.Kind               { return a.kind }
+func (a pointsAgg) Points() ([]aggregation.Point, error) { return a.points, nil }
+func (a pointsAgg) Count() (uint64, error)               { return uint64(len(a.points)), nil }
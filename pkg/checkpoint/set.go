@@ -0,0 +1,81 @@
+// Package checkpoint provides an in-memory export.CheckpointSet that owns a
+// copy of its records. Exporters that hand a checkpoint over to another
+// goroutine, or that rewrite records before export, use it to keep the data
+// valid once the controller releases the processor's checkpoint.
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+)
+
+// ErrExportKindMismatch is returned by ForEach when the selector asks for a
+// different export kind than the one the records were computed with.
+var ErrExportKindMismatch = fmt.Errorf("export kind does not match the checkpoint")
+
+// Set is an immutable export.CheckpointSet.
+type Set struct {
+	sync.RWMutex
+
+	records []export.Record
+	kinds   []export.ExportKind
+}
+
+var _ export.CheckpointSet = (*Set)(nil)
+
+// New returns a Set holding the given records, computed with the export
+// kind returned by selector. Records must carry immutable aggregations,
+// see Freeze.
+func New(selector export.ExportKindSelector, records ...export.Record) *Set {
+	s := &Set{}
+	for _, r := range records {
+		s.add(selector, r)
+	}
+	return s
+}
+
+// Snapshot copies all records of cps, as seen through selector, into a new
+// Set. The caller must hold the read lock of cps, as the controller does
+// while calling an exporter.
+func Snapshot(cps export.CheckpointSet, selector export.ExportKindSelector) (*Set, error) {
+	s := &Set{}
+	err := cps.ForEach(selector, func(r export.Record) error {
+		agg, err := Freeze(r.Aggregation())
+		if err != nil {
+			return err
+		}
+		s.add(selector, export.NewRecord(r.Descriptor(), r.Labels(), r.Resource(), agg, r.StartTime(), r.EndTime()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Set) add(selector export.ExportKindSelector, r export.Record) {
+	s.records = append(s.records, r)
+	s.kinds = append(s.kinds, selector.ExportKindFor(r.Descriptor(), r.Aggregation().Kind()))
+}
+
+// Len returns the number of records in the set.
+func (s *Set) Len() int {
+	return len(s.records)
+}
+
+// ForEach implements export.CheckpointSet.
+func (s *Set) ForEach(selector export.ExportKindSelector, f func(export.Record) error) error {
+	for i, r := range s.records {
+		if want := selector.ExportKindFor(r.Descriptor(), r.Aggregation().Kind()); !s.kinds[i].Includes(want) {
+			return fmt.Errorf("%s: %v instead of %v: %w", r.Descriptor().Name(), want, s.kinds[i], ErrExportKindMismatch)
+		}
+		if err := f(r); err != nil && !errors.Is(err, aggregation.ErrNoData) {
+			return err
+		}
+	}
+	return nil
+}
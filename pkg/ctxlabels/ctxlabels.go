@@ -0,0 +1,78 @@
+// Package ctxlabels attaches labels to a context.Context once, eg. in an
+// HTTP middleware, so that every measurement recorded with that context is
+// labeled with them without the call sites rebuilding them:
+//
+//	ctx = ctxlabels.ContextWithLabels(ctx, label.String("route", "/api/items/{id}"))
+//	...
+//	requests := ctxlabels.NewInt64Counter(counter)
+//	requests.Add(ctx, 1, label.String("outcome", "hit"))
+//
+// Precedence, from lowest to highest: the labels of the outer contexts, the
+// labels of the inner contexts, the labels given to Add or Record. A key set
+// again replaces its previous value.
+//
+// Recording with a context without labels passes the call through to the
+// wrapped instrument and does not allocate more than recording on it
+// directly. With context labels, the merged labels take one allocation.
+package ctxlabels
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+type labelsKey struct{}
+
+// ContextWithLabels returns a copy of parent carrying kvs on top of the
+// labels it already carries, kvs replace the values of the keys set by
+// outer contexts.
+func ContextWithLabels(parent context.Context, kvs ...label.KeyValue) context.Context {
+	if len(kvs) == 0 {
+		return parent
+	}
+	outer := Labels(parent)
+	// The slices stored in contexts are never modified, contexts derived
+	// from the same parent share it.
+	merged := make([]label.KeyValue, 0, len(outer)+len(kvs))
+	for _, kv := range outer {
+		if !contains(kvs, kv.Key) {
+			merged = append(merged, kv)
+		}
+	}
+	for i, kv := range kvs {
+		if !contains(kvs[i+1:], kv.Key) {
+			merged = append(merged, kv)
+		}
+	}
+	return context.WithValue(parent, labelsKey{}, merged)
+}
+
+// Labels returns the labels carried by ctx, one per key. The returned slice
+// must not be modified.
+func Labels(ctx context.Context) []label.KeyValue {
+	kvs, _ := ctx.Value(labelsKey{}).([]label.KeyValue)
+	return kvs
+}
+
+// merge returns the labels of ctx followed by labels, labels win over the
+// context labels with the same key as label sets keep the last value of a
+// key. labels is returned as is when ctx carries no labels.
+func merge(ctx context.Context, labels []label.KeyValue) []label.KeyValue {
+	kvs := Labels(ctx)
+	if len(kvs) == 0 {
+		return labels
+	}
+	merged := make([]label.KeyValue, 0, len(kvs)+len(labels))
+	merged = append(merged, kvs...)
+	return append(merged, labels...)
+}
+
+func contains(kvs []label.KeyValue, k label.Key) bool {
+	for _, kv := range kvs {
+		if kv.Key == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package ctxlabels
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+func TestContextWithLabels(t *testing.T) {
+	ctx := context.Background()
+	if got := Labels(ctx); len(got) != 0 {
+		t.Fatalf("got labels %v in an empty context", got)
+	}
+	outer := ContextWithLabels(ctx, label.String("host", "a"), label.String("path", "/"))
+	inner := ContextWithLabels(outer, label.String("path", "/items"), label.String("tenant", "x"), label.String("tenant", "y"))
+	sibling := ContextWithLabels(outer, label.String("path", "/list"))
+
+	for _, c := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{outer, "host=a,path=/"},
+		{inner, "host=a,path=/items,tenant=y"},
+		{sibling, "host=a,path=/list"},
+	} {
+		set := label.NewSet(Labels(c.ctx)...)
+		if got := set.Encoded(label.DefaultEncoder()); got != c.want || set.Len() != len(Labels(c.ctx)) {
+			t.Errorf("got labels %v, want %s", Labels(c.ctx), c.want)
+		}
+	}
+	if ContextWithLabels(outer) != outer {
+		t.Error("adding no labels created a context")
+	}
+}
+
+func TestInstruments(t *testing.T) {
+	p := metrictest.NewPipeline()
+	meter := metric.Must(p.Meter())
+	intCounter := NewInt64Counter(meter.NewInt64Counter("int.counter"))
+	floatCounter := NewFloat64Counter(meter.NewFloat64UpDownCounter("float.counter"))
+	intRecorder := NewInt64ValueRecorder(meter.NewInt64ValueRecorder("int.recorder"))
+	floatRecorder := NewFloat64ValueRecorder(meter.NewFloat64ValueRecorder("float.recorder"))
+
+	ctx := ContextWithLabels(context.Background(), label.String("path", "/items"), label.String("outcome", "ok"))
+	for _, ctx := range []context.Context{ctx, context.Background()} {
+		intCounter.Add(ctx, 1, label.String("outcome", "hit"))
+		floatCounter.Add(ctx, 2, label.String("outcome", "hit"))
+		intRecorder.Record(ctx, 3, label.String("outcome", "hit"))
+		floatRecorder.Record(ctx, 4, label.String("outcome", "hit"))
+	}
+
+	got := map[string]float64{}
+	for name, value := range p.Values(t) {
+		if !strings.HasSuffix(name, ".count") {
+			got[name] = value
+		}
+	}
+	want := map[string]float64{}
+	for name, value := range map[string]float64{"int.counter": 1, "float.counter": 2, "int.recorder": 3, "float.recorder": 4} {
+		// The outcome given to Add or Record wins over the context's.
+		want[name+"{outcome=hit,path=/items}"] = value
+		want[name+"{outcome=hit}"] = value
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	cont := controller.New(processor.New(simple.NewWithInexpensiveDistribution(), export.CumulativeExportKindSelector()))
+	counter := metric.Must(cont.MeterProvider().Meter("test")).NewInt64Counter("counter")
+	wrapped := NewInt64Counter(counter)
+	ctx := context.Background()
+	direct := testing.AllocsPerRun(100, func() {
+		counter.Add(ctx, 1, label.String("outcome", "hit"))
+	})
+	through := testing.AllocsPerRun(100, func() {
+		wrapped.Add(ctx, 1, label.String("outcome", "hit"))
+	})
+	if through != direct {
+		t.Errorf("got %v allocations through the wrapper, want %v as without context labels", through, direct)
+	}
+}
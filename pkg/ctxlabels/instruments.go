@@ -0,0 +1,94 @@
+package ctxlabels
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/label"
+)
+
+// Int64Adder is implemented by the int64 counters and up-down counters of
+// the metric API, and by the boundcache wrappers.
+type Int64Adder interface {
+	Add(ctx context.Context, value int64, labels ...label.KeyValue)
+}
+
+// Float64Adder is implemented by the float64 counters and up-down counters
+// of the metric API.
+type Float64Adder interface {
+	Add(ctx context.Context, value float64, labels ...label.KeyValue)
+}
+
+// Int64Recorder is implemented by metric.Int64ValueRecorder.
+type Int64Recorder interface {
+	Record(ctx context.Context, value int64, labels ...label.KeyValue)
+}
+
+// Float64Recorder is implemented by metric.Float64ValueRecorder and the
+// boundcache wrapper.
+type Float64Recorder interface {
+	Record(ctx context.Context, value float64, labels ...label.KeyValue)
+}
+
+// Int64Counter adds the context labels to the measurements of an
+// Int64Adder.
+type Int64Counter struct {
+	inst Int64Adder
+}
+
+// NewInt64Counter wraps a counter or up-down counter.
+func NewInt64Counter(inst Int64Adder) Int64Counter {
+	return Int64Counter{inst: inst}
+}
+
+// Add adds value with the labels of ctx and the given labels.
+func (c Int64Counter) Add(ctx context.Context, value int64, labels ...label.KeyValue) {
+	c.inst.Add(ctx, value, merge(ctx, labels)...)
+}
+
+// Float64Counter adds the context labels to the measurements of a
+// Float64Adder.
+type Float64Counter struct {
+	inst Float64Adder
+}
+
+// NewFloat64Counter wraps a counter or up-down counter.
+func NewFloat64Counter(inst Float64Adder) Float64Counter {
+	return Float64Counter{inst: inst}
+}
+
+// Add adds value with the labels of ctx and the given labels.
+func (c Float64Counter) Add(ctx context.Context, value float64, labels ...label.KeyValue) {
+	c.inst.Add(ctx, value, merge(ctx, labels)...)
+}
+
+// Int64ValueRecorder adds the context labels to the measurements of an
+// Int64Recorder.
+type Int64ValueRecorder struct {
+	inst Int64Recorder
+}
+
+// NewInt64ValueRecorder wraps a ValueRecorder.
+func NewInt64ValueRecorder(inst Int64Recorder) Int64ValueRecorder {
+	return Int64ValueRecorder{inst: inst}
+}
+
+// Record records value with the labels of ctx and the given labels.
+func (r Int64ValueRecorder) Record(ctx context.Context, value int64, labels ...label.KeyValue) {
+	r.inst.Record(ctx, value, merge(ctx, labels)...)
+}
+
+// Float64ValueRecorder adds the context labels to the measurements of a
+// Float64Recorder.
+type Float64ValueRecorder struct {
+	inst Float64Recorder
+}
+
+// NewFloat64ValueRecorder wraps a ValueRecorder.
+func NewFloat64ValueRecorder(inst Float64Recorder) Float64ValueRecorder {
+	return Float64ValueRecorder{inst: inst}
+}
+
+// Record records value with the labels of ctx and the given labels.
+func (r Float64ValueRecorder) Record(ctx context.Context, value float64, labels ...label.KeyValue) {
+	r.inst.Record(ctx, value, merge(ctx, labels)...)
+}
@@ -0,0 +1,416 @@
+// Package diskqueue provides an otlp.ProtocolDriver that writes every export
+// request to a directory before sending it, so that metrics pushed while the
+// collector is unreachable are not lost. Requests are replayed in order once
+// the collector is back, including the ones left over by a previous run of
+// the process. The queue is bounded both in size and in age, the oldest
+// requests are dropped first.
+package diskqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/transform"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	metricsdk "go.opentelemetry.io/otel/sdk/export/metric"
+	tracesdk "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/unit"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultMaxBytes is the default cap on the size of the queue, 64MiB.
+	DefaultMaxBytes = 64 << 20
+	// DefaultMaxAge is how long a request is kept by default.
+	DefaultMaxAge = time.Hour
+	// DefaultSendTimeout is the default timeout of a single send.
+	DefaultSendTimeout = 10 * time.Second
+	// DefaultRetryInterval is the default wait after the first failed send.
+	DefaultRetryInterval = time.Second
+	// DefaultMaxRetryInterval is the default cap on the wait between sends.
+	DefaultMaxRetryInterval = 30 * time.Second
+
+	fileExt = ".pb"
+	tmpExt  = ".tmp"
+)
+
+// Reasons a request is dropped, reported as the reason label of
+// diskqueue.dropped.
+const (
+	DropReasonSize     = "size"
+	DropReasonAge      = "age"
+	DropReasonRejected = "rejected"
+)
+
+// ErrTracesUnsupported is returned when spans are handed to the driver.
+// Use otlp.NewSplitDriver to send traces with another driver.
+var ErrTracesUnsupported = errors.New("diskqueue: traces are not supported")
+
+// entry is a request stored on disk.
+type entry struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// Driver is an otlp.ProtocolDriver that queues requests on disk.
+type Driver struct {
+	cfg    config
+	dir    string
+	sender Sender
+
+	// writeMu serializes appends so that requests are queued in the
+	// order they were exported.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	entries []entry
+	bytes   int64
+	nextSeq uint64
+	dropped map[string]int64
+
+	notifyCh chan struct{}
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+var _ otlp.ProtocolDriver = (*Driver)(nil)
+
+// NewDriver creates a driver that keeps its queue in dir, which is created
+// if needed, and sends the queued requests with sender. A directory must
+// not be shared by several drivers.
+func NewDriver(dir string, sender Sender, opts ...Option) *Driver {
+	return &Driver{
+		cfg:      newConfig(opts...),
+		dir:      dir,
+		sender:   sender,
+		dropped:  map[string]int64{},
+		notifyCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start implements otlp.ProtocolDriver. It loads the requests left in the
+// directory by a previous run and starts sending them.
+func (d *Driver) Start(ctx context.Context) error {
+	if err := os.MkdirAll(d.dir, 0o700); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	if err := d.load(); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	if err := d.registerMetrics(); err != nil {
+		return err
+	}
+	go d.run()
+	return nil
+}
+
+// Stop implements otlp.ProtocolDriver. Requests that were not sent yet stay
+// on disk for the next run.
+func (d *Driver) Stop(ctx context.Context) error {
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+	})
+	select {
+	case <-d.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExportMetrics implements otlp.ProtocolDriver. The checkpoint is serialized
+// and appended to the queue, it returns once the request is on disk.
+func (d *Driver) ExportMetrics(ctx context.Context, cps metricsdk.CheckpointSet, selector metricsdk.ExportKindSelector) error {
+	rms, err := transform.CheckpointSet(ctx, selector, cps)
+	if err != nil {
+		return err
+	}
+	if len(rms) == 0 {
+		return nil
+	}
+	raw, err := proto.Marshal(&colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: rms})
+	if err != nil {
+		return err
+	}
+	return d.append(raw)
+}
+
+// ExportTraces implements otlp.ProtocolDriver.
+func (d *Driver) ExportTraces(ctx context.Context, ss []*tracesdk.SpanSnapshot) error {
+	if len(ss) == 0 {
+		return nil
+	}
+	return ErrTracesUnsupported
+}
+
+// Depth returns the number of queued requests.
+func (d *Driver) Depth() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries)
+}
+
+// Dropped returns the number of requests dropped for the given reason.
+func (d *Driver) Dropped(reason string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped[reason]
+}
+
+// load indexes the requests found in the directory and removes the
+// leftovers of interrupted writes.
+func (d *Driver) load() error {
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, tmpExt) {
+			_ = os.Remove(filepath.Join(d.dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		d.entries = append(d.entries, entry{seq: seq, size: info.Size(), created: info.ModTime()})
+		d.bytes += info.Size()
+		if seq >= d.nextSeq {
+			d.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].seq < d.entries[j].seq })
+	d.enforceSizeLocked()
+	return nil
+}
+
+// append writes a request to disk and wakes up the sender. The request is
+// written to a temporary file first so that a crash never leaves a partial
+// request behind.
+func (d *Driver) append(raw []byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	size := int64(len(raw))
+	d.mu.Lock()
+	if size > d.cfg.maxBytes {
+		d.dropped[DropReasonSize]++
+		d.mu.Unlock()
+		return fmt.Errorf("diskqueue: request of %d bytes exceeds the queue size", size)
+	}
+	seq := d.nextSeq
+	d.nextSeq++
+	d.mu.Unlock()
+
+	name := d.path(seq)
+	if err := writeFile(name+tmpExt, raw); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+	if err := os.Rename(name+tmpExt, name); err != nil {
+		return fmt.Errorf("diskqueue: %w", err)
+	}
+
+	d.mu.Lock()
+	d.entries = append(d.entries, entry{seq: seq, size: size, created: time.Now()})
+	d.bytes += size
+	d.enforceSizeLocked()
+	d.mu.Unlock()
+
+	select {
+	case d.notifyCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func writeFile(name string, raw []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// enforceSizeLocked drops the oldest requests until the queue fits in
+// maxBytes.
+func (d *Driver) enforceSizeLocked() {
+	for d.bytes > d.cfg.maxBytes && len(d.entries) > 0 {
+		d.dropLocked(DropReasonSize)
+	}
+}
+
+// expireLocked drops the requests older than maxAge.
+func (d *Driver) expireLocked(now time.Time) {
+	for len(d.entries) > 0 && now.Sub(d.entries[0].created) > d.cfg.maxAge {
+		d.dropLocked(DropReasonAge)
+	}
+}
+
+// dropLocked removes the oldest request.
+func (d *Driver) dropLocked(reason string) {
+	d.dropped[reason]++
+	d.removeLocked(d.entries[0].seq)
+}
+
+// removeLocked removes a request from the index and from disk, unless it
+// was already removed eg. because the queue overflowed while it was sent.
+func (d *Driver) removeLocked(seq uint64) {
+	for i, e := range d.entries {
+		if e.seq != seq {
+			continue
+		}
+		d.entries = append(d.entries[:i], d.entries[i+1:]...)
+		d.bytes -= e.size
+		if err := os.Remove(d.path(seq)); err != nil && !os.IsNotExist(err) {
+			otel.Handle(fmt.Errorf("diskqueue: %w", err))
+		}
+		return
+	}
+}
+
+// head returns the oldest request that has not expired.
+func (d *Driver) head() (entry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expireLocked(time.Now())
+	if len(d.entries) == 0 {
+		return entry{}, false
+	}
+	return d.entries[0], true
+}
+
+func (d *Driver) path(seq uint64) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%020d%s", seq, fileExt))
+}
+
+// run sends the queued requests one at a time, in order. A failed send is
+// retried with an exponential backoff, the requests behind it wait.
+func (d *Driver) run() {
+	defer close(d.doneCh)
+	wait := d.cfg.retryInterval
+	for {
+		e, ok := d.head()
+		if !ok {
+			select {
+			case <-d.stopCh:
+				return
+			case <-d.notifyCh:
+			}
+			continue
+		}
+		err := d.send(e)
+		if err == nil || IsPermanent(err) {
+			d.mu.Lock()
+			if err != nil {
+				d.dropped[DropReasonRejected]++
+			}
+			d.removeLocked(e.seq)
+			d.mu.Unlock()
+			if err != nil {
+				otel.Handle(fmt.Errorf("diskqueue: dropping rejected request: %w", err))
+			}
+			wait = d.cfg.retryInterval
+			continue
+		}
+		otel.Handle(fmt.Errorf("diskqueue: failed to send, %d requests queued: %w", d.Depth(), err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.stopCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if wait *= 2; wait > d.cfg.maxRetryInterval {
+			wait = d.cfg.maxRetryInterval
+		}
+	}
+}
+
+func (d *Driver) send(e entry) error {
+	raw, err := ioutil.ReadFile(d.path(e.seq))
+	if os.IsNotExist(err) {
+		// Dropped while we were not looking, nothing to send.
+		return nil
+	}
+	if err != nil {
+		return Permanent(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.sendTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-d.stopCh:
+			cancel()
+		}
+	}()
+	return d.sender.Send(ctx, raw)
+}
+
+// registerMetrics reports the queue as diskqueue.depth, diskqueue.bytes and
+// diskqueue.dropped{reason}.
+func (d *Driver) registerMetrics() error {
+	if d.cfg.meterProvider == nil {
+		return nil
+	}
+	var (
+		depth, bytes metric.Int64ValueObserver
+		dropped      metric.Int64SumObserver
+		reasonKey    = label.Key("reason")
+	)
+	batch := d.cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/diskqueue").NewBatchObserver(
+		func(_ context.Context, result metric.BatchObserverResult) {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			result.Observe(nil, depth.Observation(int64(len(d.entries))), bytes.Observation(d.bytes))
+			for _, reason := range []string{DropReasonSize, DropReasonAge, DropReasonRejected} {
+				result.Observe([]label.KeyValue{reasonKey.String(reason)}, dropped.Observation(d.dropped[reason]))
+			}
+		})
+	var err error
+	if depth, err = batch.NewInt64ValueObserver("diskqueue.depth",
+		metric.WithDescription("The number of export requests waiting on disk")); err != nil {
+		return err
+	}
+	if bytes, err = batch.NewInt64ValueObserver("diskqueue.bytes",
+		metric.WithDescription("The size of the export requests waiting on disk"),
+		metric.WithUnit(unit.Bytes)); err != nil {
+		return err
+	}
+	dropped, err = batch.NewInt64SumObserver("diskqueue.dropped",
+		metric.WithDescription("The number of export requests dropped without being sent"))
+	return err
+}
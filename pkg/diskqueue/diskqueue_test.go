@@ -0,0 +1,283 @@
+package diskqueue
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/otlptest"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var errUnavailable = errors.New("collector unavailable")
+
+// collector is a Sender that records the counter value of every request it
+// accepts, or fails while down.
+type collector struct {
+	mu   sync.Mutex
+	down bool
+	err  error
+	sums []int64
+}
+
+func (c *collector) Send(_ context.Context, raw []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.down {
+		return errUnavailable
+	}
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(raw, req); err != nil {
+		return Permanent(err)
+	}
+	m := req.GetResourceMetrics()[0].GetInstrumentationLibraryMetrics()[0].GetMetrics()[0]
+	c.sums = append(c.sums, m.GetIntSum().GetDataPoints()[0].GetValue())
+	return nil
+}
+
+func (c *collector) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *collector) received() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64(nil), c.sums...)
+}
+
+// source produces checkpoints of a counter that grows by one per collection.
+type source struct {
+	proc    *processor.Processor
+	cont    *controller.Controller
+	counter metric.Int64Counter
+}
+
+func newSource() *source {
+	proc := processor.New(simple.NewWithInexpensiveDistribution(), export.CumulativeExportKindSelector())
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	return &source{
+		proc:    proc,
+		cont:    cont,
+		counter: metric.Must(cont.MeterProvider().Meter("test")).NewInt64Counter("request.count"),
+	}
+}
+
+func (s *source) export(t *testing.T, d *Driver) error {
+	t.Helper()
+	s.counter.Add(context.Background(), 1)
+	if err := s.cont.Collect(context.Background()); err != nil {
+		t.Fatal("failed to collect:", err)
+	}
+	return d.ExportMetrics(context.Background(), s.proc.CheckpointSet(), export.CumulativeExportKindSelector())
+}
+
+func startDriver(t *testing.T, dir string, sender Sender, opts ...Option) *Driver {
+	t.Helper()
+	opts = append([]Option{WithRetryInterval(time.Millisecond, 5*time.Millisecond)}, opts...)
+	d := NewDriver(dir, sender, opts...)
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal("failed to start driver:", err)
+	}
+	return d
+}
+
+func stopDriver(t *testing.T, d *Driver) {
+	t.Helper()
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal("failed to stop driver:", err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &collector{down: true}
+	src := newSource()
+	d := startDriver(t, dir, c)
+	for i := 0; i < 3; i++ {
+		if err := src.export(t, d); err != nil {
+			t.Fatal("unexpected export error:", err)
+		}
+	}
+	if got := d.Depth(); got != 3 {
+		t.Errorf("got depth %d while the collector is down, want 3", got)
+	}
+	stopDriver(t, d)
+
+	// A new process picks up where the previous one stopped, the requests
+	// it queues go after the replayed ones.
+	d = startDriver(t, dir, c)
+	defer stopDriver(t, d)
+	if got := d.Depth(); got != 3 {
+		t.Fatalf("got depth %d after restart, want 3", got)
+	}
+	if err := src.export(t, d); err != nil {
+		t.Fatal("unexpected export error:", err)
+	}
+	c.setDown(false)
+	waitFor(t, "the queue to drain", func() bool { return d.Depth() == 0 })
+
+	got := c.received()
+	want := []int64{1, 2, 3, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got sums %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got sums %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		sender *collector
+		reason string
+	}{
+		{
+			name:   "size",
+			opts:   []Option{WithMaxBytes(1)},
+			sender: &collector{down: true},
+			reason: DropReasonSize,
+		},
+		{
+			name:   "age",
+			opts:   []Option{WithMaxAge(time.Nanosecond)},
+			sender: &collector{down: true},
+			reason: DropReasonAge,
+		},
+		{
+			name:   "rejected",
+			sender: &collector{err: Permanent(errors.New("bad request"))},
+			reason: DropReasonRejected,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "diskqueue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			d := startDriver(t, dir, tc.sender, tc.opts...)
+			defer stopDriver(t, d)
+			_ = newSource().export(t, d)
+			waitFor(t, "the request to be dropped", func() bool { return d.Dropped(tc.reason) == 1 })
+			if got := d.Depth(); got != 0 {
+				t.Errorf("got depth %d, want 0", got)
+			}
+			if got := tc.sender.received(); len(got) != 0 {
+				t.Errorf("dropped request was sent: %v", got)
+			}
+		})
+	}
+}
+
+func TestMaxBytesDropsOldest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &collector{down: true}
+	src := newSource()
+	d := startDriver(t, dir, c)
+	if err := src.export(t, d); err != nil {
+		t.Fatal("unexpected export error:", err)
+	}
+	// Room for two requests of the same size.
+	d.cfg.maxBytes = 2 * d.bytes
+	for i := 0; i < 2; i++ {
+		if err := src.export(t, d); err != nil {
+			t.Fatal("unexpected export error:", err)
+		}
+	}
+	if got := d.Dropped(DropReasonSize); got != 1 {
+		t.Errorf("got %d requests dropped, want 1", got)
+	}
+	c.setDown(false)
+	waitFor(t, "the queue to drain", func() bool { return d.Depth() == 0 })
+	stopDriver(t, d)
+	if got := c.received(); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("got sums %v, want [2 3]", got)
+	}
+}
+
+func TestGRPCSenderRejected(t *testing.T) {
+	c, err := otlptest.NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	conn, err := grpc.Dial(c.Endpoint(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := startDriver(t, dir, NewGRPCSender(conn))
+	defer stopDriver(t, d)
+	src := newSource()
+
+	// A rejected request is dropped instead of blocking the queue.
+	c.SetError(status.Error(codes.InvalidArgument, "bad request"))
+	_ = src.export(t, d)
+	waitFor(t, "the request to be dropped", func() bool { return d.Dropped(DropReasonRejected) == 1 })
+
+	// An unavailable collector is retried until it comes back.
+	c.SetError(status.Error(codes.Unavailable, "down"))
+	_ = src.export(t, d)
+	time.Sleep(20 * time.Millisecond)
+	if got := d.Depth(); got != 1 {
+		t.Errorf("got depth %d while the collector is unavailable, want 1", got)
+	}
+	c.SetError(nil)
+	waitFor(t, "the queue to drain", func() bool { return d.Depth() == 0 })
+	if got := d.Dropped(DropReasonRejected); got != 1 {
+		t.Errorf("got %d requests rejected, want 1", got)
+	}
+	if got := len(c.MetricsRequests()); got != 1 {
+		t.Errorf("got %d requests, want the one sent after recovery", got)
+	}
+}
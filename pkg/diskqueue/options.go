@@ -0,0 +1,117 @@
+package diskqueue
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// config contains the settings of the disk queue driver.
+type config struct {
+	maxBytes         int64
+	maxAge           time.Duration
+	sendTimeout      time.Duration
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	meterProvider    metric.MeterProvider
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		maxBytes:         DefaultMaxBytes,
+		maxAge:           DefaultMaxAge,
+		sendTimeout:      DefaultSendTimeout,
+		retryInterval:    DefaultRetryInterval,
+		maxRetryInterval: DefaultMaxRetryInterval,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the disk queue driver.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithMaxBytes caps the total size of the queued requests. Once exceeded the
+// oldest requests are dropped. Non positive values are ignored.
+func WithMaxBytes(n int64) Option {
+	return maxBytesOption(n)
+}
+
+// WithMaxAge sets how long a request is kept before it is dropped without
+// being sent. Non positive values are ignored.
+func WithMaxAge(d time.Duration) Option {
+	return maxAgeOption(d)
+}
+
+// WithSendTimeout sets the timeout of a single send. Non positive values
+// are ignored.
+func WithSendTimeout(d time.Duration) Option {
+	return sendTimeoutOption(d)
+}
+
+// WithRetryInterval sets the initial and maximum wait between failed sends,
+// the wait doubles after every consecutive failure. Non positive values are
+// ignored.
+func WithRetryInterval(initial, max time.Duration) Option {
+	return retryIntervalOption{initial: initial, max: max}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the queue
+// depth and drops. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type maxBytesOption int64
+
+type maxAgeOption time.Duration
+
+type sendTimeoutOption time.Duration
+
+type retryIntervalOption struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o maxBytesOption) Apply(c *config) {
+	if o > 0 {
+		c.maxBytes = int64(o)
+	}
+}
+
+func (o maxAgeOption) Apply(c *config) {
+	if o > 0 {
+		c.maxAge = time.Duration(o)
+	}
+}
+
+func (o sendTimeoutOption) Apply(c *config) {
+	if o > 0 {
+		c.sendTimeout = time.Duration(o)
+	}
+}
+
+func (o retryIntervalOption) Apply(c *config) {
+	if o.initial > 0 {
+		c.retryInterval = o.initial
+	}
+	if o.max > 0 {
+		c.maxRetryInterval = o.max
+	}
+	if c.maxRetryInterval < c.retryInterval {
+		c.maxRetryInterval = c.retryInterval
+	}
+}
+
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
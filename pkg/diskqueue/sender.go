@@ -0,0 +1,77 @@
+package diskqueue
+
+import (
+	"context"
+	"errors"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Sender delivers a serialized ExportMetricsServiceRequest to a collector.
+// *otlphttp.Client is a Sender.
+type Sender interface {
+	Send(ctx context.Context, raw []byte) error
+}
+
+// Permanent marks err as not retryable, the request that caused it is
+// dropped instead of being sent again.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+func (e permanentError) Permanent() bool { return true }
+
+// IsPermanent tells whether err, or an error it wraps, was marked with
+// Permanent or has a Permanent method returning true, eg. the
+// *otlphttp.RejectedError of the requests the collector rejects.
+func IsPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// SenderFunc adapts a function to a Sender.
+type SenderFunc func(ctx context.Context, raw []byte) error
+
+// Send implements Sender.
+func (f SenderFunc) Send(ctx context.Context, raw []byte) error {
+	return f(ctx, raw)
+}
+
+// retryableCodes are the gRPC codes the OTLP specification allows to
+// retry, the requests rejected with any other code are dropped.
+var retryableCodes = map[codes.Code]bool{
+	codes.Canceled:          true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.OutOfRange:        true,
+	codes.Unavailable:       true,
+	codes.DataLoss:          true,
+}
+
+// NewGRPCSender returns a Sender that calls the collector's MetricsService
+// over conn.
+func NewGRPCSender(conn grpc.ClientConnInterface) Sender {
+	client := colmetricpb.NewMetricsServiceClient(conn)
+	return SenderFunc(func(ctx context.Context, raw []byte) error {
+		req := &colmetricpb.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(raw, req); err != nil {
+			return Permanent(err)
+		}
+		_, err := client.Export(ctx, req)
+		if err != nil && !retryableCodes[status.Code(err)] {
+			return Permanent(err)
+		}
+		return err
+	})
+}
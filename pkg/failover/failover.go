@@ -0,0 +1,238 @@
+// Package failover provides a metric exporter that sends each checkpoint to
+// the highest priority healthy endpoint of an ordered list. Endpoints that
+// are not in use are probed in the background, so that the exporter fails
+// over to a backup that is known to be up and fails back as soon as the
+// primary recovers.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+)
+
+const (
+	// DefaultProbeInterval is how often endpoints that are not in use are probed.
+	DefaultProbeInterval = 5 * time.Second
+	// DefaultProbeTimeout is the timeout of a single probe.
+	DefaultProbeTimeout = time.Second
+	// DefaultFailureThreshold is the number of consecutive export failures
+	// after which an endpoint is considered unhealthy.
+	DefaultFailureThreshold = 1
+)
+
+// ErrNoEndpoints is returned by New when no endpoint is configured.
+var ErrNoEndpoints = errors.New("failover: no endpoints configured")
+
+// ProbeFunc checks whether an endpoint is reachable.
+type ProbeFunc func(ctx context.Context, endpoint string) error
+
+// DialProbe is the default ProbeFunc, it opens and closes a TCP connection
+// to the endpoint.
+func DialProbe(ctx context.Context, endpoint string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type endpoint struct {
+	name     string
+	exporter export.Exporter
+	healthy  bool
+	failures int
+}
+
+// Exporter is an export.Exporter that fails over between endpoints.
+type Exporter struct {
+	selector         export.ExportKindSelector
+	probe            ProbeFunc
+	probeTimeout     time.Duration
+	failureThreshold int
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+var _ export.Exporter = (*Exporter)(nil)
+
+// New creates a failover exporter and starts probing the configured
+// endpoints. All endpoints start as healthy.
+func New(opts ...Option) (*Exporter, error) {
+	c := newConfig(opts...)
+	if len(c.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	e := &Exporter{
+		selector:         c.selector,
+		probe:            c.probe,
+		probeTimeout:     c.probeTimeout,
+		failureThreshold: c.failureThreshold,
+		stopCh:           make(chan struct{}),
+	}
+	if e.selector == nil {
+		e.selector = c.endpoints[0].exporter
+	}
+	for _, ep := range c.endpoints {
+		e.endpoints = append(e.endpoints, &endpoint{name: ep.name, exporter: ep.exporter, healthy: true})
+	}
+	if err := e.registerMetrics(c.meterProvider); err != nil {
+		return nil, err
+	}
+	e.wg.Add(1)
+	go e.runProbes(c.probeInterval)
+	return e, nil
+}
+
+// ExportKindFor implements export.ExportKindSelector.
+func (e *Exporter) ExportKindFor(desc *metric.Descriptor, kind aggregation.Kind) export.ExportKind {
+	return e.selector.ExportKindFor(desc, kind)
+}
+
+// Export implements export.Exporter. The checkpoint is sent to the healthy
+// endpoints in priority order until one accepts it. When every endpoint is
+// unhealthy they are all tried anyway, as a last resort.
+func (e *Exporter) Export(ctx context.Context, cps export.CheckpointSet) error {
+	var lastErr error
+	for _, ep := range e.candidates() {
+		err := ep.exporter.Export(ctx, cps)
+		e.report(ep, err)
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %w", ep.name, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("failover: no endpoint accepted the export: %w", lastErr)
+}
+
+// Active returns the endpoint the next export will be sent to first.
+func (e *Exporter) Active() string {
+	return e.candidates()[0].name
+}
+
+// Shutdown stops probing.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		close(e.stopCh)
+	})
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// candidates returns the healthy endpoints followed by the unhealthy ones,
+// each group in priority order.
+func (e *Exporter) candidates() []*endpoint {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	healthy := make([]*endpoint, 0, len(e.endpoints))
+	var unhealthy []*endpoint
+	for _, ep := range e.endpoints {
+		if ep.healthy {
+			healthy = append(healthy, ep)
+		} else {
+			unhealthy = append(unhealthy, ep)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (e *Exporter) report(ep *endpoint, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		ep.failures = 0
+		ep.healthy = true
+		return
+	}
+	ep.failures++
+	if ep.healthy && ep.failures >= e.failureThreshold {
+		ep.healthy = false
+		otel.Handle(fmt.Errorf("failover: endpoint %s marked unhealthy: %w", ep.name, err))
+	}
+}
+
+func (e *Exporter) runProbes(interval time.Duration) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stopCh:
+			return
+		case <-ticker.C:
+			e.probeIdle()
+		}
+	}
+}
+
+// probeIdle probes every endpoint but the active one. The active endpoint's
+// health is tracked by its exports.
+func (e *Exporter) probeIdle() {
+	idle := e.candidates()[1:]
+	for _, ep := range idle {
+		ctx, cancel := context.WithTimeout(context.Background(), e.probeTimeout)
+		err := e.probe(ctx, ep.name)
+		cancel()
+
+		e.mu.Lock()
+		if err == nil {
+			ep.failures = 0
+			ep.healthy = true
+		} else {
+			ep.healthy = false
+		}
+		e.mu.Unlock()
+	}
+}
+
+// registerMetrics reports the active endpoint as
+// failover.active_endpoint{endpoint} = 1 and every other endpoint as 0, so
+// that the endpoint failed over from does not stay at 1 in cumulative
+// pipelines.
+func (e *Exporter) registerMetrics(mp metric.MeterProvider) error {
+	if mp == nil {
+		return nil
+	}
+	endpointKey := label.Key("endpoint")
+	_, err := mp.Meter("github.com/skonto/test-otel/pkg/failover").NewInt64ValueObserver(
+		"failover.active_endpoint",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			for i, ep := range e.candidates() {
+				var active int64
+				if i == 0 {
+					active = 1
+				}
+				result.Observe(active, endpointKey.String(ep.name))
+			}
+		},
+		metric.WithDescription("The OTLP endpoint metrics are currently exported to"),
+	)
+	return err
+}
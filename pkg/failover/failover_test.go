@@ -0,0 +1,201 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/checkpoint"
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+var errDown = errors.New("endpoint down")
+
+// fakeEndpoint is an exporter and probe target that can be taken down.
+type fakeEndpoint struct {
+	export.ExportKindSelector
+
+	mu      sync.Mutex
+	down    bool
+	exports int
+}
+
+func (f *fakeEndpoint) Export(context.Context, export.CheckpointSet) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errDown
+	}
+	f.exports++
+	return nil
+}
+
+func (f *fakeEndpoint) check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errDown
+	}
+	return nil
+}
+
+func (f *fakeEndpoint) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeEndpoint) exported() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exports
+}
+
+func newFakeEndpoint() *fakeEndpoint {
+	return &fakeEndpoint{ExportKindSelector: export.CumulativeExportKindSelector()}
+}
+
+func TestFailoverAndFailback(t *testing.T) {
+	endpoints := map[string]*fakeEndpoint{
+		"primary":   newFakeEndpoint(),
+		"secondary": newFakeEndpoint(),
+		"tertiary":  newFakeEndpoint(),
+	}
+	probe := func(_ context.Context, name string) error {
+		return endpoints[name].check()
+	}
+	e, err := New(
+		WithEndpoint("primary", endpoints["primary"]),
+		WithEndpoint("secondary", endpoints["secondary"]),
+		WithEndpoint("tertiary", endpoints["tertiary"]),
+		WithProbe(probe),
+		WithProbeInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	defer e.Shutdown(context.Background())
+	cps := checkpoint.New(export.CumulativeExportKindSelector())
+	exportOnce := func() {
+		t.Helper()
+		if err := e.Export(context.Background(), cps); err != nil {
+			t.Fatal("unexpected export error:", err)
+		}
+	}
+
+	exportOnce()
+	if got := e.Active(); got != "primary" {
+		t.Fatalf("got active endpoint %q, want primary", got)
+	}
+
+	// The secondary goes down while idle, the probe notices it so that
+	// the primary's failure goes straight to the tertiary.
+	endpoints["secondary"].setDown(true)
+	e.probeIdle()
+	endpoints["primary"].setDown(true)
+	exportOnce()
+	if got := e.Active(); got != "tertiary" {
+		t.Fatalf("got active endpoint %q, want tertiary", got)
+	}
+	if got := endpoints["secondary"].exported(); got != 0 {
+		t.Errorf("unhealthy secondary got %d exports", got)
+	}
+
+	// Fail back to the primary once its probe succeeds.
+	endpoints["primary"].setDown(false)
+	e.probeIdle()
+	if got := e.Active(); got != "primary" {
+		t.Fatalf("got active endpoint %q after recovery, want primary", got)
+	}
+	exportOnce()
+	if got := endpoints["primary"].exported(); got != 2 {
+		t.Errorf("primary got %d exports, want 2", got)
+	}
+	if got := endpoints["tertiary"].exported(); got != 1 {
+		t.Errorf("tertiary got %d exports, want 1", got)
+	}
+}
+
+func TestAllEndpointsDown(t *testing.T) {
+	primary, secondary := newFakeEndpoint(), newFakeEndpoint()
+	primary.setDown(true)
+	secondary.setDown(true)
+	e, err := New(
+		WithEndpoint("primary", primary),
+		WithEndpoint("secondary", secondary),
+		WithProbeInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	defer e.Shutdown(context.Background())
+
+	if err := e.Export(context.Background(), checkpoint.New(export.CumulativeExportKindSelector())); !errors.Is(err, errDown) {
+		t.Errorf("got error %v, want %v", err, errDown)
+	}
+	// Unhealthy endpoints are still tried in priority order.
+	secondary.setDown(false)
+	if err := e.Export(context.Background(), checkpoint.New(export.CumulativeExportKindSelector())); err != nil {
+		t.Error("unexpected export error:", err)
+	}
+	if got := e.Active(); got != "secondary" {
+		t.Errorf("got active endpoint %q, want secondary", got)
+	}
+}
+
+func TestFailureThreshold(t *testing.T) {
+	primary, secondary := newFakeEndpoint(), newFakeEndpoint()
+	e, err := New(
+		WithEndpoint("primary", primary),
+		WithEndpoint("secondary", secondary),
+		WithFailureThreshold(2),
+		WithProbeInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	defer e.Shutdown(context.Background())
+
+	primary.setDown(true)
+	_ = e.Export(context.Background(), checkpoint.New(export.CumulativeExportKindSelector()))
+	if got := e.Active(); got != "primary" {
+		t.Errorf("got active endpoint %q after one failure, want primary", got)
+	}
+	_ = e.Export(context.Background(), checkpoint.New(export.CumulativeExportKindSelector()))
+	if got := e.Active(); got != "secondary" {
+		t.Errorf("got active endpoint %q after two failures, want secondary", got)
+	}
+}
+
+func TestActiveEndpointMetric(t *testing.T) {
+	p := metrictest.NewPipeline()
+	primary, secondary := newFakeEndpoint(), newFakeEndpoint()
+	e, err := New(
+		WithEndpoint("primary", primary),
+		WithEndpoint("secondary", secondary),
+		WithProbeInterval(time.Hour),
+		WithMeterProvider(p.MeterProvider()),
+	)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	defer e.Shutdown(context.Background())
+	active := func() map[string]float64 {
+		return p.Sums(t, "failover.active_endpoint")
+	}
+
+	want := map[string]float64{"endpoint=primary": 1, "endpoint=secondary": 0}
+	if got := active(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	primary.setDown(true)
+	_ = e.Export(context.Background(), checkpoint.New(export.CumulativeExportKindSelector()))
+	want = map[string]float64{"endpoint=primary": 0, "endpoint=secondary": 1}
+	if got := active(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v after failing over, want %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package failover
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// config contains the settings of the failover exporter.
+type config struct {
+	endpoints        []endpointConfig
+	probe            ProbeFunc
+	probeInterval    time.Duration
+	probeTimeout     time.Duration
+	failureThreshold int
+	selector         export.ExportKindSelector
+	meterProvider    metric.MeterProvider
+}
+
+type endpointConfig struct {
+	name     string
+	exporter export.Exporter
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		probe:            DialProbe,
+		probeInterval:    DefaultProbeInterval,
+		probeTimeout:     DefaultProbeTimeout,
+		failureThreshold: DefaultFailureThreshold,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the failover exporter.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithEndpoint appends an endpoint to the priority list, the first endpoint
+// added is the primary. The name is what the probe is called with and what
+// is reported as the active endpoint, usually the host:port of a collector.
+func WithEndpoint(name string, exporter export.Exporter) Option {
+	return endpointOption{name: name, exporter: exporter}
+}
+
+// WithProbe replaces DialProbe as the check used to detect that an
+// unhealthy endpoint recovered.
+func WithProbe(probe ProbeFunc) Option {
+	return probeOption(probe)
+}
+
+// WithProbeInterval sets how often unhealthy endpoints are probed. Non
+// positive values are ignored.
+func WithProbeInterval(d time.Duration) Option {
+	return probeIntervalOption(d)
+}
+
+// WithProbeTimeout sets the timeout of a single probe. Non positive values
+// are ignored.
+func WithProbeTimeout(d time.Duration) Option {
+	return probeTimeoutOption(d)
+}
+
+// WithFailureThreshold sets how many consecutive export failures mark an
+// endpoint unhealthy. Values lower than one are ignored.
+func WithFailureThreshold(n int) Option {
+	return failureThresholdOption(n)
+}
+
+// WithExportKindSelector sets the selector used by the processor, by
+// default the primary endpoint decides.
+func WithExportKindSelector(selector export.ExportKindSelector) Option {
+	return selectorOption{selector}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the active
+// endpoint. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type endpointOption endpointConfig
+
+type probeOption ProbeFunc
+
+type probeIntervalOption time.Duration
+
+type probeTimeoutOption time.Duration
+
+type failureThresholdOption int
+
+type selectorOption struct{ export.ExportKindSelector }
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o endpointOption) Apply(c *config) {
+	c.endpoints = append(c.endpoints, endpointConfig(o))
+}
+
+func (o probeOption) Apply(c *config) {
+	if o != nil {
+		c.probe = ProbeFunc(o)
+	}
+}
+
+func (o probeIntervalOption) Apply(c *config) {
+	if o > 0 {
+		c.probeInterval = time.Duration(o)
+	}
+}
+
+func (o probeTimeoutOption) Apply(c *config) {
+	if o > 0 {
+		c.probeTimeout = time.Duration(o)
+	}
+}
+
+func (o failureThresholdOption) Apply(c *config) {
+	if o > 0 {
+		c.failureThreshold = int(o)
+	}
+}
+
+func (o selectorOption) Apply(c *config) {
+	c.selector = o.ExportKindSelector
+}
+
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
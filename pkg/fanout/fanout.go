@@ -0,0 +1,227 @@
+// Package fanout provides a metric exporter that sends each checkpoint to
+// several exporters, eg. a node-local and a central collector during a
+// migration. Every destination has its own queue, timeout and error
+// accounting, so a slow or failing destination never delays or drops data
+// for the others.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/checkpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+)
+
+const (
+	// DefaultTimeout is the export timeout of a destination added with a
+	// non positive timeout.
+	DefaultTimeout = 10 * time.Second
+	// DefaultQueueSize is the number of checkpoints buffered per destination.
+	DefaultQueueSize = 2
+)
+
+// ErrNoDestinations is returned by New when no destination is configured.
+var ErrNoDestinations = errors.New("fanout: no destinations configured")
+
+// Stats holds the export accounting of a single destination.
+type Stats struct {
+	Name string
+	// Exported is the number of checkpoints successfully exported.
+	Exported int64
+	// Failed is the number of checkpoints the destination returned an error for.
+	Failed int64
+	// Dropped is the number of checkpoints discarded because the
+	// destination's queue was full.
+	Dropped int64
+	// LastError is the most recent export error, if any.
+	LastError error
+}
+
+type destination struct {
+	name     string
+	exporter export.Exporter
+	timeout  time.Duration
+	queue    chan *checkpoint.Set
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Exporter is an export.Exporter that fans checkpoints out to several
+// destinations.
+type Exporter struct {
+	selector     export.ExportKindSelector
+	destinations []*destination
+
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	mu       sync.RWMutex
+	stopped  bool
+}
+
+var _ export.Exporter = (*Exporter)(nil)
+
+// New creates a fan-out exporter and starts one sender per destination.
+// All destinations are expected to agree on the export kind, the first
+// destination decides it unless WithExportKindSelector is used.
+func New(opts ...Option) (*Exporter, error) {
+	c := newConfig(opts...)
+	if len(c.destinations) == 0 {
+		return nil, ErrNoDestinations
+	}
+	e := &Exporter{selector: c.selector}
+	if e.selector == nil {
+		e.selector = c.destinations[0].exporter
+	}
+	for _, d := range c.destinations {
+		timeout := d.timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		e.destinations = append(e.destinations, &destination{
+			name:     d.name,
+			exporter: d.exporter,
+			timeout:  timeout,
+			queue:    make(chan *checkpoint.Set, c.queueSize),
+			stats:    Stats{Name: d.name},
+		})
+	}
+	if err := e.registerMetrics(c.meterProvider); err != nil {
+		return nil, err
+	}
+	for _, d := range e.destinations {
+		e.wg.Add(1)
+		go e.run(d)
+	}
+	return e, nil
+}
+
+// ExportKindFor implements export.ExportKindSelector.
+func (e *Exporter) ExportKindFor(desc *metric.Descriptor, kind aggregation.Kind) export.ExportKind {
+	return e.selector.ExportKindFor(desc, kind)
+}
+
+// Export implements export.Exporter. It copies the checkpoint and queues it
+// for every destination without waiting for the destinations to send it.
+func (e *Exporter) Export(_ context.Context, cps export.CheckpointSet) error {
+	set, err := checkpoint.Snapshot(cps, e)
+	if err != nil {
+		return err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.stopped {
+		return nil
+	}
+	for _, d := range e.destinations {
+		d.enqueue(set)
+	}
+	return nil
+}
+
+// Shutdown stops accepting checkpoints and waits until the queued ones are
+// sent or the context is done.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		e.mu.Lock()
+		e.stopped = true
+		for _, d := range e.destinations {
+			close(d.queue)
+		}
+		e.mu.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the export accounting of every destination, in the order
+// they were configured.
+func (e *Exporter) Stats() []Stats {
+	stats := make([]Stats, 0, len(e.destinations))
+	for _, d := range e.destinations {
+		d.mu.Lock()
+		stats = append(stats, d.stats)
+		d.mu.Unlock()
+	}
+	return stats
+}
+
+// enqueue adds a checkpoint to the destination queue, discarding the oldest
+// queued checkpoint when the destination cannot keep up.
+func (d *destination) enqueue(set *checkpoint.Set) {
+	for {
+		select {
+		case d.queue <- set:
+			return
+		default:
+		}
+		select {
+		case <-d.queue:
+			d.mu.Lock()
+			d.stats.Dropped++
+			d.mu.Unlock()
+		default:
+		}
+	}
+}
+
+func (e *Exporter) run(d *destination) {
+	defer e.wg.Done()
+	for set := range d.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		err := d.exporter.Export(ctx, set)
+		cancel()
+
+		d.mu.Lock()
+		if err != nil {
+			d.stats.Failed++
+			d.stats.LastError = err
+		} else {
+			d.stats.Exported++
+		}
+		d.mu.Unlock()
+		if err != nil {
+			otel.Handle(fmt.Errorf("fanout: destination %s: %w", d.name, err))
+		}
+	}
+}
+
+// registerMetrics reports the per destination accounting as
+// fanout.exports{destination, outcome}.
+func (e *Exporter) registerMetrics(mp metric.MeterProvider) error {
+	if mp == nil {
+		return nil
+	}
+	destinationKey := label.Key("destination")
+	outcomeKey := label.Key("outcome")
+	_, err := mp.Meter("github.com/skonto/test-otel/pkg/fanout").NewInt64SumObserver(
+		"fanout.exports",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			for _, s := range e.Stats() {
+				name := destinationKey.String(s.Name)
+				result.Observe(s.Exported, name, outcomeKey.String("exported"))
+				result.Observe(s.Failed, name, outcomeKey.String("failed"))
+				result.Observe(s.Dropped, name, outcomeKey.String("dropped"))
+			}
+		},
+		metric.WithDescription("Number of checkpoints per fan-out destination and outcome"),
+	)
+	return err
+}
@@ -0,0 +1,131 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// recorder is an exporter that keeps the sum of every exported checkpoint.
+type recorder struct {
+	export.ExportKindSelector
+	block bool
+	err   error
+
+	mu   sync.Mutex
+	sums []int64
+}
+
+func (r *recorder) Export(ctx context.Context, cps export.CheckpointSet) error {
+	if r.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	var total int64
+	err := cps.ForEach(r, func(rec export.Record) error {
+		sum, err := rec.Aggregation().(aggregation.Sum).Sum()
+		total += sum.AsInt64()
+		return err
+	})
+	r.mu.Lock()
+	r.sums = append(r.sums, total)
+	r.mu.Unlock()
+	return err
+}
+
+func (r *recorder) exported() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.sums...)
+}
+
+func newRecorder() *recorder {
+	return &recorder{ExportKindSelector: export.CumulativeExportKindSelector()}
+}
+
+func TestFanoutIsolatesDestinations(t *testing.T) {
+	healthy := newRecorder()
+	slow := newRecorder()
+	slow.block = true
+	failing := newRecorder()
+	failing.err = errors.New("collector unavailable")
+
+	fan, err := New(
+		WithDestination("healthy", healthy, time.Second),
+		WithDestination("slow", slow, 50*time.Millisecond),
+		WithDestination("failing", failing, time.Second),
+		WithQueueSize(5),
+	)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+
+	proc := processor.New(simple.NewWithInexpensiveDistribution(), fan)
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	counter := metric.Must(cont.MeterProvider().Meter("test")).NewInt64Counter("request.count")
+
+	const rounds = 5
+	start := time.Now()
+	for i := 0; i < rounds; i++ {
+		counter.Add(context.Background(), 1)
+		if err := cont.Collect(context.Background()); err != nil {
+			t.Fatal("failed to collect:", err)
+		}
+		proc.CheckpointSet().RLock()
+		err := fan.Export(context.Background(), proc.CheckpointSet())
+		proc.CheckpointSet().RUnlock()
+		if err != nil {
+			t.Fatal("unexpected export error:", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("export blocked on destinations for %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := fan.Shutdown(ctx); err != nil {
+		t.Fatal("failed to shut down:", err)
+	}
+
+	// The healthy destination sees every checkpoint, with the cumulative
+	// values of the time they were taken.
+	got := healthy.exported()
+	if len(got) != rounds {
+		t.Fatalf("healthy destination got %d checkpoints, want %d", len(got), rounds)
+	}
+	for i, sum := range got {
+		if sum != int64(i+1) {
+			t.Errorf("checkpoint %d: got sum %d, want %d", i, sum, i+1)
+		}
+	}
+
+	stats := fan.Stats()
+	if s := stats[0]; s.Exported != rounds || s.Failed != 0 || s.Dropped != 0 {
+		t.Errorf("unexpected healthy stats %+v", s)
+	}
+	if s := stats[1]; s.Exported != 0 || s.Failed+s.Dropped != rounds || !errors.Is(s.LastError, context.DeadlineExceeded) {
+		t.Errorf("unexpected slow stats %+v", s)
+	}
+	if s := stats[2]; s.Failed != rounds || s.LastError != failing.err {
+		t.Errorf("unexpected failing stats %+v", s)
+	}
+}
+
+func TestFanoutRequiresDestination(t *testing.T) {
+	if _, err := New(); err != ErrNoDestinations {
+		t.Errorf("got error %v, want %v", err, ErrNoDestinations)
+	}
+}
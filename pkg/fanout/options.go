@@ -0,0 +1,91 @@
+package fanout
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// config contains the settings of the fan-out exporter.
+type config struct {
+	destinations  []destinationConfig
+	queueSize     int
+	selector      export.ExportKindSelector
+	meterProvider metric.MeterProvider
+}
+
+type destinationConfig struct {
+	name     string
+	exporter export.Exporter
+	timeout  time.Duration
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		queueSize: DefaultQueueSize,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the fan-out exporter.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithDestination adds an exporter to fan out to. The name identifies the
+// destination in Stats, metrics and errors. A non positive timeout selects
+// DefaultTimeout.
+func WithDestination(name string, exporter export.Exporter, timeout time.Duration) Option {
+	return destinationOption{name: name, exporter: exporter, timeout: timeout}
+}
+
+// WithQueueSize sets how many checkpoints are buffered per destination
+// before the oldest is dropped. Values lower than one are ignored.
+func WithQueueSize(size int) Option {
+	return queueSizeOption(size)
+}
+
+// WithExportKindSelector sets the selector the processor uses to compute the
+// checkpoint shared by all destinations.
+func WithExportKindSelector(selector export.ExportKindSelector) Option {
+	return selectorOption{selector}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the
+// per destination accounting. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type destinationOption destinationConfig
+
+type queueSizeOption int
+
+type selectorOption struct{ export.ExportKindSelector }
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o destinationOption) Apply(c *config) {
+	c.destinations = append(c.destinations, destinationConfig(o))
+}
+
+func (o queueSizeOption) Apply(c *config) {
+	if o > 0 {
+		c.queueSize = int(o)
+	}
+}
+
+func (o selectorOption) Apply(c *config) {
+	c.selector = o.ExportKindSelector
+}
+
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
@@ -0,0 +1,118 @@
+package grpcmetrics
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Client records the RPCs made by a gRPC client.
+type Client struct {
+	inst *instruments
+}
+
+// NewClient creates the rpc.client.* instruments.
+func NewClient(opts ...Option) (*Client, error) {
+	inst, err := newInstruments(newConfig(opts...), "client", trace.SpanKindClient)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{inst: inst}, nil
+}
+
+// UnaryInterceptor returns the interceptor recording unary RPCs.
+func (c *Client) UnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, r := c.start(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		r.send(req)
+		if err == nil {
+			r.receive(reply)
+		}
+		c.inst.record(ctx, r, status.Code(err))
+		return err
+	}
+}
+
+// StreamInterceptor returns the interceptor recording streaming RPCs. A
+// stream is recorded once RecvMsg returns an error, io.EOF included, or
+// the response of a stream without server streaming. The server streams
+// the caller does not read to the end are not recorded.
+func (c *Client) StreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, r := c.start(ctx, method)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.inst.record(ctx, r, status.Code(err))
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, ctx: ctx, inst: c.inst, rpc: r, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+// start starts the span of an RPC and injects it, with the baggage, in the
+// outgoing metadata.
+func (c *Client) start(ctx context.Context, method string) (context.Context, *rpc) {
+	ctx, r := c.inst.start(ctx, method)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	c.inst.propagators.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), r
+}
+
+// clientStream counts the messages of a stream and records it when it
+// ends.
+type clientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	inst *instruments
+	rpc  *rpc
+	// Without server streaming the stream ends with the first response,
+	// the callers do not read until io.EOF.
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.rpc.send(m)
+	}
+	// Errors other than io.EOF end the stream, io.EOF means the status
+	// is returned by RecvMsg.
+	if err != nil && err != io.EOF {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.rpc.receive(m)
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	case err == io.EOF:
+		s.finish(nil)
+	default:
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		s.inst.record(s.ctx, s.rpc, status.Code(err))
+	})
+}
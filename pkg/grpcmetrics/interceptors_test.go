@@ -0,0 +1,307 @@
+package grpcmetrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// echoService is a hand written service, there is no generated code for
+// tests to use.
+var echoService = grpc.ServiceDesc{
+	ServiceName: "grpcmetrics.test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &wrapperspb.StringValue{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/grpcmetrics.test.Echo/Echo"}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				in := req.(*wrapperspb.StringValue)
+				if in.Value == "missing" {
+					return nil, status.Error(codes.NotFound, "missing")
+				}
+				return in, nil
+			})
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Chat",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			for {
+				in := &wrapperspb.StringValue{}
+				if err := stream.RecvMsg(in); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(in); err != nil {
+					return err
+				}
+			}
+		},
+	}, {
+		StreamName:    "Join",
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var parts []string
+			for {
+				in := &wrapperspb.StringValue{}
+				if err := stream.RecvMsg(in); err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				parts = append(parts, in.Value)
+			}
+			return stream.SendMsg(wrapperspb.String(strings.Join(parts, " ")))
+		},
+	}},
+}
+
+// dial serves echoService over an in-memory listener and returns a
+// connection to it, both sides recording to their own pipeline with opts.
+func dial(t *testing.T, serverPipeline, clientPipeline metrictest.Pipeline, opts ...Option) *grpc.ClientConn {
+	server, err := NewServer(append(opts, WithMeterProvider(serverPipeline.MeterProvider()))...)
+	if err != nil {
+		t.Fatal("failed to create server interceptors:", err)
+	}
+	client, err := NewClient(append(opts, WithMeterProvider(clientPipeline.MeterProvider()))...)
+	if err != nil {
+		t.Fatal("failed to create client interceptors:", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(server.UnaryInterceptor()),
+		grpc.StreamInterceptor(server.StreamInterceptor()),
+	)
+	s.RegisterService(&echoService, struct{}{})
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(client.UnaryInterceptor()),
+		grpc.WithStreamInterceptor(client.StreamInterceptor()),
+	)
+	if err != nil {
+		t.Fatal("failed to dial:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestInterceptors(t *testing.T) {
+	serverPipeline, clientPipeline := metrictest.NewPipeline(), metrictest.NewPipeline()
+	conn := dial(t, serverPipeline, clientPipeline)
+	ctx := context.Background()
+
+	reply := &wrapperspb.StringValue{}
+	if err := conn.Invoke(ctx, "/grpcmetrics.test.Echo/Echo", wrapperspb.String("hello"), reply); err != nil {
+		t.Fatal("echo failed:", err)
+	}
+	err := conn.Invoke(ctx, "/grpcmetrics.test.Echo/Echo", wrapperspb.String("missing"), reply)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+
+	chat, err := conn.NewStream(ctx, &echoService.Streams[0], "/grpcmetrics.test.Echo/Chat")
+	if err != nil {
+		t.Fatal("failed to open chat:", err)
+	}
+	for _, word := range []string{"a", "bb", "ccc"} {
+		if err := chat.SendMsg(wrapperspb.String(word)); err != nil {
+			t.Fatal("failed to send:", err)
+		}
+	}
+	if err := chat.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if err := chat.RecvMsg(reply); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("failed to receive:", err)
+		}
+	}
+
+	join, err := conn.NewStream(ctx, &echoService.Streams[1], "/grpcmetrics.test.Echo/Join")
+	if err != nil {
+		t.Fatal("failed to open join:", err)
+	}
+	for _, word := range []string{"x", "y"} {
+		if err := join.SendMsg(wrapperspb.String(word)); err != nil {
+			t.Fatal("failed to send:", err)
+		}
+	}
+	if err := join.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	// Like generated CloseAndRecv, the response is read once.
+	if err := join.RecvMsg(reply); err != nil || reply.Value != "x y" {
+		t.Fatalf("got %v %v, want x y", reply.Value, err)
+	}
+
+	sizeOf := func(s string) float64 { return float64(proto.Size(wrapperspb.String(s))) }
+	rpc := func(code, method string) string {
+		return "{rpc.grpc.status_code=" + code + ",rpc.method=/grpcmetrics.test.Echo/" + method + "}"
+	}
+	messages := func(direction, code, method string) string {
+		return "{rpc.grpc.status_code=" + code + ",rpc.message.direction=" + direction + ",rpc.method=/grpcmetrics.test.Echo/" + method + "}"
+	}
+	for side, p := range map[string]metrictest.Pipeline{"server": serverPipeline, "client": clientPipeline} {
+		// What the client sends the server receives.
+		in, out := Received, Sent
+		if side == "client" {
+			in, out = out, in
+		}
+		prefix := "rpc." + side + "."
+		want := map[string]float64{
+			prefix + "request_count" + rpc("OK", "Echo"):                 1,
+			prefix + "request_count" + rpc("NotFound", "Echo"):           1,
+			prefix + "request_count" + rpc("OK", "Chat"):                 1,
+			prefix + "request_count" + rpc("OK", "Join"):                 1,
+			prefix + "duration" + rpc("OK", "Chat") + ".count":           1,
+			prefix + "message_count" + messages(in, "OK", "Echo"):        1,
+			prefix + "message_count" + messages(out, "OK", "Echo"):       1,
+			prefix + "message_count" + messages(out, "NotFound", "Echo"): 0,
+			prefix + "message_count" + messages(in, "OK", "Chat"):        3,
+			prefix + "message_count" + messages(out, "OK", "Chat"):       3,
+			prefix + "message_count" + messages(in, "OK", "Join"):        2,
+			prefix + "message_count" + messages(out, "OK", "Join"):       1,
+			prefix + "message_bytes" + messages(in, "OK", "Echo"):        sizeOf("hello"),
+			prefix + "message_bytes" + messages(in, "OK", "Chat"):        sizeOf("a") + sizeOf("bb") + sizeOf("ccc"),
+			prefix + "message_bytes" + messages(out, "OK", "Join"):       sizeOf("x y"),
+		}
+		got := p.Values(t)
+		for name, value := range want {
+			if v, ok := got[name]; !ok || v != value {
+				t.Errorf("%s: got %v, want %v", name, got[name], value)
+			}
+		}
+	}
+}
+
+func TestClientStreamError(t *testing.T) {
+	serverPipeline, clientPipeline := metrictest.NewPipeline(), metrictest.NewPipeline()
+	conn := dial(t, serverPipeline, clientPipeline)
+	ctx, cancel := context.WithCancel(context.Background())
+	chat, err := conn.NewStream(ctx, &echoService.Streams[0], "/grpcmetrics.test.Echo/Chat")
+	if err != nil {
+		t.Fatal("failed to open chat:", err)
+	}
+	if err := chat.SendMsg(wrapperspb.String("a")); err != nil {
+		t.Fatal("failed to send:", err)
+	}
+	cancel()
+	reply := &wrapperspb.StringValue{}
+	for chat.RecvMsg(reply) == nil {
+	}
+	got := clientPipeline.Values(t)
+	if n := got["rpc.client.request_count{rpc.grpc.status_code=Canceled,rpc.method=/grpcmetrics.test.Echo/Chat}"]; n != 1 {
+		t.Errorf("got %v canceled chats, want 1 in %v", n, got)
+	}
+}
+
+// spanRecorder keeps the ended spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.SpanData
+}
+
+func (r *spanRecorder) OnEnd(s *tracing.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) ForceFlush(context.Context) error { return nil }
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestPropagation(t *testing.T) {
+	recorder := &spanRecorder{}
+	tp := tracing.NewTracerProvider(tracing.WithSpanProcessor(recorder))
+	serverPipeline, clientPipeline := metrictest.NewPipeline(), metrictest.NewPipeline()
+	conn := dial(t, serverPipeline, clientPipeline, WithTracerProvider(tp), WithBaggageLabels("tenant"))
+
+	md := metadata.Pairs("request-id", "42")
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	ctx = baggage.ContextWithValues(ctx, label.String("tenant", "acme"), label.String("user", "jane"))
+	reply := &wrapperspb.StringValue{}
+	if err := conn.Invoke(ctx, "/grpcmetrics.test.Echo/Echo", wrapperspb.String("hello"), reply); err != nil {
+		t.Fatal("echo failed:", err)
+	}
+	if len(md) != 1 {
+		t.Errorf("the outgoing metadata was modified: %v", md)
+	}
+
+	recorder.mu.Lock()
+	spans := map[trace.SpanKind]*tracing.SpanData{}
+	for _, s := range recorder.spans {
+		spans[s.SpanKind] = s
+	}
+	recorder.mu.Unlock()
+	server, client := spans[trace.SpanKindServer], spans[trace.SpanKindClient]
+	if server == nil || client == nil {
+		t.Fatalf("got spans %v, want a client and a server span", spans)
+	}
+	if server.SpanContext.TraceID != client.SpanContext.TraceID || server.ParentSpanID != client.SpanContext.SpanID || !server.HasRemoteParent {
+		t.Errorf("the server span %v is not the remote child of the client span %v", server.SpanContext, client.SpanContext)
+	}
+	if server.Name != "grpcmetrics.test.Echo/Echo" {
+		t.Errorf("got span name %q", server.Name)
+	}
+
+	for side, p := range map[string]metrictest.Pipeline{"server": serverPipeline, "client": clientPipeline} {
+		name := "rpc." + side + ".request_count{rpc.grpc.status_code=OK,rpc.method=/grpcmetrics.test.Echo/Echo,tenant=acme}"
+		if got := p.Values(t)[name]; got != 1 {
+			t.Errorf("%s: got %v, want 1", name, got)
+		}
+	}
+}
+
+// sizer stands for a gogo generated message.
+type sizer int
+
+func (s sizer) Size() int { return int(s) }
+
+func TestSize(t *testing.T) {
+	for _, tc := range []struct {
+		msg  interface{}
+		want int64
+	}{
+		{wrapperspb.String("abc"), 5},
+		{sizer(42), 42},
+		{"not a message", 0},
+	} {
+		if got := size(tc.msg); got != tc.want {
+			t.Errorf("size(%T) = %d, want %d", tc.msg, got, tc.want)
+		}
+	}
+}
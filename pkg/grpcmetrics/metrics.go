@@ -0,0 +1,193 @@
+// Package grpcmetrics provides gRPC interceptors recording the RPCs of
+// servers and clients: their count and latency, and the number and size of
+// the messages they exchanged, per method and status code.
+//
+//	server, err := grpcmetrics.NewServer()
+//	s := grpc.NewServer(
+//		grpc.UnaryInterceptor(server.UnaryInterceptor()),
+//		grpc.StreamInterceptor(server.StreamInterceptor()))
+//
+//	client, err := grpcmetrics.NewClient()
+//	conn, err := grpc.Dial(target,
+//		grpc.WithUnaryInterceptor(client.UnaryInterceptor()),
+//		grpc.WithStreamInterceptor(client.StreamInterceptor()))
+//
+// Message sizes are the sizes of the protobuf encoded messages, before
+// compression. Messages that are not protobuf messages are counted with a
+// size of zero.
+//
+// RPCs are traced too: the client interceptors start a client span and
+// propagate it, with the baggage, in the metadata of the RPC, and the
+// server interceptors continue the trace with a server span.
+package grpcmetrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/baggage"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Label keys of the recorded metrics.
+const (
+	MethodKey     = label.Key("rpc.method")
+	StatusCodeKey = label.Key("rpc.grpc.status_code")
+	DirectionKey  = label.Key("rpc.message.direction")
+)
+
+// Values of DirectionKey.
+const (
+	Sent     = "sent"
+	Received = "received"
+)
+
+// instruments are the instruments of one side, server or client.
+type instruments struct {
+	requests metric.Int64Counter
+	duration metric.Float64ValueRecorder
+	messages metric.Int64Counter
+	bytes    metric.Int64Counter
+
+	tracer      trace.Tracer
+	spanKind    trace.SpanKind
+	propagators propagation.TextMapPropagator
+	baggageKeys []label.Key
+}
+
+func newInstruments(cfg config, side string, kind trace.SpanKind) (*instruments, error) {
+	meter := cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/grpcmetrics")
+	prefix := "rpc." + side + "."
+	i := &instruments{
+		tracer:      cfg.tracerProvider.Tracer("github.com/skonto/test-otel/pkg/grpcmetrics"),
+		spanKind:    kind,
+		propagators: cfg.propagators,
+		baggageKeys: cfg.baggageKeys,
+	}
+	var err error
+	if i.requests, err = meter.NewInt64Counter(prefix+"request_count",
+		metric.WithDescription("The number of RPCs completed")); err != nil {
+		return nil, fmt.Errorf("grpcmetrics: %w", err)
+	}
+	if i.duration, err = meter.NewFloat64ValueRecorder(prefix+"duration",
+		metric.WithDescription("The duration of RPCs"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, fmt.Errorf("grpcmetrics: %w", err)
+	}
+	if i.messages, err = meter.NewInt64Counter(prefix+"message_count",
+		metric.WithDescription("The number of messages sent and received")); err != nil {
+		return nil, fmt.Errorf("grpcmetrics: %w", err)
+	}
+	if i.bytes, err = meter.NewInt64Counter(prefix+"message_bytes",
+		metric.WithDescription("The size of the messages sent and received"),
+		metric.WithUnit(unit.Bytes)); err != nil {
+		return nil, fmt.Errorf("grpcmetrics: %w", err)
+	}
+	return i, nil
+}
+
+// rpc tracks one RPC until it completes. Messages can be sent and received
+// from different goroutines, the counts are updated atomically.
+type rpc struct {
+	// First for the alignment of atomic operations on 32 bits platforms.
+	sent, received           int64
+	sentBytes, receivedBytes int64
+	method                   string
+	start                    time.Time
+	span                     trace.Span
+	// The allow-listed baggage members.
+	baggage []label.KeyValue
+}
+
+// start starts the span of an RPC, ctx carries the trace context and the
+// baggage of the caller.
+func (i *instruments) start(ctx context.Context, method string) (context.Context, *rpc) {
+	service, name := splitMethod(method)
+	ctx, span := i.tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(i.spanKind),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCServiceKey.String(service), semconv.RPCMethodKey.String(name)))
+	r := &rpc{method: method, start: time.Now(), span: span}
+	for _, k := range i.baggageKeys {
+		if v := baggage.Value(ctx, k); v.Type() != label.INVALID {
+			r.baggage = append(r.baggage, label.KeyValue{Key: k, Value: v})
+		}
+	}
+	return ctx, r
+}
+
+func (r *rpc) send(msg interface{}) {
+	atomic.AddInt64(&r.sent, 1)
+	atomic.AddInt64(&r.sentBytes, size(msg))
+}
+
+func (r *rpc) receive(msg interface{}) {
+	atomic.AddInt64(&r.received, 1)
+	atomic.AddInt64(&r.receivedBytes, size(msg))
+}
+
+func (i *instruments) record(ctx context.Context, r *rpc, code codes.Code) {
+	elapsed := float64(time.Since(r.start)) / float64(time.Millisecond)
+	labels := append([]label.KeyValue{MethodKey.String(r.method), StatusCodeKey.String(code.String())}, r.baggage...)
+	i.requests.Add(ctx, 1, labels...)
+	i.duration.Record(ctx, elapsed, labels...)
+	sent := append(labels[:len(labels):len(labels)], DirectionKey.String(Sent))
+	received := append(labels[:len(labels):len(labels)], DirectionKey.String(Received))
+	i.messages.Add(ctx, atomic.LoadInt64(&r.sent), sent...)
+	i.messages.Add(ctx, atomic.LoadInt64(&r.received), received...)
+	i.bytes.Add(ctx, atomic.LoadInt64(&r.sentBytes), sent...)
+	i.bytes.Add(ctx, atomic.LoadInt64(&r.receivedBytes), received...)
+
+	r.span.SetAttributes(StatusCodeKey.Int64(int64(code)))
+	if code != codes.OK {
+		r.span.SetStatus(otelcodes.Error, code.String())
+	}
+	r.span.End()
+}
+
+// splitMethod splits "/package.Service/Method" into its service and method.
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "", fullMethod
+}
+
+// metadataCarrier adapts the metadata of an RPC to the propagators.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// size returns the encoded size of a message. The gogo generated messages,
+// eg. those the OTLP exporter sends, are not proto.Messages but have a Size
+// method.
+func size(msg interface{}) int64 {
+	switch m := msg.(type) {
+	case proto.Message:
+		return int64(proto.Size(m))
+	case interface{ Size() int }:
+		return int64(m.Size())
+	}
+	return 0
+}
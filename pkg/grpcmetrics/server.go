@@ -0,0 +1,85 @@
+package grpcmetrics
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Server records the RPCs served by a gRPC server.
+type Server struct {
+	inst *instruments
+}
+
+// NewServer creates the rpc.server.* instruments.
+func NewServer(opts ...Option) (*Server, error) {
+	inst, err := newInstruments(newConfig(opts...), "server", trace.SpanKindServer)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{inst: inst}, nil
+}
+
+// UnaryInterceptor returns the interceptor recording unary RPCs.
+func (s *Server) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, r := s.start(ctx, info.FullMethod)
+		r.receive(req)
+		resp, err := handler(ctx, req)
+		if err == nil {
+			r.send(resp)
+		}
+		s.inst.record(ctx, r, status.Code(err))
+		return resp, err
+	}
+}
+
+// StreamInterceptor returns the interceptor recording streaming RPCs.
+func (s *Server) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, r := s.start(ss.Context(), info.FullMethod)
+		stream := &serverStream{ServerStream: ss, ctx: ctx, rpc: r}
+		err := handler(srv, stream)
+		s.inst.record(ctx, r, status.Code(err))
+		return err
+	}
+}
+
+// start continues the trace of the caller, extracted with its baggage
+// from the incoming metadata.
+func (s *Server) start(ctx context.Context, method string) (context.Context, *rpc) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = s.inst.propagators.Extract(ctx, metadataCarrier(md))
+	return s.inst.start(ctx, method)
+}
+
+// serverStream counts the messages of a stream, its context carries the
+// span of the RPC.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	rpc *rpc
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.rpc.send(m)
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.rpc.receive(m)
+	}
+	return err
+}
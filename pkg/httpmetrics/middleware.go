@@ -0,0 +1,207 @@
+// Package httpmetrics is a net/http middleware recording the requests of a
+// server: their count, latency and response size, and the requests in
+// flight. Each request is also traced with a server span.
+//
+// Requests are labeled with the route template they were served by, eg.
+// "/api/items/{id}", rather than their path so that IDs in paths do not
+// create a series each. The route is given when wrapping the handler:
+//
+//	m, err := httpmetrics.New()
+//	mux.Handle("/api/items/", m.Handle("/api/items/{id}", itemsHandler))
+package httpmetrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+)
+
+// Label keys of the recorded metrics.
+const (
+	RouteKey       = label.Key("http.route")
+	MethodKey      = label.Key("http.method")
+	StatusClassKey = label.Key("http.status_class")
+)
+
+// OtherMethod labels the requests with a non standard method, clients
+// choose methods freely.
+const OtherMethod = "OTHER"
+
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Middleware records the requests of the handlers it wraps.
+type Middleware struct {
+	requests     metric.Int64Counter
+	duration     metric.Float64ValueRecorder
+	inFlight     metric.Int64UpDownCounter
+	responseSize metric.Int64ValueRecorder
+	tracer       trace.Tracer
+	propagators  propagation.TextMapPropagator
+	baggageKeys  []label.Key
+}
+
+// New creates the instruments of the middleware.
+func New(opts ...Option) (*Middleware, error) {
+	cfg := newConfig(opts...)
+	meter := cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/httpmetrics")
+	m := &Middleware{
+		tracer:      cfg.tracerProvider.Tracer("github.com/skonto/test-otel/pkg/httpmetrics"),
+		propagators: cfg.propagators,
+		baggageKeys: cfg.baggageKeys,
+	}
+	var err error
+	if m.requests, err = meter.NewInt64Counter("http.server.request_count",
+		metric.WithDescription("The number of requests served")); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if m.duration, err = meter.NewFloat64ValueRecorder("http.server.duration",
+		metric.WithDescription("The time taken to serve requests"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if m.inFlight, err = meter.NewInt64UpDownCounter("http.server.active_requests",
+		metric.WithDescription("The number of requests being served")); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if m.responseSize, err = meter.NewInt64ValueRecorder("http.server.response_size",
+		metric.WithDescription("The size of response bodies"),
+		metric.WithUnit(unit.Bytes)); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	return m, nil
+}
+
+// Handle wraps a handler serving route, a template such as
+// "/api/items/{id}" used as the route label. The span of the request
+// continues the trace of the caller, if propagated.
+func (m *Middleware) Handle(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := m.propagators.Extract(r.Context(), r.Header)
+		ctx, span := m.tracer.Start(ctx, route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, r)...))
+		r = r.WithContext(ctx)
+		labels := baggageLabels(ctx, m.baggageKeys, []label.KeyValue{
+			RouteKey.String(route),
+			MethodKey.String(method(r.Method)),
+		})
+		m.inFlight.Add(ctx, 1, labels...)
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		completed := false
+		defer func() {
+			// Recorded even if the handler panics, the server recovers
+			// and closes the connection without a response.
+			if !completed && !rw.wroteHeader {
+				rw.status = http.StatusInternalServerError
+			}
+			elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+			m.inFlight.Add(ctx, -1, labels...)
+			m.record(ctx, elapsed, rw, labels)
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rw.status)...)
+			span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(rw.status))
+			span.End()
+		}()
+		h.ServeHTTP(rw, r)
+		completed = true
+	})
+}
+
+// HandleFunc wraps a handler function serving route.
+func (m *Middleware) HandleFunc(route string, f func(http.ResponseWriter, *http.Request)) http.Handler {
+	return m.Handle(route, http.HandlerFunc(f))
+}
+
+func (m *Middleware) record(ctx context.Context, elapsed float64, rw *responseWriter, labels []label.KeyValue) {
+	labels = append(labels[:len(labels):len(labels)], StatusClassKey.String(statusClass(rw.status)))
+	m.requests.Add(ctx, 1, labels...)
+	m.duration.Record(ctx, elapsed, labels...)
+	m.responseSize.Record(ctx, rw.written, labels...)
+}
+
+func method(m string) string {
+	if methods[m] {
+		return m
+	}
+	return OtherMethod
+}
+
+// baggageLabels appends the allow-listed baggage members of ctx to labels.
+func baggageLabels(ctx context.Context, keys []label.Key, labels []label.KeyValue) []label.KeyValue {
+	for _, k := range keys {
+		if v := baggage.Value(ctx, k); v.Type() != label.INVALID {
+			labels = append(labels, label.KeyValue{Key: k, Value: v})
+		}
+	}
+	return labels
+}
+
+// statusClass returns eg. "2xx" for 200.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// responseWriter records the status and the size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, a no-op if the wrapped ResponseWriter
+// does not flush.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for the ResponseWriters supporting it.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("httpmetrics: %T does not support hijacking", w.ResponseWriter)
+	}
+	return h.Hijack()
+}
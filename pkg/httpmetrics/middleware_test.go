@@ -0,0 +1,195 @@
+package httpmetrics
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newServer(t *testing.T, p metrictest.Pipeline) *httptest.Server {
+	m, err := New(WithMeterProvider(p.MeterProvider()))
+	if err != nil {
+		t.Fatal("failed to create middleware:", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/items/", m.HandleFunc("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/items/")
+		if id == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"id":%q}`, id)
+	}))
+	mux.Handle("/panic", m.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method, url string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The panicking handler closes the connection.
+		return
+	}
+	_, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+func TestMiddleware(t *testing.T) {
+	p := metrictest.NewPipeline()
+	srv := newServer(t, p)
+	do(t, http.MethodGet, srv.URL+"/api/items/1")
+	do(t, http.MethodGet, srv.URL+"/api/items/22")
+	do(t, http.MethodGet, srv.URL+"/api/items/missing")
+	do(t, "PURGE", srv.URL+"/api/items/1")
+	// Not a GET, the client would retry it.
+	do(t, http.MethodPost, srv.URL+"/panic")
+
+	got := p.Values(t)
+	ok := "http.method=GET,http.route=/api/items/{id},http.status_class=2xx"
+	notFound := "http.method=GET,http.route=/api/items/{id},http.status_class=4xx"
+	other := "http.method=OTHER,http.route=/api/items/{id},http.status_class=2xx"
+	panicked := "http.method=POST,http.route=/panic,http.status_class=5xx"
+	want := map[string]float64{
+		"http.server.request_count{" + ok + "}":             2,
+		"http.server.request_count{" + notFound + "}":       1,
+		"http.server.request_count{" + other + "}":          1,
+		"http.server.request_count{" + panicked + "}":       1,
+		"http.server.duration{" + ok + "}.count":            2,
+		"http.server.response_size{" + ok + "}":             float64(len(`{"id":"1"}`) + len(`{"id":"22"}`)),
+		"http.server.response_size{" + notFound + "}":       float64(len("404 page not found\n")),
+		"http.server.response_size{" + panicked + "}.count": 1,
+		// In flight requests are back to zero.
+		"http.server.active_requests{http.method=GET,http.route=/api/items/{id}}": 0,
+		"http.server.active_requests{http.method=POST,http.route=/panic}":         0,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+	for name := range got {
+		if strings.Contains(name, "/api/items/1") || strings.Contains(name, "PURGE") {
+			t.Errorf("%s is labeled with a path or an unknown method", name)
+		}
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	p := metrictest.NewPipeline()
+	m, err := New(WithMeterProvider(p.MeterProvider()))
+	if err != nil {
+		t.Fatal("failed to create middleware:", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(m.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		do(t, http.MethodPost, srv.URL)
+	}()
+	<-started
+
+	inFlight := "http.server.active_requests{http.method=POST,http.route=/slow}"
+	if got := p.Values(t)[inFlight]; got != 1 {
+		t.Errorf("got %v requests in flight, want 1", got)
+	}
+	close(release)
+	<-done
+	got := p.Values(t)
+	if got[inFlight] != 0 {
+		t.Errorf("got %v requests in flight, want 0", got[inFlight])
+	}
+	if count := got["http.server.request_count{http.method=POST,http.route=/slow,http.status_class=2xx}"]; count != 1 {
+		t.Errorf("got %v requests, want 1", count)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, want := range map[int]string{101: "1xx", 200: "2xx", 302: "3xx", 404: "4xx", 503: "5xx", 0: "unknown", 999: "unknown"} {
+		if got := statusClass(status); got != want {
+			t.Errorf("%d: got %s, want %s", status, got, want)
+		}
+	}
+}
+
+// spanRecorder keeps the ended spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.SpanData
+}
+
+func (r *spanRecorder) OnEnd(s *tracing.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+}
+
+func (r *spanRecorder) ForceFlush(context.Context) error { return nil }
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func TestSpans(t *testing.T) {
+	recorder := &spanRecorder{}
+	m, err := New(
+		WithMeterProvider(metrictest.NewPipeline().MeterProvider()),
+		WithTracerProvider(tracing.NewTracerProvider(tracing.WithSpanProcessor(recorder))),
+	)
+	if err != nil {
+		t.Fatal("failed to create middleware:", err)
+	}
+	var handlerSpan trace.SpanContext
+	srv := httptest.NewServer(m.HandleFunc("/api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	do(t, http.MethodGet, srv.URL+"/api/items/1")
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(recorder.spans))
+	}
+	s := recorder.spans[0]
+	if s.Name != "/api/items/{id}" || s.SpanKind != trace.SpanKindServer || s.SpanContext.SpanID != handlerSpan.SpanID {
+		t.Errorf("got span %s %v %v, want the server span of the handler %v", s.Name, s.SpanKind, s.SpanContext, handlerSpan)
+	}
+	if s.StatusCode != codes.Error {
+		t.Errorf("got status %v, want an error", s.StatusCode)
+	}
+	attrs := map[label.Key]label.Value{}
+	for _, kv := range s.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[semconv.HTTPStatusCodeKey].AsInt64() != http.StatusServiceUnavailable ||
+		attrs[semconv.HTTPTargetKey].AsString() != "/api/items/1" ||
+		attrs[semconv.HTTPRouteKey].AsString() != "/api/items/{id}" {
+		t.Errorf("got attributes %v", s.Attributes)
+	}
+}
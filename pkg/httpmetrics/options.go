@@ -0,0 +1,109 @@
+package httpmetrics
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultMaxHosts is the number of hosts a Transport labels its requests
+// with before labeling requests to further hosts OtherHost.
+const DefaultMaxHosts = 100
+
+// config contains the settings of the middleware and the transport.
+type config struct {
+	meterProvider  metric.MeterProvider
+	tracerProvider trace.TracerProvider
+	propagators    propagation.TextMapPropagator
+	baggageKeys    []label.Key
+	maxHosts       int
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		meterProvider:  otel.GetMeterProvider(),
+		tracerProvider: otel.GetTracerProvider(),
+		propagators:    propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+		maxHosts:       DefaultMaxHosts,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the middleware and the
+// transport.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithMeterProvider sets the metric.MeterProvider the requests are recorded
+// with, the global one by default.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
+
+// WithTracerProvider sets the trace.TracerProvider the middleware starts
+// server spans with, the global one by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return tracerProviderOption{tp}
+}
+
+type tracerProviderOption struct{ trace.TracerProvider }
+
+func (o tracerProviderOption) Apply(c *config) {
+	c.tracerProvider = o.TracerProvider
+}
+
+// WithPropagators sets the propagators extracting the trace context and the
+// baggage from the requests served and injecting them in the requests
+// sent, the W3C traceparent, tracestate and baggage headers by default.
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return propagatorsOption{p}
+}
+
+type propagatorsOption struct{ propagation.TextMapPropagator }
+
+func (o propagatorsOption) Apply(c *config) {
+	c.propagators = o.TextMapPropagator
+}
+
+// WithBaggageLabels promotes the baggage members with the given keys to
+// labels of the metrics, eg. a tenant or a revision. Only allow-listed
+// members are promoted, their values come from the callers: they should
+// have few distinct values or be capped with pkg/cardinality.
+func WithBaggageLabels(keys ...label.Key) Option {
+	return baggageLabelsOption(keys)
+}
+
+type baggageLabelsOption []label.Key
+
+func (o baggageLabelsOption) Apply(c *config) {
+	c.baggageKeys = append(c.baggageKeys, o...)
+}
+
+// WithMaxHosts sets the number of hosts a Transport labels its requests
+// with, DefaultMaxHosts by default. Requests to further hosts are labeled
+// OtherHost. A limit of zero or less removes the limit, for clients calling
+// a known set of hosts only.
+func WithMaxHosts(n int) Option {
+	return maxHostsOption(n)
+}
+
+type maxHostsOption int
+
+func (o maxHostsOption) Apply(c *config) {
+	c.maxHosts = int(o)
+}
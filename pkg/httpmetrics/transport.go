@@ -0,0 +1,293 @@
+package httpmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+)
+
+// Label keys of the metrics recorded by a Transport.
+const (
+	HostKey      = label.Key("http.host")
+	PhaseKey     = label.Key("http.client.phase")
+	ErrorTypeKey = label.Key("error.type")
+)
+
+// OtherHost labels the requests to the hosts past the limit set by
+// WithMaxHosts.
+const OtherHost = "other"
+
+// ErrorStatusClass labels the requests that failed without a response.
+const ErrorStatusClass = "error"
+
+// Values of PhaseKey.
+const (
+	PhaseDNS     = "dns"
+	PhaseConnect = "connect"
+	PhaseTLS     = "tls"
+)
+
+// Values of ErrorTypeKey.
+const (
+	ErrorCanceled          = "canceled"
+	ErrorTimeout           = "timeout"
+	ErrorDNS               = "dns"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorTLS               = "tls"
+	ErrorOther             = "other"
+)
+
+// Transport is an http.RoundTripper recording the requests it sends: their
+// count and latency, the time spent resolving, connecting and handshaking
+// when a new connection is needed, and their errors. Each request is traced
+// with a client span, propagated to the server with the request headers.
+//
+// The latency is the time until the response headers are received, reading
+// the body is not included.
+//
+//	t, err := httpmetrics.NewTransport(http.DefaultTransport)
+//	client := &http.Client{Transport: t}
+type Transport struct {
+	base     http.RoundTripper
+	requests metric.Int64Counter
+	duration metric.Float64ValueRecorder
+	phases   metric.Float64ValueRecorder
+	errors   metric.Int64Counter
+
+	tracer      trace.Tracer
+	propagators propagation.TextMapPropagator
+	baggageKeys []label.Key
+
+	maxHosts   int
+	mu         sync.RWMutex
+	hosts      map[string]bool
+	overflowed bool
+}
+
+// NewTransport wraps base, http.DefaultTransport if nil, and creates the
+// instruments of the transport.
+func NewTransport(base http.RoundTripper, opts ...Option) (*Transport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cfg := newConfig(opts...)
+	meter := cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/httpmetrics")
+	t := &Transport{
+		base:        base,
+		tracer:      cfg.tracerProvider.Tracer("github.com/skonto/test-otel/pkg/httpmetrics"),
+		propagators: cfg.propagators,
+		baggageKeys: cfg.baggageKeys,
+		maxHosts:    cfg.maxHosts,
+		hosts:       map[string]bool{},
+	}
+	var err error
+	if t.requests, err = meter.NewInt64Counter("http.client.request_count",
+		metric.WithDescription("The number of requests sent")); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if t.duration, err = meter.NewFloat64ValueRecorder("http.client.duration",
+		metric.WithDescription("The time taken to receive the response headers of requests"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if t.phases, err = meter.NewFloat64ValueRecorder("http.client.phase_duration",
+		metric.WithDescription("The time taken to resolve, connect to and handshake with hosts"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	if t.errors, err = meter.NewInt64Counter("http.client.errors",
+		metric.WithDescription("The number of requests that failed without a response")); err != nil {
+		return nil, fmt.Errorf("httpmetrics: %w", err)
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), "HTTP "+method(req.Method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(req)...))
+	defer span.End()
+	hostLabel := HostKey.String(t.host(req.URL.Host))
+	pt := &phaseTrace{t: t, ctx: ctx, hostLabel: hostLabel}
+	// RoundTrippers must not modify the request, the headers are copied
+	// before injecting the trace context.
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, pt.clientTrace()))
+	req.Header = header
+	t.propagators.Inject(ctx, header)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+
+	class := ErrorStatusClass
+	if err == nil {
+		class = statusClass(resp.StatusCode)
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+	} else {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		t.errors.Add(ctx, 1, baggageLabels(ctx, t.baggageKeys, []label.KeyValue{hostLabel, ErrorTypeKey.String(errorType(err))})...)
+	}
+	labels := baggageLabels(ctx, t.baggageKeys, []label.KeyValue{hostLabel, MethodKey.String(method(req.Method)), StatusClassKey.String(class)})
+	t.requests.Add(ctx, 1, labels...)
+	t.duration.Record(ctx, elapsed, labels...)
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped
+// transport, for http.Client.CloseIdleConnections.
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := t.base.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// host returns the host label of a request, OtherHost once maxHosts hosts
+// were seen.
+func (t *Transport) host(host string) string {
+	host = strings.ToLower(host)
+	if t.maxHosts <= 0 {
+		return host
+	}
+	t.mu.RLock()
+	seen := t.hosts[host]
+	t.mu.RUnlock()
+	if seen {
+		return host
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts[host] {
+		return host
+	}
+	if len(t.hosts) >= t.maxHosts {
+		if !t.overflowed {
+			t.overflowed = true
+			otel.Handle(fmt.Errorf("httpmetrics: more than %d hosts called, further hosts are labeled %q", t.maxHosts, OtherHost))
+		}
+		return OtherHost
+	}
+	t.hosts[host] = true
+	return host
+}
+
+// phaseTrace records the phases of establishing a connection. The dials of
+// an address family can run concurrently, their starts are kept by address.
+type phaseTrace struct {
+	t         *Transport
+	ctx       context.Context
+	hostLabel label.KeyValue
+
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+}
+
+func (p *phaseTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			p.mu.Lock()
+			p.dnsStart = time.Now()
+			p.mu.Unlock()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			p.mu.Lock()
+			start := p.dnsStart
+			p.mu.Unlock()
+			p.record(PhaseDNS, start, info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			p.mu.Lock()
+			if p.connectStart == nil {
+				p.connectStart = map[string]time.Time{}
+			}
+			p.connectStart[network+addr] = time.Now()
+			p.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			p.mu.Lock()
+			start := p.connectStart[network+addr]
+			p.mu.Unlock()
+			p.record(PhaseConnect, start, err)
+		},
+		TLSHandshakeStart: func() {
+			p.mu.Lock()
+			p.tlsStart = time.Now()
+			p.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			p.mu.Lock()
+			start := p.tlsStart
+			p.mu.Unlock()
+			p.record(PhaseTLS, start, err)
+		},
+	}
+}
+
+// record records a successful phase, failures are counted as errors of the
+// request.
+func (p *phaseTrace) record(phase string, start time.Time, err error) {
+	if err != nil || start.IsZero() {
+		return
+	}
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+	p.t.phases.Record(p.ctx, elapsed, p.hostLabel, PhaseKey.String(phase))
+}
+
+// errorType classifies the error of a request.
+func errorType(err error) string {
+	var (
+		dnsErr      *net.DNSError
+		netErr      net.Error
+		recordErr   tls.RecordHeaderError
+		authErr     x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		certErr     x509.CertificateInvalidError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return ErrorConnectionReset
+	case errors.As(err, &recordErr), errors.As(err, &authErr), errors.As(err, &hostnameErr), errors.As(err, &certErr):
+		return ErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
@@ -0,0 +1,233 @@
+package httpmetrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newClient(t *testing.T, p metrictest.Pipeline, base http.RoundTripper, opts ...Option) *http.Client {
+	tr, err := NewTransport(base, append(opts, WithMeterProvider(p.MeterProvider()))...)
+	if err != nil {
+		t.Fatal("failed to create transport:", err)
+	}
+	client := &http.Client{Transport: tr}
+	t.Cleanup(client.CloseIdleConnections)
+	return client
+}
+
+func get(t *testing.T, client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	p := metrictest.NewPipeline()
+	client := newClient(t, p, http.DefaultTransport.(*http.Transport).Clone())
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, path := range []string{"/a", "/b", "/missing"} {
+		if err := get(t, client, srv.URL+path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := p.Values(t)
+	ok := "http.host=" + host + ",http.method=GET,http.status_class=2xx"
+	want := map[string]float64{
+		"http.client.request_count{" + ok + "}":                                                   2,
+		"http.client.request_count{http.host=" + host + ",http.method=GET,http.status_class=4xx}": 1,
+		"http.client.duration{" + ok + "}.count":                                                  2,
+		// The connection is reused.
+		"http.client.phase_duration{http.client.phase=connect,http.host=" + host + "}.count": 1,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+	for name := range got {
+		if strings.Contains(name, "/a") || strings.HasPrefix(name, "http.client.errors") {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+}
+
+func TestTransportTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	p := metrictest.NewPipeline()
+	if err := get(t, newClient(t, p, srv.Client().Transport), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	// Not trusting the certificate of the server.
+	if err := get(t, newClient(t, p, http.DefaultTransport.(*http.Transport).Clone()), srv.URL); err == nil {
+		t.Fatal("got no error from an untrusted server")
+	}
+
+	got := p.Values(t)
+	want := map[string]float64{
+		"http.client.phase_duration{http.client.phase=tls,http.host=" + host + "}.count":            1,
+		"http.client.request_count{http.host=" + host + ",http.method=GET,http.status_class=2xx}":   1,
+		"http.client.request_count{http.host=" + host + ",http.method=GET,http.status_class=error}": 1,
+		"http.client.errors{error.type=tls,http.host=" + host + "}":                                 1,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := lis.Addr().String()
+	lis.Close()
+
+	p := metrictest.NewPipeline()
+	client := newClient(t, p, http.DefaultTransport.(*http.Transport).Clone())
+	if err := get(t, client, "http://"+refused); err == nil {
+		t.Fatal("got no error from a closed port")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+refused, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("got no error from a canceled request")
+	}
+
+	got := p.Values(t)
+	want := map[string]float64{
+		"http.client.errors{error.type=connection_refused,http.host=" + refused + "}":                  1,
+		"http.client.errors{error.type=canceled,http.host=" + refused + "}":                            1,
+		"http.client.request_count{http.host=" + refused + ",http.method=GET,http.status_class=error}": 2,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+}
+
+func TestTransportMaxHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	port := srv.URL[strings.LastIndex(srv.URL, ":"):]
+
+	p := metrictest.NewPipeline()
+	client := newClient(t, p, http.DefaultTransport.(*http.Transport).Clone(), WithMaxHosts(1))
+	for _, host := range []string{"127.0.0.1", "localhost", "127.0.0.1", "LOCALHOST"} {
+		if err := get(t, client, "http://"+host+port); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := p.Values(t)
+	want := map[string]float64{
+		"http.client.request_count{http.host=127.0.0.1" + port + ",http.method=GET,http.status_class=2xx}": 2,
+		"http.client.request_count{http.host=other,http.method=GET,http.status_class=2xx}":                 2,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{context.DeadlineExceeded, ErrorTimeout},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, ErrorDNS},
+		{&net.OpError{Op: "dial", Err: &timeoutError{}}, ErrorTimeout},
+		{errors.New("unexpected EOF"), ErrorOther},
+	} {
+		if got := errorType(tc.err); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestPropagation(t *testing.T) {
+	recorder := &spanRecorder{}
+	tp := tracing.NewTracerProvider(tracing.WithSpanProcessor(recorder))
+	serverPipeline, clientPipeline := metrictest.NewPipeline(), metrictest.NewPipeline()
+	m, err := New(WithMeterProvider(serverPipeline.MeterProvider()), WithTracerProvider(tp), WithBaggageLabels("tenant"))
+	if err != nil {
+		t.Fatal("failed to create middleware:", err)
+	}
+	srv := httptest.NewServer(m.HandleFunc("/api/items/{id}", func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	client := newClient(t, clientPipeline, nil, WithTracerProvider(tp), WithBaggageLabels("tenant"))
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("tenant", "acme"), label.String("user", "jane"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/items/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("request failed:", err)
+	}
+	resp.Body.Close()
+	if len(req.Header) != 0 {
+		t.Errorf("the request headers were modified: %v", req.Header)
+	}
+
+	recorder.mu.Lock()
+	spans := map[trace.SpanKind]*tracing.SpanData{}
+	for _, s := range recorder.spans {
+		spans[s.SpanKind] = s
+	}
+	recorder.mu.Unlock()
+	server, clientSpan := spans[trace.SpanKindServer], spans[trace.SpanKindClient]
+	if server == nil || clientSpan == nil {
+		t.Fatalf("got spans %v, want a client and a server span", spans)
+	}
+	if server.SpanContext.TraceID != clientSpan.SpanContext.TraceID || server.ParentSpanID != clientSpan.SpanContext.SpanID || !server.HasRemoteParent {
+		t.Errorf("the server span %v is not the remote child of the client span %v", server.SpanContext, clientSpan.SpanContext)
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if got := clientPipeline.Values(t)["http.client.request_count{http.host="+host+",http.method=GET,http.status_class=2xx,tenant=acme}"]; got != 1 {
+		t.Errorf("got %v client requests of tenant acme, want 1", got)
+	}
+	if got := serverPipeline.Values(t)["http.server.request_count{http.method=GET,http.route=/api/items/{id},http.status_class=2xx,tenant=acme}"]; got != 1 {
+		t.Errorf("got %v server requests of tenant acme, want 1", got)
+	}
+}
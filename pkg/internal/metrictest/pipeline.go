@@ -0,0 +1,202 @@
+// Package metrictest provides the metric pipeline the tests of the
+// instrumentation packages record to. It is collected on demand and its
+// checkpoint is flattened into values by metric name and label set:
+//
+//	p := metrictest.NewPipeline()
+//	counter := metric.Must(p.Meter()).NewInt64Counter("requests")
+//	counter.Add(ctx, 1, label.String("path", "/"))
+//	p.Values(t)["requests{path=/}"] // 1
+package metrictest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// config contains the settings of a pipeline.
+type config struct {
+	wrap          []func(export.Checkpointer) export.Checkpointer
+	resource      *resource.Resource
+	histogram     bool
+	boundaries    []float64
+	collectPeriod time.Duration
+}
+
+// Option supports configuring optional settings for a pipeline.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithWrap inserts the checkpointer wrap returns between the accumulator
+// and the processor, eg. to test a processor wrapping another one. The
+// last wrap is the closest to the accumulator.
+func WithWrap(wrap func(export.Checkpointer) export.Checkpointer) Option {
+	return wrapOption(wrap)
+}
+
+type wrapOption func(export.Checkpointer) export.Checkpointer
+
+// Apply implements Option.
+func (o wrapOption) Apply(c *config) {
+	c.wrap = append(c.wrap, o)
+}
+
+// WithResource sets the resource of the records, empty by default.
+func WithResource(res *resource.Resource) Option {
+	return resourceOption{res}
+}
+
+type resourceOption struct{ *resource.Resource }
+
+// Apply implements Option.
+func (o resourceOption) Apply(c *config) {
+	c.resource = o.Resource
+}
+
+// Pipeline is a cumulative pipeline with the inexpensive distribution for
+// ValueRecorders, without a period so that every Collect collects, unless
+// configured otherwise.
+type Pipeline struct {
+	Processor  *processor.Processor
+	Controller *controller.Controller
+}
+
+// WithHistogramDistribution aggregates ValueRecorders into histograms with
+// boundaries rather than with the inexpensive distribution.
+func WithHistogramDistribution(boundaries []float64) Option {
+	return boundariesOption(boundaries)
+}
+
+type boundariesOption []float64
+
+// Apply implements Option.
+func (o boundariesOption) Apply(c *config) {
+	c.histogram, c.boundaries = true, o
+}
+
+// WithCollectPeriod sets the minimum period between collections, none by
+// default so that every Collect collects.
+func WithCollectPeriod(period time.Duration) Option {
+	return collectPeriodOption(period)
+}
+
+type collectPeriodOption time.Duration
+
+// Apply implements Option.
+func (o collectPeriodOption) Apply(c *config) {
+	c.collectPeriod = time.Duration(o)
+}
+
+// NewPipeline returns a pipeline configured with opts.
+func NewPipeline(opts ...Option) Pipeline {
+	var cfg config
+	for _, opt := range opts {
+		opt.Apply(&cfg)
+	}
+	var selector export.AggregatorSelector = simple.NewWithInexpensiveDistribution()
+	if cfg.histogram {
+		selector = simple.NewWithHistogramDistribution(cfg.boundaries)
+	}
+	proc := processor.New(selector, export.CumulativeExportKindSelector(), processor.WithMemory(true))
+	var checkpointer export.Checkpointer = proc
+	for _, w := range cfg.wrap {
+		checkpointer = w(checkpointer)
+	}
+	return Pipeline{
+		Processor:  proc,
+		Controller: controller.New(checkpointer, controller.WithCollectPeriod(cfg.collectPeriod), controller.WithResource(cfg.resource)),
+	}
+}
+
+// MeterProvider returns the meter provider recording to the pipeline.
+func (p Pipeline) MeterProvider() metric.MeterProvider {
+	return p.Controller.MeterProvider()
+}
+
+// Meter returns a meter recording to the pipeline.
+func (p Pipeline) Meter() metric.Meter {
+	return p.MeterProvider().Meter("test")
+}
+
+// Values collects and returns the values by metric name and encoded label
+// set, eg. "requests{path=/}". Sums and last values are keyed by the
+// label set, counts, of ValueRecorders and ValueObservers, have a ".count"
+// suffix.
+func (p Pipeline) Values(t testing.TB) map[string]float64 {
+	t.Helper()
+	got := map[string]float64{}
+	p.forEach(t, func(r export.Record) error {
+		name := r.Descriptor().Name() + "{" + r.Labels().Encoded(label.DefaultEncoder()) + "}"
+		return value(r, name, got)
+	})
+	return got
+}
+
+// Sums collects and returns the values of an instrument by encoded label
+// set, eg. "path=/".
+func (p Pipeline) Sums(t testing.TB, name string) map[string]float64 {
+	t.Helper()
+	got := map[string]float64{}
+	p.forEach(t, func(r export.Record) error {
+		if r.Descriptor().Name() != name {
+			return nil
+		}
+		return value(r, r.Labels().Encoded(label.DefaultEncoder()), got)
+	})
+	return got
+}
+
+// CheckpointSet collects and returns the checkpoint of the processor, eg.
+// to test an exporter.
+func (p Pipeline) CheckpointSet(t testing.TB) export.CheckpointSet {
+	t.Helper()
+	if err := p.Controller.Collect(context.Background()); err != nil {
+		t.Fatal("failed to collect:", err)
+	}
+	return p.Processor.CheckpointSet()
+}
+
+func (p Pipeline) forEach(t testing.TB, f func(export.Record) error) {
+	t.Helper()
+	if err := p.CheckpointSet(t).ForEach(export.CumulativeExportKindSelector(), f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// value stores the value of a record, and its count, in got.
+func value(r export.Record, key string, got map[string]float64) error {
+	kind := r.Descriptor().NumberKind()
+	if c, ok := r.Aggregation().(aggregation.Count); ok {
+		count, err := c.Count()
+		if err != nil {
+			return err
+		}
+		got[key+".count"] = float64(count)
+	}
+	switch agg := r.Aggregation().(type) {
+	case aggregation.Sum:
+		sum, err := agg.Sum()
+		if err != nil {
+			return err
+		}
+		got[key] = sum.CoerceToFloat64(kind)
+	case aggregation.LastValue:
+		last, _, err := agg.LastValue()
+		if err != nil {
+			return err
+		}
+		got[key] = last.CoerceToFloat64(kind)
+	}
+	return nil
+}
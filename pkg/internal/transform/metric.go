@@ -0,0 +1,485 @@
+// Package transform converts OpenTelemetry SDK checkpoints to OTLP protobuf
+// structures. It is adapted from the internal transform package of
+// go.opentelemetry.io/otel/exporters/otlp, which cannot be imported from
+// outside that module, and targets the go.opentelemetry.io/proto/otlp types.
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric/number"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+var (
+	// ErrUnimplementedAgg is returned when a transformation of an unimplemented
+	// aggregator is attempted.
+	ErrUnimplementedAgg = errors.New("unimplemented aggregator")
+
+	// ErrIncompatibleAgg is returned when aggregation.Kind implies an
+	// interface conversion that has failed.
+	ErrIncompatibleAgg = errors.New("incompatible aggregation type")
+
+	// ErrUnknownValueType is returned when a transformation of an unknown value
+	// is attempted.
+	ErrUnknownValueType = errors.New("invalid value type")
+
+	// ErrTransforming is returned when an unexpected error is encountered transforming.
+	ErrTransforming = errors.New("transforming failed")
+)
+
+// toNanos returns the number of nanoseconds since the UNIX epoch.
+func toNanos(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// resourceBatch groups the metrics of a single resource by instrumentation
+// library and then by metric name.
+type resourceBatch struct {
+	rm        *metricpb.ResourceMetrics
+	libraries map[instrumentation.Library]map[string]*metricpb.Metric
+	order     []instrumentation.Library
+}
+
+// CheckpointSet transforms all records contained in a checkpoint into
+// batched OTLP ResourceMetrics. Records that fail to transform are skipped
+// and reported with an ErrTransforming error next to the batches that did
+// transform, it is up to the caller to decide whether to send them.
+func CheckpointSet(ctx context.Context, exportSelector export.ExportKindSelector, cps export.CheckpointSet) ([]*metricpb.ResourceMetrics, error) {
+	var errStrings []string
+	var order []label.Distinct
+	grouped := make(map[label.Distinct]*resourceBatch)
+
+	err := cps.ForEach(exportSelector, func(r export.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := Record(exportSelector, r)
+		if err != nil {
+			errStrings = append(errStrings, err.Error())
+			return nil
+		}
+		if m == nil {
+			return nil
+		}
+
+		rID := r.Resource().Equivalent()
+		rb, ok := grouped[rID]
+		if !ok {
+			rb = &resourceBatch{
+				rm:        &metricpb.ResourceMetrics{Resource: Resource(r.Resource())},
+				libraries: make(map[instrumentation.Library]map[string]*metricpb.Metric),
+			}
+			grouped[rID] = rb
+			order = append(order, rID)
+		}
+
+		il := instrumentation.Library{
+			Name:    r.Descriptor().InstrumentationName(),
+			Version: r.Descriptor().InstrumentationVersion(),
+		}
+		mb, ok := rb.libraries[il]
+		if !ok {
+			mb = make(map[string]*metricpb.Metric)
+			rb.libraries[il] = mb
+			rb.order = append(rb.order, il)
+		}
+
+		if existing, ok := mb[m.GetName()]; ok {
+			mergeDataPoints(existing, m)
+			return nil
+		}
+		mb[m.GetName()] = m
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rms []*metricpb.ResourceMetrics
+	for _, rID := range order {
+		rb := grouped[rID]
+		for _, il := range rb.order {
+			mb := rb.libraries[il]
+			ilm := &metricpb.InstrumentationLibraryMetrics{
+				InstrumentationLibrary: instrumentationLibrary(il),
+				Metrics:                make([]*metricpb.Metric, 0, len(mb)),
+			}
+			for _, m := range mb {
+				ilm.Metrics = append(ilm.Metrics, m)
+			}
+			sort.Slice(ilm.Metrics, func(i, j int) bool {
+				return ilm.Metrics[i].GetName() < ilm.Metrics[j].GetName()
+			})
+			rb.rm.InstrumentationLibraryMetrics = append(rb.rm.InstrumentationLibraryMetrics, ilm)
+		}
+		rms = append(rms, rb.rm)
+	}
+
+	if len(errStrings) > 0 {
+		return rms, fmt.Errorf("%w:\n -%s", ErrTransforming, strings.Join(errStrings, "\n -"))
+	}
+	return rms, nil
+}
+
+// mergeDataPoints appends the data points of src to dst. Both metrics are
+// expected to carry the same data type since they share a name.
+func mergeDataPoints(dst, src *metricpb.Metric) {
+	switch src.Data.(type) {
+	case *metricpb.Metric_IntGauge:
+		dst.GetIntGauge().DataPoints = append(dst.GetIntGauge().DataPoints, src.GetIntGauge().DataPoints...)
+	case *metricpb.Metric_IntHistogram:
+		dst.GetIntHistogram().DataPoints = append(dst.GetIntHistogram().DataPoints, src.GetIntHistogram().DataPoints...)
+	case *metricpb.Metric_IntSum:
+		dst.GetIntSum().DataPoints = append(dst.GetIntSum().DataPoints, src.GetIntSum().DataPoints...)
+	case *metricpb.Metric_DoubleGauge:
+		dst.GetDoubleGauge().DataPoints = append(dst.GetDoubleGauge().DataPoints, src.GetDoubleGauge().DataPoints...)
+	case *metricpb.Metric_DoubleHistogram:
+		dst.GetDoubleHistogram().DataPoints = append(dst.GetDoubleHistogram().DataPoints, src.GetDoubleHistogram().DataPoints...)
+	case *metricpb.Metric_DoubleSum:
+		dst.GetDoubleSum().DataPoints = append(dst.GetDoubleSum().DataPoints, src.GetDoubleSum().DataPoints...)
+	}
+}
+
+// Record transforms a Record into an OTLP Metric. An ErrIncompatibleAgg
+// error is returned if the Record Aggregator is not supported.
+func Record(exportSelector export.ExportKindSelector, r export.Record) (*metricpb.Metric, error) {
+	agg := r.Aggregation()
+	switch agg.Kind() {
+	case aggregation.MinMaxSumCountKind:
+		mmsc, ok := agg.(aggregation.MinMaxSumCount)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrIncompatibleAgg, agg)
+		}
+		return minMaxSumCount(r, mmsc)
+
+	case aggregation.HistogramKind:
+		h, ok := agg.(aggregation.Histogram)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrIncompatibleAgg, agg)
+		}
+		return histogramPoint(r, exportSelector.ExportKindFor(r.Descriptor(), aggregation.HistogramKind), h)
+
+	case aggregation.SumKind:
+		s, ok := agg.(aggregation.Sum)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrIncompatibleAgg, agg)
+		}
+		sum, err := s.Sum()
+		if err != nil {
+			return nil, err
+		}
+		return sumPoint(r, sum, r.StartTime(), r.EndTime(), exportSelector.ExportKindFor(r.Descriptor(), aggregation.SumKind), r.Descriptor().InstrumentKind().Monotonic())
+
+	case aggregation.LastValueKind:
+		lv, ok := agg.(aggregation.LastValue)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrIncompatibleAgg, agg)
+		}
+		value, tm, err := lv.LastValue()
+		if err != nil {
+			return nil, err
+		}
+		return gaugePoint(r, value, time.Time{}, tm)
+
+	case aggregation.ExactKind:
+		e, ok := agg.(aggregation.Points)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrIncompatibleAgg, agg)
+		}
+		pts, err := e.Points()
+		if err != nil {
+			return nil, err
+		}
+		return gaugeArray(r, pts)
+
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnimplementedAgg, agg)
+	}
+}
+
+func newMetric(record export.Record) *metricpb.Metric {
+	desc := record.Descriptor()
+	return &metricpb.Metric{
+		Name:        desc.Name(),
+		Description: desc.Description(),
+		Unit:        string(desc.Unit()),
+	}
+}
+
+func gaugeArray(record export.Record, points []aggregation.Point) (*metricpb.Metric, error) {
+	m := newMetric(record)
+	labels := stringKeyValues(record.Labels().Iter())
+
+	switch nk := record.Descriptor().NumberKind(); nk {
+	case number.Int64Kind:
+		var pts []*metricpb.IntDataPoint
+		for _, p := range points {
+			pts = append(pts, &metricpb.IntDataPoint{
+				Labels:            labels,
+				StartTimeUnixNano: toNanos(record.StartTime()),
+				TimeUnixNano:      toNanos(p.Time),
+				Value:             p.Number.CoerceToInt64(nk),
+			})
+		}
+		m.Data = &metricpb.Metric_IntGauge{IntGauge: &metricpb.IntGauge{DataPoints: pts}}
+
+	case number.Float64Kind:
+		var pts []*metricpb.DoubleDataPoint
+		for _, p := range points {
+			pts = append(pts, &metricpb.DoubleDataPoint{
+				Labels:            labels,
+				StartTimeUnixNano: toNanos(record.StartTime()),
+				TimeUnixNano:      toNanos(p.Time),
+				Value:             p.Number.CoerceToFloat64(nk),
+			})
+		}
+		m.Data = &metricpb.Metric_DoubleGauge{DoubleGauge: &metricpb.DoubleGauge{DataPoints: pts}}
+
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownValueType, nk)
+	}
+	return m, nil
+}
+
+func gaugePoint(record export.Record, num number.Number, start, end time.Time) (*metricpb.Metric, error) {
+	m := newMetric(record)
+	labels := stringKeyValues(record.Labels().Iter())
+
+	switch n := record.Descriptor().NumberKind(); n {
+	case number.Int64Kind:
+		m.Data = &metricpb.Metric_IntGauge{
+			IntGauge: &metricpb.IntGauge{
+				DataPoints: []*metricpb.IntDataPoint{{
+					Value:             num.CoerceToInt64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(start),
+					TimeUnixNano:      toNanos(end),
+				}},
+			},
+		}
+	case number.Float64Kind:
+		m.Data = &metricpb.Metric_DoubleGauge{
+			DoubleGauge: &metricpb.DoubleGauge{
+				DataPoints: []*metricpb.DoubleDataPoint{{
+					Value:             num.CoerceToFloat64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(start),
+					TimeUnixNano:      toNanos(end),
+				}},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownValueType, n)
+	}
+	return m, nil
+}
+
+func exportKindToTemporality(ek export.ExportKind) metricpb.AggregationTemporality {
+	switch ek {
+	case export.DeltaExportKind:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	case export.CumulativeExportKind:
+		return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	}
+	return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+}
+
+func sumPoint(record export.Record, num number.Number, start, end time.Time, ek export.ExportKind, monotonic bool) (*metricpb.Metric, error) {
+	m := newMetric(record)
+	labels := stringKeyValues(record.Labels().Iter())
+
+	switch n := record.Descriptor().NumberKind(); n {
+	case number.Int64Kind:
+		m.Data = &metricpb.Metric_IntSum{
+			IntSum: &metricpb.IntSum{
+				IsMonotonic:            monotonic,
+				AggregationTemporality: exportKindToTemporality(ek),
+				DataPoints: []*metricpb.IntDataPoint{{
+					Value:             num.CoerceToInt64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(start),
+					TimeUnixNano:      toNanos(end),
+				}},
+			},
+		}
+	case number.Float64Kind:
+		m.Data = &metricpb.Metric_DoubleSum{
+			DoubleSum: &metricpb.DoubleSum{
+				IsMonotonic:            monotonic,
+				AggregationTemporality: exportKindToTemporality(ek),
+				DataPoints: []*metricpb.DoubleDataPoint{{
+					Value:             num.CoerceToFloat64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(start),
+					TimeUnixNano:      toNanos(end),
+				}},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownValueType, n)
+	}
+	return m, nil
+}
+
+// minMaxSumCountValues returns the values of the MinMaxSumCount Aggregator
+// as discrete values.
+func minMaxSumCountValues(a aggregation.MinMaxSumCount) (min, max, sum number.Number, count uint64, err error) {
+	if min, err = a.Min(); err != nil {
+		return
+	}
+	if max, err = a.Max(); err != nil {
+		return
+	}
+	if sum, err = a.Sum(); err != nil {
+		return
+	}
+	if count, err = a.Count(); err != nil {
+		return
+	}
+	return
+}
+
+// minMaxSumCount transforms a MinMaxSumCount Aggregator into an OTLP Metric.
+// Like upstream, min and max are carried in a two bucket histogram.
+func minMaxSumCount(record export.Record, a aggregation.MinMaxSumCount) (*metricpb.Metric, error) {
+	min, max, sum, count, err := minMaxSumCountValues(a)
+	if err != nil {
+		return nil, err
+	}
+	m := newMetric(record)
+	labels := stringKeyValues(record.Labels().Iter())
+	buckets := []uint64{min.AsRaw(), max.AsRaw()}
+	bounds := []float64{0.0, 100.0}
+
+	switch n := record.Descriptor().NumberKind(); n {
+	case number.Int64Kind:
+		m.Data = &metricpb.Metric_IntHistogram{
+			IntHistogram: &metricpb.IntHistogram{
+				DataPoints: []*metricpb.IntHistogramDataPoint{{
+					Sum:               sum.CoerceToInt64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(record.StartTime()),
+					TimeUnixNano:      toNanos(record.EndTime()),
+					Count:             count,
+					BucketCounts:      buckets,
+					ExplicitBounds:    bounds,
+				}},
+			},
+		}
+	case number.Float64Kind:
+		m.Data = &metricpb.Metric_DoubleHistogram{
+			DoubleHistogram: &metricpb.DoubleHistogram{
+				DataPoints: []*metricpb.DoubleHistogramDataPoint{{
+					Sum:               sum.CoerceToFloat64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(record.StartTime()),
+					TimeUnixNano:      toNanos(record.EndTime()),
+					Count:             count,
+					BucketCounts:      buckets,
+					ExplicitBounds:    bounds,
+				}},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownValueType, n)
+	}
+	return m, nil
+}
+
+// histogramPoint transforms a Histogram Aggregator into an OTLP Metric.
+func histogramPoint(record export.Record, ek export.ExportKind, a aggregation.Histogram) (*metricpb.Metric, error) {
+	buckets, err := a.Histogram()
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets.Counts) != len(buckets.Boundaries)+1 {
+		return nil, ErrTransforming
+	}
+	count, err := a.Count()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := a.Sum()
+	if err != nil {
+		return nil, err
+	}
+	m := newMetric(record)
+	labels := stringKeyValues(record.Labels().Iter())
+
+	switch n := record.Descriptor().NumberKind(); n {
+	case number.Int64Kind:
+		m.Data = &metricpb.Metric_IntHistogram{
+			IntHistogram: &metricpb.IntHistogram{
+				AggregationTemporality: exportKindToTemporality(ek),
+				DataPoints: []*metricpb.IntHistogramDataPoint{{
+					Sum:               sum.CoerceToInt64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(record.StartTime()),
+					TimeUnixNano:      toNanos(record.EndTime()),
+					Count:             count,
+					BucketCounts:      buckets.Counts,
+					ExplicitBounds:    buckets.Boundaries,
+				}},
+			},
+		}
+	case number.Float64Kind:
+		m.Data = &metricpb.Metric_DoubleHistogram{
+			DoubleHistogram: &metricpb.DoubleHistogram{
+				AggregationTemporality: exportKindToTemporality(ek),
+				DataPoints: []*metricpb.DoubleHistogramDataPoint{{
+					Sum:               sum.CoerceToFloat64(n),
+					Labels:            labels,
+					StartTimeUnixNano: toNanos(record.StartTime()),
+					TimeUnixNano:      toNanos(record.EndTime()),
+					Count:             count,
+					BucketCounts:      buckets.Counts,
+					ExplicitBounds:    buckets.Boundaries,
+				}},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnknownValueType, n)
+	}
+	return m, nil
+}
+
+// stringKeyValues transforms a label iterator into OTLP StringKeyValues.
+func stringKeyValues(iter label.Iterator) []*commonpb.StringKeyValue {
+	l := iter.Len()
+	if l == 0 {
+		return nil
+	}
+	result := make([]*commonpb.StringKeyValue, 0, l)
+	for iter.Next() {
+		kv := iter.Label()
+		result = append(result, &commonpb.StringKeyValue{
+			Key:   string(kv.Key),
+			Value: kv.Value.Emit(),
+		})
+	}
+	return result
+}
+
+func instrumentationLibrary(il instrumentation.Library) *commonpb.InstrumentationLibrary {
+	if il == (instrumentation.Library{}) {
+		return nil
+	}
+	return &commonpb.InstrumentationLibrary{
+		Name:    il.Name,
+		Version: il.Version,
+	}
+}
@@ -0,0 +1,377 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/exact"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/lastvalue"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/minmaxsumcount"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/sum"
+	"go.opentelemetry.io/otel/sdk/resource"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	start      = time.Unix(100, 0)
+	end        = time.Unix(110, 0)
+	testLabels = label.NewSet(label.String("path", "/"))
+	testRes    = resource.NewWithAttributes(label.String("service.name", "test"))
+	pbLabels   = []*commonpb.StringKeyValue{{Key: "path", Value: "/"}}
+	cumulative = metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta      = metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+)
+
+// aggregatorPair returns an aggregator to update and the one its state is
+// moved to, as the SDK does on collection.
+type aggregatorPair func(desc *metric.Descriptor) (export.Aggregator, export.Aggregator)
+
+func sumAggregator(*metric.Descriptor) (export.Aggregator, export.Aggregator) {
+	a := sum.New(2)
+	return &a[0], &a[1]
+}
+
+func lastValueAggregator(*metric.Descriptor) (export.Aggregator, export.Aggregator) {
+	a := lastvalue.New(2)
+	return &a[0], &a[1]
+}
+
+func mmscAggregator(desc *metric.Descriptor) (export.Aggregator, export.Aggregator) {
+	a := minmaxsumcount.New(2, desc)
+	return &a[0], &a[1]
+}
+
+func histogramAggregator(desc *metric.Descriptor) (export.Aggregator, export.Aggregator) {
+	a := histogram.New(2, desc, []float64{1, 10})
+	return &a[0], &a[1]
+}
+
+func exactAggregator(*metric.Descriptor) (export.Aggregator, export.Aggregator) {
+	a := exact.New(2)
+	return &a[0], &a[1]
+}
+
+// record returns a record of the values aggregated by the aggregators.
+func record(t *testing.T, desc metric.Descriptor, aggs aggregatorPair, values ...float64) export.Record {
+	t.Helper()
+	agg, ckpt := aggs(&desc)
+	for _, v := range values {
+		n := number.NewFloat64Number(v)
+		if desc.NumberKind() == number.Int64Kind {
+			n = number.NewInt64Number(int64(v))
+		}
+		if err := agg.Update(context.Background(), n, &desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := agg.SynchronizedMove(ckpt, &desc); err != nil {
+		t.Fatal(err)
+	}
+	return export.NewRecord(&desc, &testLabels, testRes, ckpt.Aggregation(), start, end)
+}
+
+// withoutPointTimes clears the point times of gauges, which the last value
+// and exact aggregators take from the clock.
+func withoutPointTimes(m *metricpb.Metric) *metricpb.Metric {
+	for _, p := range m.GetIntGauge().GetDataPoints() {
+		p.TimeUnixNano = 0
+	}
+	for _, p := range m.GetDoubleGauge().GetDataPoints() {
+		p.TimeUnixNano = 0
+	}
+	return m
+}
+
+func TestRecord(t *testing.T) {
+	counter := func(nk number.Kind) metric.Descriptor {
+		return metric.NewDescriptor("requests", metric.CounterInstrumentKind, nk, metric.WithDescription("reqs"), metric.WithUnit("1"))
+	}
+	upDown := metric.NewDescriptor("inflight", metric.UpDownCounterInstrumentKind, number.Int64Kind)
+	observer := func(nk number.Kind) metric.Descriptor {
+		return metric.NewDescriptor("alloc", metric.ValueObserverInstrumentKind, nk)
+	}
+	recorder := func(nk number.Kind) metric.Descriptor {
+		return metric.NewDescriptor("latency", metric.ValueRecorderInstrumentKind, nk)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		desc     metric.Descriptor
+		aggs     aggregatorPair
+		values   []float64
+		selector export.ExportKindSelector
+		want     *metricpb.Metric
+	}{{
+		name:     "int sum cumulative",
+		desc:     counter(number.Int64Kind),
+		aggs:     sumAggregator,
+		values:   []float64{1, 2},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "requests", Description: "reqs", Unit: "1", Data: &metricpb.Metric_IntSum{IntSum: &metricpb.IntSum{
+			IsMonotonic:            true,
+			AggregationTemporality: cumulative,
+			DataPoints:             []*metricpb.IntDataPoint{{Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9, Value: 3}},
+		}}},
+	}, {
+		name:     "float sum delta",
+		desc:     counter(number.Float64Kind),
+		aggs:     sumAggregator,
+		values:   []float64{1.5, 2},
+		selector: export.DeltaExportKindSelector(),
+		want: &metricpb.Metric{Name: "requests", Description: "reqs", Unit: "1", Data: &metricpb.Metric_DoubleSum{DoubleSum: &metricpb.DoubleSum{
+			IsMonotonic:            true,
+			AggregationTemporality: delta,
+			DataPoints:             []*metricpb.DoubleDataPoint{{Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9, Value: 3.5}},
+		}}},
+	}, {
+		name:     "non-monotonic sum",
+		desc:     upDown,
+		aggs:     sumAggregator,
+		values:   []float64{2, -3},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "inflight", Data: &metricpb.Metric_IntSum{IntSum: &metricpb.IntSum{
+			AggregationTemporality: cumulative,
+			DataPoints:             []*metricpb.IntDataPoint{{Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9, Value: -1}},
+		}}},
+	}, {
+		name:     "int last value",
+		desc:     observer(number.Int64Kind),
+		aggs:     lastValueAggregator,
+		values:   []float64{5, 7},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "alloc", Data: &metricpb.Metric_IntGauge{IntGauge: &metricpb.IntGauge{
+			DataPoints: []*metricpb.IntDataPoint{{Labels: pbLabels, Value: 7}},
+		}}},
+	}, {
+		name:     "float last value",
+		desc:     observer(number.Float64Kind),
+		aggs:     lastValueAggregator,
+		values:   []float64{0.5},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "alloc", Data: &metricpb.Metric_DoubleGauge{DoubleGauge: &metricpb.DoubleGauge{
+			DataPoints: []*metricpb.DoubleDataPoint{{Labels: pbLabels, Value: 0.5}},
+		}}},
+	}, {
+		name:     "int minmaxsumcount",
+		desc:     recorder(number.Int64Kind),
+		aggs:     mmscAggregator,
+		values:   []float64{2, 8, 5},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_IntHistogram{IntHistogram: &metricpb.IntHistogram{
+			DataPoints: []*metricpb.IntHistogramDataPoint{{
+				Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9,
+				Count: 3, Sum: 15, BucketCounts: []uint64{2, 8}, ExplicitBounds: []float64{0, 100},
+			}},
+		}}},
+	}, {
+		name:     "float minmaxsumcount",
+		desc:     recorder(number.Float64Kind),
+		aggs:     mmscAggregator,
+		values:   []float64{1, 3},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_DoubleHistogram{DoubleHistogram: &metricpb.DoubleHistogram{
+			DataPoints: []*metricpb.DoubleHistogramDataPoint{{
+				Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9,
+				Count: 2, Sum: 4, BucketCounts: []uint64{math.Float64bits(1), math.Float64bits(3)}, ExplicitBounds: []float64{0, 100},
+			}},
+		}}},
+	}, {
+		name:     "int histogram delta",
+		desc:     recorder(number.Int64Kind),
+		aggs:     histogramAggregator,
+		values:   []float64{0, 5, 50},
+		selector: export.DeltaExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_IntHistogram{IntHistogram: &metricpb.IntHistogram{
+			AggregationTemporality: delta,
+			DataPoints: []*metricpb.IntHistogramDataPoint{{
+				Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9,
+				Count: 3, Sum: 55, BucketCounts: []uint64{1, 1, 1}, ExplicitBounds: []float64{1, 10},
+			}},
+		}}},
+	}, {
+		name:     "float histogram cumulative",
+		desc:     recorder(number.Float64Kind),
+		aggs:     histogramAggregator,
+		values:   []float64{0.5, 20},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_DoubleHistogram{DoubleHistogram: &metricpb.DoubleHistogram{
+			AggregationTemporality: cumulative,
+			DataPoints: []*metricpb.DoubleHistogramDataPoint{{
+				Labels: pbLabels, StartTimeUnixNano: 100e9, TimeUnixNano: 110e9,
+				Count: 2, Sum: 20.5, BucketCounts: []uint64{1, 0, 1}, ExplicitBounds: []float64{1, 10},
+			}},
+		}}},
+	}, {
+		name:     "int exact",
+		desc:     recorder(number.Int64Kind),
+		aggs:     exactAggregator,
+		values:   []float64{3, 4},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_IntGauge{IntGauge: &metricpb.IntGauge{
+			DataPoints: []*metricpb.IntDataPoint{
+				{Labels: pbLabels, StartTimeUnixNano: 100e9, Value: 3},
+				{Labels: pbLabels, StartTimeUnixNano: 100e9, Value: 4},
+			},
+		}}},
+	}, {
+		name:     "float exact",
+		desc:     recorder(number.Float64Kind),
+		aggs:     exactAggregator,
+		values:   []float64{0.25},
+		selector: export.CumulativeExportKindSelector(),
+		want: &metricpb.Metric{Name: "latency", Data: &metricpb.Metric_DoubleGauge{DoubleGauge: &metricpb.DoubleGauge{
+			DataPoints: []*metricpb.DoubleDataPoint{{Labels: pbLabels, StartTimeUnixNano: 100e9, Value: 0.25}},
+		}}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Record(tc.selector, record(t, tc.desc, tc.aggs, tc.values...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got = withoutPointTimes(got); !proto.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// unknownAggregation is an aggregation the transform does not implement.
+type unknownAggregation struct{}
+
+func (unknownAggregation) Kind() aggregation.Kind {
+	return "Unknown"
+}
+
+func TestRecordErrors(t *testing.T) {
+	desc := metric.NewDescriptor("alloc", metric.ValueObserverInstrumentKind, number.Int64Kind)
+	record := export.NewRecord(&desc, &testLabels, testRes, unknownAggregation{}, start, end)
+	if _, err := Record(export.CumulativeExportKindSelector(), record); !errors.Is(err, ErrUnimplementedAgg) {
+		t.Errorf("got error %v, want ErrUnimplementedAgg", err)
+	}
+	// A last value never updated has no data.
+	_, ckpt := lastValueAggregator(&desc)
+	record = export.NewRecord(&desc, &testLabels, testRes, ckpt.Aggregation(), start, end)
+	if _, err := Record(export.CumulativeExportKindSelector(), record); !errors.Is(err, aggregation.ErrNoData) {
+		t.Errorf("got error %v, want ErrNoData", err)
+	}
+}
+
+// checkpointSet is a fixed set of records.
+type checkpointSet struct {
+	sync.RWMutex
+	records []export.Record
+}
+
+func (c *checkpointSet) ForEach(_ export.ExportKindSelector, f func(export.Record) error) error {
+	for _, r := range c.records {
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCheckpointSet(t *testing.T) {
+	requests := metric.NewDescriptor("requests", metric.CounterInstrumentKind, number.Int64Kind, metric.WithInstrumentationName("lib"))
+	unknown := metric.NewDescriptor("unknown", metric.CounterInstrumentKind, number.Int64Kind, metric.WithInstrumentationName("lib"))
+	other := label.NewSet(label.String("path", "/other"))
+	cps := &checkpointSet{records: []export.Record{
+		record(t, requests, sumAggregator, 1),
+		export.NewRecord(&unknown, &testLabels, testRes, unknownAggregation{}, start, end),
+		export.NewRecord(&requests, &other, testRes, record(t, requests, sumAggregator, 2).Aggregation(), start, end),
+	}}
+
+	// The records that transform are returned next to the error.
+	rms, err := CheckpointSet(context.Background(), export.CumulativeExportKindSelector(), cps)
+	if !errors.Is(err, ErrTransforming) {
+		t.Fatalf("got error %v, want ErrTransforming", err)
+	}
+	if len(rms) != 1 || len(rms[0].InstrumentationLibraryMetrics) != 1 {
+		t.Fatalf("got %v", rms)
+	}
+	if got := rms[0].Resource.Attributes[0].Key; got != "service.name" {
+		t.Errorf("got resource %v", rms[0].Resource)
+	}
+	ilm := rms[0].InstrumentationLibraryMetrics[0]
+	if ilm.InstrumentationLibrary.GetName() != "lib" || len(ilm.Metrics) != 1 {
+		t.Fatalf("got %v", ilm)
+	}
+	// Records of a metric are merged into its data points.
+	if points := ilm.Metrics[0].GetIntSum().GetDataPoints(); len(points) != 2 || points[0].Value != 1 || points[1].Value != 2 {
+		t.Errorf("got points %v", points)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CheckpointSet(ctx, export.CumulativeExportKindSelector(), cps); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+// checkpoint returns the checkpoint of a counter and a histogram recorded
+// with labelSets label sets each.
+func checkpoint(b *testing.B, labelSets int) export.CheckpointSet {
+	p := metrictest.NewPipeline(
+		metrictest.WithHistogramDistribution([]float64{1, 5, 10, 50, 100}),
+		metrictest.WithResource(resource.NewWithAttributes(label.String("service.name", "bench"))),
+	)
+	meter := metric.Must(p.Meter())
+	counter := meter.NewInt64Counter("request.count")
+	latency := meter.NewFloat64ValueRecorder("request.latency")
+	ctx := context.Background()
+	for i := 0; i < labelSets; i++ {
+		labels := []label.KeyValue{label.String("path", "/api/items/"+strconv.Itoa(i)), label.String("host", "localhost")}
+		counter.Add(ctx, 1, labels...)
+		latency.Record(ctx, float64(i%100), labels...)
+	}
+	return p.CheckpointSet(b)
+}
+
+// BenchmarkCheckpointSet measures the conversion of a checkpoint to OTLP,
+// and its serialization, as the number of label sets grows.
+func BenchmarkCheckpointSet(b *testing.B) {
+	ctx := context.Background()
+	for _, labelSets := range []int{10, 100, 1000, 10000} {
+		cps := checkpoint(b, labelSets)
+		b.Run(fmt.Sprintf("transform/%d", labelSets), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := CheckpointSet(ctx, export.CumulativeExportKindSelector(), cps); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("marshal/%d", labelSets), func(b *testing.B) {
+			b.ReportAllocs()
+			var size int
+			for n := 0; n < b.N; n++ {
+				rms, err := CheckpointSet(ctx, export.CumulativeExportKindSelector(), cps)
+				if err != nil {
+					b.Fatal(err)
+				}
+				data, err := proto.Marshal(&colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: rms})
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/export")
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package transform
+
+import (
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/resource"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// Resource transforms a Resource into an OTLP Resource.
+func Resource(r *resource.Resource) *resourcepb.Resource {
+	if r == nil {
+		return nil
+	}
+	return &resourcepb.Resource{Attributes: Attributes(r.Attributes())}
+}
+
+// Attributes transforms a slice of KeyValues into a slice of OTLP attribute
+// key-values. Array values are not supported by the collector exporters we
+// target, so they are sent in their string form.
+func Attributes(attrs []label.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		out = append(out, toAttribute(kv))
+	}
+	return out
+}
+
+func toAttribute(kv label.KeyValue) *commonpb.KeyValue {
+	result := &commonpb.KeyValue{
+		Key:   string(kv.Key),
+		Value: new(commonpb.AnyValue),
+	}
+	switch kv.Value.Type() {
+	case label.BOOL:
+		result.Value.Value = &commonpb.AnyValue_BoolValue{BoolValue: kv.Value.AsBool()}
+	case label.INT64, label.INT32, label.UINT32, label.UINT64:
+		result.Value.Value = &commonpb.AnyValue_IntValue{IntValue: kv.Value.AsInt64()}
+	case label.FLOAT32:
+		result.Value.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: float64(kv.Value.AsFloat32())}
+	case label.FLOAT64:
+		result.Value.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: kv.Value.AsFloat64()}
+	default:
+		result.Value.Value = &commonpb.AnyValue_StringValue{StringValue: kv.Value.Emit()}
+	}
+	return result
+}
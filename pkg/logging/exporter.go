@@ -0,0 +1,282 @@
+// Package logging provides a metric exporter that prints every checkpoint in
+// a human readable form, like the collector's logging exporter does, so that
+// what an application pushes can be inspected without running a collector.
+// Use it with controller.WithPusher.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Exporter is an export.Exporter that prints records to an io.Writer.
+type Exporter struct {
+	cfg config
+
+	// mu serializes writes, an export is written with a single call.
+	mu sync.Mutex
+}
+
+var _ export.Exporter = (*Exporter)(nil)
+
+// New creates a logging exporter.
+func New(opts ...Option) *Exporter {
+	return &Exporter{cfg: newConfig(opts...)}
+}
+
+// ExportKindFor implements export.ExportKindSelector.
+func (e *Exporter) ExportKindFor(desc *metric.Descriptor, kind aggregation.Kind) export.ExportKind {
+	return e.cfg.selector.ExportKindFor(desc, kind)
+}
+
+// entry is the printed form of a record.
+type entry struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	Unit           string            `json:"unit,omitempty"`
+	InstrumentKind string            `json:"instrument_kind"`
+	NumberKind     string            `json:"number_kind"`
+	Aggregation    string            `json:"aggregation"`
+	Temporality    string            `json:"temporality"`
+	Monotonic      bool              `json:"monotonic"`
+	Resource       map[string]string `json:"resource,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	StartTime      time.Time         `json:"start_time"`
+	EndTime        time.Time         `json:"end_time"`
+	// data holds the aggregated values, in the order they are printed.
+	data []field
+
+	resource []label.KeyValue
+	labels   []label.KeyValue
+}
+
+type field struct {
+	name  string
+	value interface{}
+}
+
+// Export implements export.Exporter.
+func (e *Exporter) Export(_ context.Context, cps export.CheckpointSet) error {
+	var buf bytes.Buffer
+	i := 0
+	err := cps.ForEach(e, func(r export.Record) error {
+		ent, err := e.entry(r)
+		if err != nil {
+			return err
+		}
+		if e.cfg.format == JSONFormat {
+			err = writeJSON(&buf, ent)
+		} else {
+			writeText(&buf, i, ent)
+		}
+		i++
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.cfg.writer.Write(buf.Bytes())
+	return err
+}
+
+func (e *Exporter) entry(r export.Record) (*entry, error) {
+	desc := r.Descriptor()
+	agg := r.Aggregation()
+	ent := &entry{
+		Name:           desc.Name(),
+		Description:    desc.Description(),
+		Unit:           string(desc.Unit()),
+		InstrumentKind: strings.TrimSuffix(desc.InstrumentKind().String(), "InstrumentKind"),
+		NumberKind:     strings.TrimSuffix(desc.NumberKind().String(), "Kind"),
+		Aggregation:    string(agg.Kind()),
+		Temporality:    strings.TrimSuffix(e.ExportKindFor(desc, agg.Kind()).String(), "ExportKind"),
+		Monotonic:      desc.InstrumentKind().Monotonic(),
+		StartTime:      r.StartTime(),
+		EndTime:        r.EndTime(),
+		resource:       resourceLabels(r.Resource()),
+		labels:         r.Labels().ToSlice(),
+	}
+	ent.Resource = labelMap(ent.resource)
+	ent.Labels = labelMap(ent.labels)
+
+	data, err := aggregationData(agg, desc.NumberKind())
+	if err != nil {
+		return nil, fmt.Errorf("logging: %s: %w", desc.Name(), err)
+	}
+	ent.data = data
+	return ent, nil
+}
+
+// aggregationData extracts the values of an aggregation, testing for the
+// richest interface first.
+func aggregationData(agg aggregation.Aggregation, kind number.Kind) ([]field, error) {
+	switch a := agg.(type) {
+	case aggregation.Points:
+		pts, err := a.Points()
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(pts))
+		for i := range pts {
+			values[i] = pts[i].Number.AsInterface(kind)
+		}
+		return []field{{"Count", len(pts)}, {"Values", values}}, nil
+	case aggregation.Histogram:
+		count, err := a.Count()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		buckets, err := a.Histogram()
+		if err != nil {
+			return nil, err
+		}
+		return []field{
+			{"Count", count},
+			{"Sum", sum.AsInterface(kind)},
+			{"ExplicitBounds", buckets.Boundaries},
+			{"BucketCounts", buckets.Counts},
+		}, nil
+	case aggregation.MinMaxSumCount:
+		min, err := a.Min()
+		if err != nil {
+			return nil, err
+		}
+		max, err := a.Max()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		count, err := a.Count()
+		if err != nil {
+			return nil, err
+		}
+		return []field{
+			{"Min", min.AsInterface(kind)},
+			{"Max", max.AsInterface(kind)},
+			{"Sum", sum.AsInterface(kind)},
+			{"Count", count},
+		}, nil
+	case aggregation.LastValue:
+		value, ts, err := a.LastValue()
+		if err != nil {
+			return nil, err
+		}
+		return []field{{"Value", value.AsInterface(kind)}, {"ValueTimestamp", ts}}, nil
+	case aggregation.Sum:
+		sum, err := a.Sum()
+		if err != nil {
+			return nil, err
+		}
+		return []field{{"Value", sum.AsInterface(kind)}}, nil
+	}
+	return nil, fmt.Errorf("unsupported aggregation %T", agg)
+}
+
+func resourceLabels(res *resource.Resource) []label.KeyValue {
+	if res == nil {
+		return nil
+	}
+	return res.Attributes()
+}
+
+func labelMap(kvs []label.KeyValue) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[string(kv.Key)] = kv.Value.Emit()
+	}
+	return m
+}
+
+// writeText prints an entry in the layout of the collector's logging
+// exporter.
+func writeText(buf *bytes.Buffer, i int, ent *entry) {
+	fmt.Fprintf(buf, "Metric #%d\n", i)
+	fmt.Fprintln(buf, "Descriptor:")
+	fmt.Fprintf(buf, "     -> Name: %s\n", ent.Name)
+	fmt.Fprintf(buf, "     -> Description: %s\n", ent.Description)
+	fmt.Fprintf(buf, "     -> Unit: %s\n", ent.Unit)
+	fmt.Fprintf(buf, "     -> InstrumentKind: %s\n", ent.InstrumentKind)
+	fmt.Fprintf(buf, "     -> NumberKind: %s\n", ent.NumberKind)
+	fmt.Fprintf(buf, "     -> Aggregation: %s\n", ent.Aggregation)
+	fmt.Fprintf(buf, "     -> IsMonotonic: %t\n", ent.Monotonic)
+	fmt.Fprintf(buf, "     -> Temporality: %s\n", ent.Temporality)
+	writeLabels(buf, "Resource labels:", ent.resource)
+	writeLabels(buf, "Data point labels:", ent.labels)
+	fmt.Fprintf(buf, "StartTime: %s\n", ent.StartTime.Format(time.RFC3339Nano))
+	fmt.Fprintf(buf, "Timestamp: %s\n", ent.EndTime.Format(time.RFC3339Nano))
+	for _, f := range ent.data {
+		if ts, ok := f.value.(time.Time); ok {
+			fmt.Fprintf(buf, "%s: %s\n", f.name, ts.Format(time.RFC3339Nano))
+			continue
+		}
+		fmt.Fprintf(buf, "%s: %v\n", f.name, f.value)
+	}
+	fmt.Fprintln(buf)
+}
+
+func writeLabels(buf *bytes.Buffer, title string, kvs []label.KeyValue) {
+	if len(kvs) == 0 {
+		return
+	}
+	fmt.Fprintln(buf, title)
+	for _, kv := range kvs {
+		fmt.Fprintf(buf, "     -> %s: %s\n", kv.Key, kv.Value.Emit())
+	}
+}
+
+// writeJSON prints an entry as a single line JSON object, the aggregated
+// values are under "data".
+func writeJSON(buf *bytes.Buffer, ent *entry) error {
+	data := make(map[string]interface{}, len(ent.data))
+	for _, f := range ent.data {
+		data[snakeCase(f.name)] = f.value
+	}
+	raw, err := json.Marshal(struct {
+		*entry
+		Data map[string]interface{} `json:"data"`
+	}{ent, data})
+	if err != nil {
+		return err
+	}
+	buf.Write(raw)
+	return buf.WriteByte('\n')
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// push records a counter and a value recorder and pushes them once.
+func push(t *testing.T, opts ...Option) string {
+	t.Helper()
+	var out bytes.Buffer
+	exp := New(append([]Option{WithWriter(&out)}, opts...)...)
+	cont := controller.New(
+		processor.New(simple.NewWithInexpensiveDistribution(), exp),
+		controller.WithPusher(exp),
+		controller.WithResource(resource.NewWithAttributes(label.String("service.name", "test"))),
+	)
+	if err := cont.Start(context.Background()); err != nil {
+		t.Fatal("failed to start controller:", err)
+	}
+	meter := metric.Must(cont.MeterProvider().Meter("test"))
+	meter.NewInt64Counter("request.count", metric.WithDescription("Requests served")).
+		Add(context.Background(), 3, label.String("path", "/api"))
+	latency := meter.NewFloat64ValueRecorder("request.latency")
+	latency.Record(context.Background(), 1.5)
+	latency.Record(context.Background(), 0.5)
+	// Stop pushes the last checkpoint.
+	if err := cont.Stop(context.Background()); err != nil {
+		t.Fatal("failed to stop controller:", err)
+	}
+	return out.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	out := push(t)
+	for _, want := range []string{
+		"     -> Name: request.count\n",
+		"     -> Description: Requests served\n",
+		"     -> InstrumentKind: Counter\n",
+		"     -> NumberKind: Int64\n",
+		"     -> IsMonotonic: true\n",
+		"     -> Temporality: Cumulative\n",
+		"Resource labels:\n     -> service.name: test\n",
+		"Data point labels:\n     -> path: /api\n",
+		"Value: 3\n",
+		"     -> Name: request.latency\n",
+		"     -> Aggregation: MinMaxSumCount\n",
+		"Min: 0.5\nMax: 1.5\nSum: 2\nCount: 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	out := push(t, WithFormat(JSONFormat))
+	got := map[string]map[string]interface{}{}
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", sc.Text(), err)
+		}
+		got[rec["name"].(string)] = rec
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(got), out)
+	}
+
+	count := got["request.count"]
+	if count["monotonic"] != true || count["temporality"] != "Cumulative" || count["instrument_kind"] != "Counter" {
+		t.Errorf("unexpected descriptor %v", count)
+	}
+	if labels := count["labels"].(map[string]interface{}); labels["path"] != "/api" {
+		t.Errorf("unexpected labels %v", labels)
+	}
+	if res := count["resource"].(map[string]interface{}); res["service.name"] != "test" {
+		t.Errorf("unexpected resource %v", res)
+	}
+	if data := count["data"].(map[string]interface{}); data["value"] != float64(3) {
+		t.Errorf("unexpected data %v", data)
+	}
+
+	latency := got["request.latency"]
+	if data := latency["data"].(map[string]interface{}); data["min"] != 0.5 || data["max"] != 1.5 || data["count"] != float64(2) {
+		t.Errorf("unexpected data %v", data)
+	}
+}
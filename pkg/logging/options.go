@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"io"
+	"os"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// Format selects how records are printed.
+type Format int
+
+const (
+	// TextFormat prints multi-line blocks similar to the collector's
+	// logging exporter.
+	TextFormat Format = iota
+	// JSONFormat prints one JSON object per record and line.
+	JSONFormat
+)
+
+// config contains the settings of the logging exporter.
+type config struct {
+	writer   io.Writer
+	format   Format
+	selector export.ExportKindSelector
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		writer:   os.Stdout,
+		format:   TextFormat,
+		selector: export.CumulativeExportKindSelector(),
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the logging exporter.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithWriter sets where records are printed, os.Stdout by default.
+func WithWriter(w io.Writer) Option {
+	return writerOption{w}
+}
+
+// WithFormat sets the output format, TextFormat by default.
+func WithFormat(format Format) Option {
+	return formatOption(format)
+}
+
+// WithExportKindSelector sets the temporality requested from the processor,
+// cumulative by default.
+func WithExportKindSelector(selector export.ExportKindSelector) Option {
+	return selectorOption{selector}
+}
+
+type writerOption struct{ io.Writer }
+
+type formatOption Format
+
+type selectorOption struct{ export.ExportKindSelector }
+
+// Apply implements Option.
+func (o writerOption) Apply(c *config) {
+	if o.Writer != nil {
+		c.writer = o.Writer
+	}
+}
+
+func (o formatOption) Apply(c *config) {
+	c.format = Format(o)
+}
+
+func (o selectorOption) Apply(c *config) {
+	if o.ExportKindSelector != nil {
+		c.selector = o.ExportKindSelector
+	}
+}
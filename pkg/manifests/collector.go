@@ -0,0 +1,227 @@
+package manifests
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Data types of the pipelines.
+const (
+	traces  = "traces"
+	metrics = "metrics"
+)
+
+// minCollectorMemoryMiB is the smallest memory limit leaving room for the
+// memory_limiter to work with.
+const minCollectorMemoryMiB = 128
+
+// telemetryPort is the port the collector serves its own metrics on.
+const telemetryPort = 8888
+
+// healthCheckPort is the port of the health_check extension the probes
+// check.
+const healthCheckPort = 13133
+
+// namedPort is a port the collector listens on.
+type namedPort struct {
+	name string
+	port int
+	// receiver is set for the ports the telemetry is received on.
+	receiver bool
+}
+
+// receiverProtocol is a protocol of a receiver, the protocol is empty for
+// receivers with one.
+type receiverProtocol struct {
+	protocol string
+	namedPort
+}
+
+// receivers are the ports of the receivers by type.
+var receivers = map[string][]receiverProtocol{
+	"otlp": {
+		{"grpc", namedPort{name: "otlp-grpc", port: 55680}},
+		{"http", namedPort{name: "otlp-http", port: 55681}},
+	},
+	"jaeger": {
+		{"grpc", namedPort{name: "jaeger-grpc", port: 14250}},
+		{"thrift_http", namedPort{name: "jaeger-http", port: 14268}},
+	},
+	"zipkin":     {{"", namedPort{name: "zipkin", port: 9411}}},
+	"opencensus": {{"", namedPort{name: "opencensus", port: 55678}}},
+}
+
+// receiverTypes are the data types of the receivers.
+var receiverTypes = map[string][]string{
+	"otlp":       {traces, metrics},
+	"jaeger":     {traces},
+	"zipkin":     {traces},
+	"opencensus": {traces, metrics},
+}
+
+// exporters are the data types of the exporters.
+var exporters = map[string][]string{
+	"otlp":       {traces, metrics},
+	"jaeger":     {traces},
+	"zipkin":     {traces},
+	"prometheus": {metrics},
+	"logging":    {traces, metrics},
+}
+
+// supports tells whether dataType is one of types.
+func supports(types []string, dataType string) bool {
+	for _, t := range types {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+// MemorySettings returns the memory settings of a collector limited to
+// memoryMiB. The ballast is a third of the memory, the memory_limiter
+// limit leaves a fifth of the memory, up to 2GiB, for the garbage
+// collector to run and the spike limit is a fourth of the limit, up to
+// 2GiB.
+func MemorySettings(memoryMiB int) (ballastMiB, limitMiB, spikeLimitMiB int) {
+	ballastMiB = memoryMiB / 3
+	limitMiB = memoryMiB - min(memoryMiB/5, 2048)
+	spikeLimitMiB = min(limitMiB/4, 2048)
+	return ballastMiB, limitMiB, spikeLimitMiB
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// collectorConfig returns the configuration of the collector and the ports
+// it listens on.
+func collectorConfig(c Collector) (string, []namedPort, error) {
+	var ports []namedPort
+	recv := map[string]interface{}{}
+	for _, r := range c.Receivers {
+		protocols := map[string]interface{}{}
+		for _, p := range receivers[r] {
+			np := p.namedPort
+			np.receiver = true
+			ports = append(ports, np)
+			endpoint := map[string]interface{}{"endpoint": listenEndpoint(p.port)}
+			if p.protocol == "" {
+				recv[r] = endpoint
+				continue
+			}
+			protocols[p.protocol] = endpoint
+		}
+		if len(protocols) > 0 {
+			recv[r] = map[string]interface{}{"protocols": protocols}
+		}
+	}
+
+	exp := map[string]interface{}{}
+	for _, e := range c.Exporters {
+		settings := map[string]interface{}{}
+		switch e.Type {
+		case "prometheus":
+			_, p, err := net.SplitHostPort(e.Endpoint)
+			port, perr := strconv.Atoi(p)
+			if err != nil || perr != nil {
+				return "", nil, fmt.Errorf("manifests: exporter %q: invalid endpoint %q", e.id(), e.Endpoint)
+			}
+			ports = append(ports, namedPort{name: "prometheus", port: port})
+			settings["endpoint"] = e.Endpoint
+		case "logging":
+		default:
+			settings["endpoint"] = e.Endpoint
+			if e.Insecure {
+				settings["insecure"] = true
+			}
+		}
+		exp[e.id()] = settings
+	}
+	ports = append(ports, namedPort{name: "telemetry", port: telemetryPort})
+	// The health_check port is not exposed, only probed.
+	seen := map[int]string{healthCheckPort: "health_check"}
+	for _, p := range ports {
+		if name, ok := seen[p.port]; ok {
+			return "", nil, fmt.Errorf("manifests: %s and %s both use port %d", name, p.name, p.port)
+		}
+		seen[p.port] = p.name
+	}
+
+	ballast, limit, spike := MemorySettings(c.Resources.MemoryMiB)
+	pipelines := map[string]interface{}{}
+	for _, dataType := range []string{traces, metrics} {
+		var r, e []string
+		for _, name := range c.Receivers {
+			if supports(receiverTypes[name], dataType) {
+				r = append(r, name)
+			}
+		}
+		for _, ex := range c.Exporters {
+			if supports(exporters[ex.Type], dataType) {
+				e = append(e, ex.id())
+			}
+		}
+		if len(r) == 0 || len(e) == 0 {
+			continue
+		}
+		pipelines[dataType] = map[string]interface{}{
+			"receivers": r,
+			// The memory_limiter goes first to refuse data before it is
+			// processed, batch last.
+			"processors": []string{"memory_limiter", "batch"},
+			"exporters":  e,
+		}
+	}
+	if len(pipelines) == 0 {
+		return "", nil, fmt.Errorf("manifests: no exporter supports the data types of the receivers")
+	}
+
+	config := map[string]interface{}{
+		"receivers": recv,
+		"processors": map[string]interface{}{
+			"memory_limiter": map[string]interface{}{
+				"check_interval":   "1s",
+				"ballast_size_mib": ballast,
+				"limit_mib":        limit,
+				"spike_limit_mib":  spike,
+			},
+			"batch": map[string]interface{}{},
+		},
+		"exporters": exp,
+		"extensions": map[string]interface{}{
+			"health_check": map[string]interface{}{},
+		},
+		"service": map[string]interface{}{
+			"extensions": []string{"health_check"},
+			"pipelines":  pipelines,
+		},
+	}
+	out, err := marshal(config)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(out), ports, nil
+}
+
+// portUser returns the name of the port of the collector listening on port,
+// if any.
+func portUser(port int, ports []namedPort) (string, bool) {
+	if port == healthCheckPort {
+		return "health_check", true
+	}
+	for _, p := range ports {
+		if p.port == port {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+func listenEndpoint(port int) string {
+	return "0.0.0.0:" + strconv.Itoa(port)
+}
@@ -0,0 +1,296 @@
+// Package manifests generates the Kubernetes manifests of an app and of the
+// collector it pushes its telemetry to, from a Topology. The ConfigMap
+// holding the collector configuration, the ports of the containers and of
+// the Services and the memory settings of the collector are derived from the
+// same description, so they do not drift apart like hand-written manifests.
+package manifests
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configVolume is the name of the volume of the ConfigMap, mounted at
+	// configDir.
+	configVolume = "collector-config"
+	configDir    = "/conf"
+	configKey    = "collector.yaml"
+	// endpointEnv is read by the app for the address of the collector.
+	endpointEnv = "OLTP_ENDPOINT"
+	// header starts the generated manifests.
+	header = "# Generated by github.com/skonto/test-otel/pkg/manifests, do not edit.\n"
+)
+
+// The Kubernetes objects, with the fields the manifests use only.
+type (
+	object struct {
+		APIVersion string            `yaml:"apiVersion"`
+		Kind       string            `yaml:"kind"`
+		Metadata   objectMeta        `yaml:"metadata"`
+		Data       map[string]string `yaml:"data,omitempty"`
+		Spec       interface{}       `yaml:"spec,omitempty"`
+	}
+	objectMeta struct {
+		Name      string            `yaml:"name,omitempty"`
+		Namespace string            `yaml:"namespace,omitempty"`
+		Labels    map[string]string `yaml:"labels,omitempty"`
+	}
+	workloadSpec struct {
+		Replicas int           `yaml:"replicas,omitempty"`
+		Selector labelSelector `yaml:"selector"`
+		Template podTemplate   `yaml:"template"`
+	}
+	labelSelector struct {
+		MatchLabels map[string]string `yaml:"matchLabels"`
+	}
+	podTemplate struct {
+		Metadata objectMeta `yaml:"metadata"`
+		Spec     podSpec    `yaml:"spec"`
+	}
+	podSpec struct {
+		Containers []container `yaml:"containers"`
+		Volumes    []volume    `yaml:"volumes,omitempty"`
+	}
+	container struct {
+		Name           string          `yaml:"name"`
+		Image          string          `yaml:"image"`
+		Args           []string        `yaml:"args,omitempty"`
+		Env            []envVar        `yaml:"env,omitempty"`
+		Ports          []containerPort `yaml:"ports,omitempty"`
+		Resources      *resources      `yaml:"resources,omitempty"`
+		VolumeMounts   []volumeMount   `yaml:"volumeMounts,omitempty"`
+		LivenessProbe  *probe          `yaml:"livenessProbe,omitempty"`
+		ReadinessProbe *probe          `yaml:"readinessProbe,omitempty"`
+	}
+	envVar struct {
+		Name      string        `yaml:"name"`
+		Value     string        `yaml:"value,omitempty"`
+		ValueFrom *envVarSource `yaml:"valueFrom,omitempty"`
+	}
+	envVarSource struct {
+		FieldRef fieldRef `yaml:"fieldRef"`
+	}
+	fieldRef struct {
+		FieldPath string `yaml:"fieldPath"`
+	}
+	containerPort struct {
+		Name          string `yaml:"name"`
+		ContainerPort int    `yaml:"containerPort"`
+		HostPort      int    `yaml:"hostPort,omitempty"`
+	}
+	resources struct {
+		Limits   map[string]string `yaml:"limits"`
+		Requests map[string]string `yaml:"requests"`
+	}
+	volumeMount struct {
+		Name      string `yaml:"name"`
+		MountPath string `yaml:"mountPath"`
+	}
+	volume struct {
+		Name      string          `yaml:"name"`
+		ConfigMap configMapSource `yaml:"configMap"`
+	}
+	configMapSource struct {
+		Name string `yaml:"name"`
+	}
+	probe struct {
+		HTTPGet httpGet `yaml:"httpGet"`
+	}
+	httpGet struct {
+		Path string `yaml:"path"`
+		Port int    `yaml:"port"`
+	}
+	serviceSpec struct {
+		Selector map[string]string `yaml:"selector"`
+		Ports    []servicePort     `yaml:"ports"`
+	}
+	servicePort struct {
+		Name       string `yaml:"name"`
+		Port       int    `yaml:"port"`
+		TargetPort int    `yaml:"targetPort"`
+	}
+)
+
+// Generate returns the manifests of a topology, in the order they can be
+// applied in: the ConfigMap of the collector, the collector Service and
+// Deployment of a Gateway or the DaemonSet of an Agent, then the Service and
+// the Deployment of the app, with the collector container of a Sidecar.
+func Generate(t Topology) ([]byte, error) {
+	t = t.withDefaults()
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	config, ports, err := collectorConfig(t.Collector)
+	if err != nil {
+		return nil, err
+	}
+	// A sidecar shares the network namespace of the app.
+	if t.Collector.Mode == Sidecar && t.App.Port > 0 {
+		if name, ok := portUser(t.App.Port, ports); ok {
+			return nil, fmt.Errorf("manifests: the app port %d is the %s port of the sidecar collector", t.App.Port, name)
+		}
+	}
+	g := generator{Topology: t}
+
+	objects := []object{g.object("v1", "ConfigMap", t.Collector.Name, g.collectorLabels())}
+	objects[0].Data = map[string]string{configKey: config}
+	app := g.appContainer()
+	switch t.Collector.Mode {
+	case Sidecar:
+		app.Env = append(app.Env, envVar{Name: endpointEnv, Value: "localhost:" + strconv.Itoa(otlpPort)})
+	case Agent:
+		ds := g.object("apps/v1", "DaemonSet", t.Collector.Name, g.collectorLabels())
+		ds.Spec = g.workloadSpec(0, g.collectorLabels(), []container{g.collectorContainer(ports, true)}, true)
+		objects = append(objects, ds)
+		app.Env = append(app.Env,
+			envVar{Name: "HOST_IP", ValueFrom: &envVarSource{FieldRef: fieldRef{FieldPath: "status.hostIP"}}},
+			envVar{Name: endpointEnv, Value: "$(HOST_IP):" + strconv.Itoa(otlpPort)})
+	case Gateway:
+		svc := g.object("v1", "Service", t.Collector.Name, g.collectorLabels())
+		spec := serviceSpec{Selector: g.collectorLabels()}
+		for _, p := range ports {
+			spec.Ports = append(spec.Ports, servicePort{Name: p.name, Port: p.port, TargetPort: p.port})
+		}
+		svc.Spec = spec
+		deploy := g.object("apps/v1", "Deployment", t.Collector.Name, g.collectorLabels())
+		deploy.Spec = g.workloadSpec(t.Collector.Replicas, g.collectorLabels(), []container{g.collectorContainer(ports, false)}, true)
+		objects = append(objects, svc, deploy)
+		app.Env = append(app.Env, envVar{Name: endpointEnv, Value: t.Collector.Name + ":" + strconv.Itoa(otlpPort)})
+	}
+
+	if t.App.Port > 0 {
+		svc := g.object("v1", "Service", t.App.Name, g.appLabels())
+		svc.Spec = serviceSpec{
+			Selector: g.appLabels(),
+			Ports:    []servicePort{{Name: "metrics", Port: t.App.Port, TargetPort: t.App.Port}},
+		}
+		objects = append(objects, svc)
+	}
+	containers := []container{app}
+	if t.Collector.Mode == Sidecar {
+		containers = append(containers, g.collectorContainer(ports, false))
+	}
+	deploy := g.object("apps/v1", "Deployment", t.App.Name, g.appLabels())
+	deploy.Spec = g.workloadSpec(t.App.Replicas, g.appLabels(), containers, t.Collector.Mode == Sidecar)
+	objects = append(objects, deploy)
+
+	buf := bytes.NewBufferString(header)
+	for _, o := range objects {
+		out, err := marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(out)
+	}
+	return buf.Bytes(), nil
+}
+
+// otlpPort is the port of the OTLP/gRPC receiver the app pushes to.
+var otlpPort = receivers["otlp"][0].port
+
+// generator builds the objects of a topology with the defaults set.
+type generator struct {
+	Topology
+}
+
+func (g generator) appLabels() map[string]string {
+	return map[string]string{"app": g.App.Name}
+}
+
+func (g generator) collectorLabels() map[string]string {
+	return map[string]string{"app": g.Collector.Name, "component": "otel-collector"}
+}
+
+func (g generator) object(apiVersion, kind, name string, labels map[string]string) object {
+	return object{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Metadata:   objectMeta{Name: name, Namespace: g.Namespace, Labels: labels},
+	}
+}
+
+// workloadSpec returns the spec of a Deployment, or of a DaemonSet when
+// replicas is 0. The pods mount the ConfigMap when withConfig is set.
+func (g generator) workloadSpec(replicas int, labels map[string]string, containers []container, withConfig bool) workloadSpec {
+	spec := workloadSpec{
+		Replicas: replicas,
+		Selector: labelSelector{MatchLabels: labels},
+		Template: podTemplate{
+			Metadata: objectMeta{Labels: labels},
+			Spec:     podSpec{Containers: containers},
+		},
+	}
+	if withConfig {
+		spec.Template.Spec.Volumes = []volume{{Name: configVolume, ConfigMap: configMapSource{Name: g.Collector.Name}}}
+	}
+	return spec
+}
+
+func (g generator) appContainer() container {
+	c := container{Name: g.App.Name, Image: g.App.Image, Resources: newResources(g.App.Resources)}
+	if g.App.Port > 0 {
+		c.Ports = []containerPort{{Name: "metrics", ContainerPort: g.App.Port}}
+	}
+	return c
+}
+
+// collectorContainer returns the collector container, listening on the
+// ports of the node for the receivers when hostPorts is set.
+func (g generator) collectorContainer(ports []namedPort, hostPorts bool) container {
+	ballast, _, _ := MemorySettings(g.Collector.Resources.MemoryMiB)
+	c := container{
+		Name:  g.Collector.Name,
+		Image: g.Collector.Image,
+		Args: []string{
+			"--config=" + configDir + "/" + configKey,
+			"--mem-ballast-size-mib=" + strconv.Itoa(ballast),
+		},
+		Resources:      newResources(g.Collector.Resources),
+		VolumeMounts:   []volumeMount{{Name: configVolume, MountPath: configDir}},
+		LivenessProbe:  &probe{HTTPGet: httpGet{Path: "/", Port: healthCheckPort}},
+		ReadinessProbe: &probe{HTTPGet: httpGet{Path: "/", Port: healthCheckPort}},
+	}
+	for _, p := range ports {
+		cp := containerPort{Name: p.name, ContainerPort: p.port}
+		if hostPorts && p.receiver {
+			cp.HostPort = p.port
+		}
+		c.Ports = append(c.Ports, cp)
+	}
+	return c
+}
+
+// newResources returns the limits and the requests of a container, nil when
+// there are none.
+func newResources(r Resources) *resources {
+	if r.CPU == "" && r.MemoryMiB == 0 {
+		return nil
+	}
+	values := map[string]string{}
+	if r.CPU != "" {
+		values["cpu"] = r.CPU
+	}
+	if r.MemoryMiB != 0 {
+		values["memory"] = strconv.Itoa(r.MemoryMiB) + "Mi"
+	}
+	return &resources{Limits: values, Requests: values}
+}
+
+// marshal encodes v as YAML indented by 2 spaces like kubectl does.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("manifests: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("manifests: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package manifests
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func topology(mode string) Topology {
+	return Topology{
+		Namespace: "observability",
+		App: App{
+			Name:      "test-otel",
+			Image:     "ko://github.com/skonto/test-otel/cmd/knativememstats",
+			Replicas:  2,
+			Port:      17000,
+			Resources: Resources{CPU: "100m", MemoryMiB: 100},
+		},
+		Collector: Collector{
+			Mode:      mode,
+			Receivers: []string{"otlp", "jaeger"},
+			Exporters: []Exporter{
+				{Type: "otlp", Endpoint: "backend:4317", Insecure: true},
+				{Type: "prometheus"},
+				{Type: "logging"},
+			},
+			Resources: Resources{CPU: "1", MemoryMiB: 2048},
+		},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, mode := range []string{Sidecar, Agent, Gateway} {
+		t.Run(mode, func(t *testing.T) {
+			got, err := Generate(topology(mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", mode+".yaml")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("%s is outdated, run go test -update, got:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestMemorySettings(t *testing.T) {
+	for _, tc := range []struct {
+		memory, ballast, limit, spike int
+	}{
+		{512, 170, 410, 102},
+		{2048, 682, 1639, 409},
+		// The headroom and the spike limit stop growing at 2GiB.
+		{16384, 5461, 14336, 2048},
+	} {
+		ballast, limit, spike := MemorySettings(tc.memory)
+		if ballast != tc.ballast || limit != tc.limit || spike != tc.spike {
+			t.Errorf("MemorySettings(%d) = %d, %d, %d, want %d, %d, %d", tc.memory, ballast, limit, spike, tc.ballast, tc.limit, tc.spike)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(*Topology)
+		err    string
+		mode   string
+	}{
+		{"mode", func(t *Topology) { t.Collector.Mode = "daemon" }, `unknown collector mode "daemon"`, Gateway},
+		{"no otlp", func(t *Topology) { t.Collector.Receivers = []string{"jaeger"} }, "needs the otlp receiver", Gateway},
+		{"receiver", func(t *Topology) { t.Collector.Receivers = []string{"otlp", "kafka"} }, `unknown receiver "kafka"`, Gateway},
+		{"no exporters", func(t *Topology) { t.Collector.Exporters = nil }, "no exporters", Gateway},
+		{"endpoint", func(t *Topology) { t.Collector.Exporters[0].Endpoint = "" }, `exporter "otlp" has no endpoint`, Gateway},
+		{"twice", func(t *Topology) { t.Collector.Exporters[2] = Exporter{Type: "prometheus", Endpoint: ":9090"} }, `exporter "prometheus" is defined twice`, Gateway},
+		{"port", func(t *Topology) { t.Collector.Exporters[1].Endpoint = "0.0.0.0:8888" }, "prometheus and telemetry both use port 8888", Gateway},
+		{"health check port", func(t *Topology) { t.Collector.Exporters[1].Endpoint = "0.0.0.0:13133" }, "health_check and prometheus both use port 13133", Gateway},
+		{"memory", func(t *Topology) { t.Collector.Resources.MemoryMiB = 64 }, "less than 128MiB", Gateway},
+		{"sidecar telemetry port", func(t *Topology) { t.App.Port = 8888 }, "app port 8888 is the telemetry port", Sidecar},
+		{"sidecar prometheus port", func(t *Topology) { t.App.Port = 8889 }, "app port 8889 is the prometheus port", Sidecar},
+		{"sidecar receiver port", func(t *Topology) { t.App.Port = 55680 }, "app port 55680 is the otlp-grpc port", Sidecar},
+		{"sidecar health check port", func(t *Topology) { t.App.Port = 13133 }, "app port 13133 is the health_check port", Sidecar},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			top := topology(tc.mode)
+			tc.modify(&top)
+			_, err := Generate(top)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Modes of the collector.
+const (
+	// Sidecar runs a collector in every pod of the app, the app pushes to
+	// localhost.
+	Sidecar = "sidecar"
+	// Agent runs a collector on every node with a DaemonSet, the app pushes
+	// to the collector of its node.
+	Agent = "agent"
+	// Gateway runs a Deployment of collectors behind a Service the app
+	// pushes to.
+	Gateway = "gateway"
+)
+
+const (
+	// DefaultCollectorImage is the collector image used when none is set,
+	// the release the default ports of the receivers are those of.
+	DefaultCollectorImage = "otel/opentelemetry-collector:0.17.0"
+	// DefaultCollectorMemoryMiB is the memory limit of the collector when
+	// none is set.
+	DefaultCollectorMemoryMiB = 512
+	// DefaultPrometheusEndpoint is where the prometheus exporter serves the
+	// metrics when no endpoint is set.
+	DefaultPrometheusEndpoint = "0.0.0.0:8889"
+)
+
+// Topology describes an app and the collector it pushes its telemetry to.
+type Topology struct {
+	// Namespace of the objects, none by default so that kubectl picks it.
+	Namespace string    `json:"namespace,omitempty"`
+	App       App       `json:"app"`
+	Collector Collector `json:"collector"`
+}
+
+// App is the Deployment of the instrumented app. It pushes OTLP to the
+// collector at the address in OLTP_ENDPOINT.
+type App struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Replicas is 1 by default.
+	Replicas int `json:"replicas,omitempty"`
+	// Port the app serves its Prometheus metrics on, exposed by a Service.
+	// No Service is generated when it is 0.
+	Port      int       `json:"port,omitempty"`
+	Resources Resources `json:"resources,omitempty"`
+}
+
+// Collector is the collector and the pipelines it runs.
+type Collector struct {
+	// Mode is Sidecar, Agent or Gateway.
+	Mode string `json:"mode"`
+	// Name of the DaemonSet or Deployment, and of the container and the
+	// ConfigMap, the name of the app followed by "-collector" by default.
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image,omitempty"`
+	// Replicas of a Gateway, 1 by default.
+	Replicas int `json:"replicas,omitempty"`
+	// Receivers are the types of the receivers, otlp, jaeger, zipkin or
+	// opencensus, otlp by default. The app needs otlp.
+	Receivers []string   `json:"receivers,omitempty"`
+	Exporters []Exporter `json:"exporters"`
+	// Resources of the collector, the memory limit sets the ballast and the
+	// memory_limiter processor, see MemorySettings.
+	Resources Resources `json:"resources,omitempty"`
+}
+
+// Exporter is an exporter of the collector. It is added to the pipelines of
+// the data types it supports.
+type Exporter struct {
+	// Type is otlp, jaeger, zipkin, prometheus or logging.
+	Type string `json:"type"`
+	// Name tells exporters of the same type apart, eg. "backup" for
+	// "otlp/backup".
+	Name string `json:"name,omitempty"`
+	// Endpoint the data is sent to, or served on for prometheus. It is not
+	// used by logging.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Insecure disables TLS for otlp and jaeger.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// Resources are the limits of a container, the requests are the same.
+type Resources struct {
+	// CPU is a quantity eg. "500m", none by default.
+	CPU       string `json:"cpu,omitempty"`
+	MemoryMiB int    `json:"memoryMiB,omitempty"`
+}
+
+// LoadTopology reads a JSON encoded Topology from a file.
+func LoadTopology(filename string) (Topology, error) {
+	var t Topology
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return t, fmt.Errorf("manifests: %w", err)
+	}
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return t, fmt.Errorf("manifests: %s: %w", filename, err)
+	}
+	return t, nil
+}
+
+// withDefaults returns a copy of the topology with the defaults set.
+func (t Topology) withDefaults() Topology {
+	if t.App.Replicas == 0 {
+		t.App.Replicas = 1
+	}
+	c := &t.Collector
+	if c.Name == "" {
+		c.Name = t.App.Name + "-collector"
+	}
+	if c.Image == "" {
+		c.Image = DefaultCollectorImage
+	}
+	if c.Replicas == 0 {
+		c.Replicas = 1
+	}
+	if len(c.Receivers) == 0 {
+		c.Receivers = []string{"otlp"}
+	}
+	if c.Resources.MemoryMiB == 0 {
+		c.Resources.MemoryMiB = DefaultCollectorMemoryMiB
+	}
+	c.Exporters = append([]Exporter(nil), c.Exporters...)
+	for i := range c.Exporters {
+		if c.Exporters[i].Type == "prometheus" && c.Exporters[i].Endpoint == "" {
+			c.Exporters[i].Endpoint = DefaultPrometheusEndpoint
+		}
+	}
+	return t
+}
+
+// validate checks a topology with the defaults set.
+func (t Topology) validate() error {
+	if t.App.Name == "" || t.App.Image == "" {
+		return fmt.Errorf("manifests: the app needs a name and an image")
+	}
+	switch t.Collector.Mode {
+	case Sidecar, Agent, Gateway:
+	default:
+		return fmt.Errorf("manifests: unknown collector mode %q, must be %s, %s or %s", t.Collector.Mode, Sidecar, Agent, Gateway)
+	}
+	if t.App.Replicas < 0 || t.Collector.Replicas < 0 || t.App.Port < 0 || t.App.Port > 65535 {
+		return fmt.Errorf("manifests: invalid replicas or port")
+	}
+	otlp := false
+	for _, r := range t.Collector.Receivers {
+		if _, ok := receivers[r]; !ok {
+			return fmt.Errorf("manifests: unknown receiver %q", r)
+		}
+		otlp = otlp || r == "otlp"
+	}
+	if !otlp {
+		return fmt.Errorf("manifests: the collector needs the otlp receiver the app pushes to")
+	}
+	if len(t.Collector.Exporters) == 0 {
+		return fmt.Errorf("manifests: the collector has no exporters")
+	}
+	names := map[string]bool{}
+	for _, e := range t.Collector.Exporters {
+		if _, ok := exporters[e.Type]; !ok {
+			return fmt.Errorf("manifests: unknown exporter %q", e.Type)
+		}
+		if names[e.id()] {
+			return fmt.Errorf("manifests: exporter %q is defined twice", e.id())
+		}
+		names[e.id()] = true
+		if e.Endpoint == "" && e.Type != "logging" {
+			return fmt.Errorf("manifests: exporter %q has no endpoint", e.id())
+		}
+	}
+	if m := t.Collector.Resources.MemoryMiB; m < minCollectorMemoryMiB {
+		return fmt.Errorf("manifests: the collector memory limit, %dMiB, is less than %dMiB", m, minCollectorMemoryMiB)
+	}
+	return nil
+}
+
+// id returns the key of the exporter in the collector configuration.
+func (e Exporter) id() string {
+	if e.Name == "" {
+		return e.Type
+	}
+	return e.Type + "/" + e.Name
+}
@@ -66,6 +66,12 @@ func WithMetricPrefix(prefix string) Option {
 	return metricPrefixOption(prefix)
 }
 
+// WithMeterProvider sets the MeterProvider the metrics are reported to,
+// the global one by default.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
 type minimumReadMemStatsIntervalOption time.Duration
 
 type extraRuntimeMetricsOption bool
@@ -74,6 +80,10 @@ type labelsOption []label.KeyValue
 
 type metricPrefixOption string
 
+type meterProviderOption struct {
+	metric.MeterProvider
+}
+
 // ApplyRuntime implements Option.
 func (o minimumReadMemStatsIntervalOption) ApplyRuntime(c *config) {
 	if o >= 0 {
@@ -93,6 +103,10 @@ func (o metricPrefixOption) ApplyRuntime(c *config) {
 	c.metricPrefix = string(o)
 }
 
+func (o meterProviderOption) ApplyRuntime(c *config) {
+	c.MeterProvider = o.MeterProvider
+}
+
 type memstatsOtel struct {
 	config config
 
@@ -0,0 +1,41 @@
+package memstats
+
+import (
+	"context"
+	"testing"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// BenchmarkBatchObserver measures a collection of the runtime metrics,
+// reading the memory statistics on every collection or reusing the last
+// ones read, as with the default minimum interval.
+func BenchmarkBatchObserver(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		opts []Option
+	}{
+		{"readmemstats", []Option{WithMinimumReadMemStatsInterval(0)}},
+		{"cached", nil},
+		{"cached-extra", []Option{WithExtraRuntimeMetrics()}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			cont := controller.New(processor.New(simple.NewWithInexpensiveDistribution(), export.CumulativeExportKindSelector()),
+				controller.WithCollectPeriod(0))
+			if err := Start(append(c.opts, WithMeterProvider(cont.MeterProvider()))...); err != nil {
+				b.Fatal("failed to start:", err)
+			}
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if err := cont.Collect(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
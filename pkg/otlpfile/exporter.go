@@ -0,0 +1,266 @@
+// Package otlpfile provides a metric exporter that appends every checkpoint
+// to a file as an ExportMetricsServiceRequest in the OTLP JSON encoding, one
+// request per line, so that metrics recorded offline can be inspected or
+// replayed into a collector later. The file is rotated by size and
+// optionally by age, rotated files are compressed and only the most recent
+// ones are kept.
+package otlpfile
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/transform"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// DefaultMaxBytes is the size after which the file is rotated, 100MiB.
+	DefaultMaxBytes = 100 << 20
+	// DefaultMaxBackups is the number of rotated files kept by default.
+	DefaultMaxBackups = 5
+
+	// backupTimeFormat is embedded in the name of rotated files, it sorts
+	// in chronological order.
+	backupTimeFormat = "20060102T150405.000000000"
+	gzipExt          = ".gz"
+)
+
+// ErrClosed is returned when exporting after Close.
+var ErrClosed = errors.New("otlpfile: exporter is closed")
+
+// Exporter is an export.Exporter writing OTLP JSON lines to a file.
+type Exporter struct {
+	cfg  config
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	closed  bool
+	pending sync.WaitGroup
+
+	// housekeeping serializes compression and retention, so that a file
+	// is never removed while it is being compressed.
+	housekeeping sync.Mutex
+}
+
+var _ export.Exporter = (*Exporter)(nil)
+
+// New creates an exporter that appends to path, creating the file and its
+// directory if needed. Rotated files are written next to it, eg.
+// metrics-20210128T130000.000000000.jsonl.gz for metrics.jsonl.
+func New(path string, opts ...Option) (*Exporter, error) {
+	e := &Exporter{cfg: newConfig(opts...), path: path}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("otlpfile: %w", err)
+	}
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ExportKindFor implements export.ExportKindSelector.
+func (e *Exporter) ExportKindFor(desc *metric.Descriptor, kind aggregation.Kind) export.ExportKind {
+	return e.cfg.selector.ExportKindFor(desc, kind)
+}
+
+// Export implements export.Exporter.
+func (e *Exporter) Export(ctx context.Context, cps export.CheckpointSet) error {
+	e.mu.Lock()
+	closed := e.closed
+	e.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	rms, err := transform.CheckpointSet(ctx, e, cps)
+	if err != nil {
+		return err
+	}
+	if len(rms) == 0 {
+		return nil
+	}
+	line, err := protojson.Marshal(&colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: rms})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	if e.shouldRotate(int64(len(line))) {
+		if err := e.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := e.file.Write(line)
+	e.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("otlpfile: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file and waits for rotated files to be compressed.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	var err error
+	if !e.closed {
+		e.closed = true
+		err = e.file.Close()
+	}
+	e.mu.Unlock()
+	e.pending.Wait()
+	return err
+}
+
+func (e *Exporter) open() error {
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("otlpfile: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("otlpfile: %w", err)
+	}
+	e.file = f
+	e.size = info.Size()
+	e.opened = e.cfg.now()
+	return nil
+}
+
+// shouldRotate tells whether a line of n bytes must go to a new file. A
+// line is never split, a single line larger than maxBytes gets a file of
+// its own.
+func (e *Exporter) shouldRotate(n int64) bool {
+	if e.size == 0 {
+		return false
+	}
+	if e.size+n > e.cfg.maxBytes {
+		return true
+	}
+	return e.cfg.maxAge > 0 && e.cfg.now().Sub(e.opened) >= e.cfg.maxAge
+}
+
+// rotate moves the current file aside and opens a new one. Compression and
+// retention run in the background so that exports are not delayed.
+func (e *Exporter) rotate() error {
+	if err := e.file.Close(); err != nil {
+		return fmt.Errorf("otlpfile: %w", err)
+	}
+	ext := filepath.Ext(e.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(e.path, ext), e.cfg.now().UTC().Format(backupTimeFormat), ext)
+	if err := os.Rename(e.path, backup); err != nil {
+		return fmt.Errorf("otlpfile: %w", err)
+	}
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.pending.Add(1)
+	go func() {
+		defer e.pending.Done()
+		e.housekeeping.Lock()
+		defer e.housekeeping.Unlock()
+		if e.cfg.compress {
+			// The file is gone if the retention already removed it.
+			if err := compress(backup); err != nil && !os.IsNotExist(err) {
+				otel.Handle(fmt.Errorf("otlpfile: failed to compress %s: %w", backup, err))
+			}
+		}
+		e.removeOldBackups()
+	}()
+	return nil
+}
+
+// compress replaces name with a gzip compressed name.gz.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+gzipExt+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(name+gzipExt+".tmp", name+gzipExt); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// backups returns the rotated files, oldest first.
+func (e *Exporter) backups() ([]string, error) {
+	ext := filepath.Ext(e.path)
+	pattern := strings.TrimSuffix(e.path, ext) + "-*" + ext
+	plain, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(pattern + gzipExt)
+	if err != nil {
+		return nil, err
+	}
+	// Without an extension the plain pattern matches the compressed
+	// backups, and the ones being compressed, too.
+	names := compressed
+	for _, name := range plain {
+		if !strings.HasSuffix(name, gzipExt) && !strings.HasSuffix(name, gzipExt+".tmp") {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.TrimSuffix(names[i], gzipExt) < strings.TrimSuffix(names[j], gzipExt)
+	})
+	return names, nil
+}
+
+// removeOldBackups enforces the retention count.
+func (e *Exporter) removeOldBackups() {
+	if e.cfg.maxBackups == 0 {
+		return
+	}
+	names, err := e.backups()
+	if err != nil {
+		otel.Handle(fmt.Errorf("otlpfile: %w", err))
+		return
+	}
+	for len(names) > e.cfg.maxBackups {
+		if err := os.Remove(names[0]); err != nil && !os.IsNotExist(err) {
+			otel.Handle(fmt.Errorf("otlpfile: %w", err))
+		}
+		names = names[1:]
+	}
+}
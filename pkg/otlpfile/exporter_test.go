@@ -0,0 +1,200 @@
+package otlpfile
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// clock is a fake time source advanced by the tests.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+// exportN collects and exports a counter n times, the counter is at i+1 in
+// the i-th export.
+func exportN(t *testing.T, e *Exporter, n int) {
+	t.Helper()
+	proc := processor.New(simple.NewWithInexpensiveDistribution(), e)
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	counter := metric.Must(cont.MeterProvider().Meter("test")).NewInt64Counter("request.count")
+	for i := 0; i < n; i++ {
+		counter.Add(context.Background(), 1)
+		if err := cont.Collect(context.Background()); err != nil {
+			t.Fatal("failed to collect:", err)
+		}
+		if err := e.Export(context.Background(), proc.CheckpointSet()); err != nil {
+			t.Fatal("unexpected export error:", err)
+		}
+	}
+}
+
+// readSums decodes every line of a, possibly compressed, file into the
+// value of the counter.
+func readSums(t *testing.T, name string) []int64 {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, gzipExt) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	var sums []int64
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		req := &colmetricpb.ExportMetricsServiceRequest{}
+		if err := protojson.Unmarshal(sc.Bytes(), req); err != nil {
+			t.Fatalf("%s: invalid line %q: %v", name, sc.Text(), err)
+		}
+		m := req.GetResourceMetrics()[0].GetInstrumentationLibraryMetrics()[0].GetMetrics()[0]
+		sums = append(sums, m.GetIntSum().GetDataPoints()[0].GetValue())
+	}
+	return sums
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "otlpfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &clock{now: time.Date(2021, 1, 28, 13, 0, 0, 0, time.UTC)}
+	path := filepath.Join(dir, "metrics.jsonl")
+
+	// Every line goes to a file of its own.
+	e, err := New(path, WithMaxBytes(1), WithMaxBackups(2), clockOption(c.Now))
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	for i := 0; i < 4; i++ {
+		exportN(t, e, 1)
+		c.now = c.now.Add(time.Second)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal("failed to close:", err)
+	}
+
+	backups, err := e.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "metrics-20210128T130002.000000000.jsonl.gz"),
+		filepath.Join(dir, "metrics-20210128T130003.000000000.jsonl.gz"),
+	}
+	if len(backups) != len(want) || backups[0] != want[0] || backups[1] != want[1] {
+		t.Fatalf("got backups %v, want %v", backups, want)
+	}
+	for _, name := range append(backups, path) {
+		if got := readSums(t, name); len(got) != 1 || got[0] != 1 {
+			t.Errorf("%s: got sums %v, want [1]", name, got)
+		}
+	}
+}
+
+func TestRotateWithoutExtension(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &clock{now: time.Date(2021, 1, 28, 13, 0, 0, 0, time.UTC)}
+	path := filepath.Join(dir, "metrics")
+
+	e, err := New(path, WithMaxBytes(1), WithMaxBackups(2), clockOption(c.Now))
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	for i := 0; i < 4; i++ {
+		exportN(t, e, 1)
+		c.now = c.now.Add(time.Second)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal("failed to close:", err)
+	}
+
+	backups, err := e.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "metrics-20210128T130002.000000000.gz"),
+		filepath.Join(dir, "metrics-20210128T130003.000000000.gz"),
+	}
+	if len(backups) != len(want) || backups[0] != want[0] || backups[1] != want[1] {
+		t.Fatalf("got backups %v, want %v", backups, want)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := &clock{now: time.Date(2021, 1, 28, 13, 0, 0, 0, time.UTC)}
+	path := filepath.Join(dir, "metrics.jsonl")
+
+	e, err := New(path, WithMaxAge(time.Minute), WithCompression(false), clockOption(c.Now))
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	exportN(t, e, 3)
+	c.now = c.now.Add(time.Minute)
+	exportN(t, e, 1)
+	if err := e.Close(); err != nil {
+		t.Fatal("failed to close:", err)
+	}
+
+	backup := filepath.Join(dir, "metrics-20210128T130100.000000000.jsonl")
+	if got := readSums(t, backup); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("got sums %v in the rotated file, want [1 2 3]", got)
+	}
+	if got := readSums(t, path); len(got) != 1 {
+		t.Errorf("got sums %v in the current file, want one line", got)
+	}
+}
+
+func TestAppendsAcrossRestarts(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.jsonl")
+
+	for i := 0; i < 2; i++ {
+		e, err := New(path)
+		if err != nil {
+			t.Fatal("failed to create exporter:", err)
+		}
+		exportN(t, e, 2)
+		if err := e.Close(); err != nil {
+			t.Fatal("failed to close:", err)
+		}
+		if err := e.Export(context.Background(), nil); err != ErrClosed {
+			t.Errorf("got error %v after close, want %v", err, ErrClosed)
+		}
+	}
+	if got := readSums(t, path); len(got) != 4 {
+		t.Errorf("got sums %v, want four lines", got)
+	}
+}
@@ -0,0 +1,111 @@
+package otlpfile
+
+import (
+	"time"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+// config contains the settings of the file exporter.
+type config struct {
+	maxBytes   int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	selector   export.ExportKindSelector
+	now        func() time.Time
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		maxBytes:   DefaultMaxBytes,
+		maxBackups: DefaultMaxBackups,
+		compress:   true,
+		selector:   export.CumulativeExportKindSelector(),
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the file exporter.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithMaxBytes sets the size after which the file is rotated. Non positive
+// values are ignored.
+func WithMaxBytes(n int64) Option {
+	return maxBytesOption(n)
+}
+
+// WithMaxAge rotates the file once it is older than d, regardless of its
+// size. By default files are only rotated by size.
+func WithMaxAge(d time.Duration) Option {
+	return maxAgeOption(d)
+}
+
+// WithMaxBackups sets how many rotated files are kept, the oldest are
+// removed first. Zero keeps every file. Negative values are ignored.
+func WithMaxBackups(n int) Option {
+	return maxBackupsOption(n)
+}
+
+// WithCompression sets whether rotated files are compressed with gzip,
+// enabled by default.
+func WithCompression(enabled bool) Option {
+	return compressionOption(enabled)
+}
+
+// WithExportKindSelector sets the temporality requested from the processor,
+// cumulative by default.
+func WithExportKindSelector(selector export.ExportKindSelector) Option {
+	return selectorOption{selector}
+}
+
+type maxBytesOption int64
+
+type maxAgeOption time.Duration
+
+type maxBackupsOption int
+
+type compressionOption bool
+
+type selectorOption struct{ export.ExportKindSelector }
+
+type clockOption func() time.Time
+
+// Apply implements Option.
+func (o maxBytesOption) Apply(c *config) {
+	if o > 0 {
+		c.maxBytes = int64(o)
+	}
+}
+
+func (o maxAgeOption) Apply(c *config) {
+	c.maxAge = time.Duration(o)
+}
+
+func (o maxBackupsOption) Apply(c *config) {
+	if o >= 0 {
+		c.maxBackups = int(o)
+	}
+}
+
+func (o compressionOption) Apply(c *config) {
+	c.compress = bool(o)
+}
+
+func (o selectorOption) Apply(c *config) {
+	if o.ExportKindSelector != nil {
+		c.selector = o.ExportKindSelector
+	}
+}
+
+func (o clockOption) Apply(c *config) {
+	c.now = o
+}
@@ -0,0 +1,219 @@
+package otlphttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client sends serialized ExportMetricsServiceRequests to an OTLP/HTTP
+// receiver. It is what the driver uses under the hood, exposed for callers
+// that already hold encoded requests, eg. ones replayed from disk.
+type Client struct {
+	cfg        config
+	url        string
+	httpClient *http.Client
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewClient creates a new Client, it accepts the same options as NewDriver.
+func NewClient(opts ...Option) *Client {
+	cfg := newConfig(opts...)
+
+	urlPath := strings.TrimSpace(cfg.metricsURLPath)
+	if urlPath == "" {
+		urlPath = DefaultMetricsPath
+	}
+	urlPath = path.Clean("/" + urlPath)
+	scheme := "https"
+	if cfg.insecure {
+		scheme = "http"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.tlsCfg != nil {
+		transport.TLSClientConfig = cfg.tlsCfg
+	}
+	var rt http.RoundTripper = transport
+	if cfg.wrapTransport != nil {
+		rt = cfg.wrapTransport(transport)
+	}
+	return &Client{
+		cfg:        cfg,
+		url:        fmt.Sprintf("%s://%s%s", scheme, cfg.endpoint, urlPath),
+		httpClient: &http.Client{Transport: rt},
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Close aborts any ongoing retries and closes idle connections.
+func (c *Client) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		c.httpClient.CloseIdleConnections()
+	})
+}
+
+// Send posts a serialized ExportMetricsServiceRequest to the collector,
+// retrying while it answers with a retryable status. The error of a
+// request the collector rejects, with a 4xx status other than 408 and 429,
+// is a *RejectedError.
+func (c *Client) Send(ctx context.Context, raw []byte) error {
+	body, err := c.encode(raw)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.contextWithStop(ctx)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.post(ctx, body)
+		if err != nil {
+			return err
+		}
+		// Drain the body so that the connection can be reused.
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+			return nil
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if attempt+1 >= c.cfg.maxAttempts {
+				return fmt.Errorf("otlphttp: failed to send to %s after %d attempts: %s", c.url, c.cfg.maxAttempts, resp.Status)
+			}
+			wait, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if ok {
+				if wait > c.cfg.maxRetryAfter {
+					wait = c.cfg.maxRetryAfter
+				}
+			} else {
+				wait = backoff(c.cfg.backoff, attempt)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		default:
+			if rejected(resp.StatusCode) {
+				return &RejectedError{URL: c.url, Status: resp.Status, StatusCode: resp.StatusCode}
+			}
+			return fmt.Errorf("otlphttp: failed to send to %s: %s", c.url, resp.Status)
+		}
+	}
+}
+
+// RejectedError is returned when the collector rejects a request. It is
+// permanent: sending the request again would fail the same way, so that
+// eg. diskqueue drops it rather than retrying it.
+type RejectedError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("otlphttp: failed to send to %s: %s", e.URL, e.Status)
+}
+
+// Permanent tells that the request must not be retried.
+func (e *RejectedError) Permanent() bool { return true }
+
+// rejected tells whether the collector refused the request itself, it is
+// not retried as it would be refused again.
+func rejected(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+func (c *Client) post(ctx context.Context, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.cfg.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.cfg.compression == GzipCompression {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return c.httpClient.Do(req)
+}
+
+// encode compresses the payload once, so that retries resend the same bytes.
+func (c *Client) encode(raw []byte) ([]byte, error) {
+	if c.cfg.compression != GzipCompression {
+		return raw, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// contextWithStop returns a context that is also cancelled when the client
+// is closed.
+func (c *Client) contextWithStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.stopCh:
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+// retryAfter parses a Retry-After header value, given either in seconds or
+// as an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// backoff returns the wait before the next attempt: a random multiple of the
+// base in [0, 2^(attempt+1)) plus up to 5% jitter either way.
+func backoff(base time.Duration, attempt int) time.Duration {
+	if attempt > 10 {
+		attempt = 10
+	}
+	k := rand.Int63n(int64(1) << uint(attempt+1))
+	jitter := (rand.Float64() - 0.5) / 10 * float64(base)
+	return time.Duration(k)*base + time.Duration(jitter)
+}
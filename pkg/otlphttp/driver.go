@@ -0,0 +1,68 @@
+// Package otlphttp implements an otlp.ProtocolDriver that sends metrics to
+// the collector as protobuf encoded ExportMetricsServiceRequests over plain
+// HTTP/1.1, for environments where gRPC egress is not available.
+package otlphttp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/skonto/test-otel/pkg/internal/transform"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	metricsdk "go.opentelemetry.io/otel/sdk/export/metric"
+	tracesdk "go.opentelemetry.io/otel/sdk/export/trace"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const contentType = "application/x-protobuf"
+
+// ErrTracesUnsupported is returned when spans are handed to the driver.
+// Use otlp.NewSplitDriver to send traces with another driver.
+var ErrTracesUnsupported = errors.New("otlphttp: traces are not supported")
+
+type driver struct {
+	client *Client
+}
+
+var _ otlp.ProtocolDriver = (*driver)(nil)
+
+// NewDriver creates a new HTTP protocol driver.
+func NewDriver(opts ...Option) otlp.ProtocolDriver {
+	return &driver{client: NewClient(opts...)}
+}
+
+// Start implements otlp.ProtocolDriver. Connections are established lazily.
+func (d *driver) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop implements otlp.ProtocolDriver. It aborts any ongoing retries.
+func (d *driver) Stop(ctx context.Context) error {
+	d.client.Close()
+	return nil
+}
+
+// ExportMetrics implements otlp.ProtocolDriver.
+func (d *driver) ExportMetrics(ctx context.Context, cps metricsdk.CheckpointSet, selector metricsdk.ExportKindSelector) error {
+	rms, err := transform.CheckpointSet(ctx, selector, cps)
+	if err != nil {
+		return err
+	}
+	if len(rms) == 0 {
+		return nil
+	}
+	raw, err := proto.Marshal(&colmetricpb.ExportMetricsServiceRequest{ResourceMetrics: rms})
+	if err != nil {
+		return err
+	}
+	return d.client.Send(ctx, raw)
+}
+
+// ExportTraces implements otlp.ProtocolDriver.
+func (d *driver) ExportTraces(ctx context.Context, ss []*tracesdk.SpanSnapshot) error {
+	if len(ss) == 0 {
+		return nil
+	}
+	return ErrTracesUnsupported
+}
@@ -0,0 +1,236 @@
+package otlphttp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/diskqueue"
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a minimal OTLP/HTTP receiver answering with the queued
+// status codes before accepting requests.
+type collector struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*colmetricpb.ExportMetricsServiceRequest
+	headers  []http.Header
+	paths    []string
+	attempts int32
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&c.attempts, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(status)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(raw, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header.Clone())
+	c.paths = append(c.paths, r.URL.Path)
+	w.WriteHeader(http.StatusOK)
+}
+
+func newCheckpoint(t *testing.T) export.CheckpointSet {
+	t.Helper()
+	p := metrictest.NewPipeline(metrictest.WithResource(resource.NewWithAttributes(label.String("service.name", "test"))))
+	counter := metric.Must(p.Meter()).NewInt64Counter("request.count")
+	counter.Add(context.Background(), 7, label.String("path", "/api/list/foo"))
+	return p.CheckpointSet(t)
+}
+
+func newTestDriver(t *testing.T, c *collector, opts ...Option) (*driver, func()) {
+	t.Helper()
+	srv := httptest.NewServer(c)
+	opts = append([]Option{
+		WithEndpoint(strings.TrimPrefix(srv.URL, "http://")),
+		WithInsecure(),
+		WithBackoff(time.Millisecond),
+	}, opts...)
+	d := NewDriver(opts...).(*driver)
+	return d, func() {
+		_ = d.Stop(context.Background())
+		srv.Close()
+	}
+}
+
+func TestExportMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantPath    string
+		wantEncoded string
+	}{
+		{name: "defaults", wantPath: DefaultMetricsPath},
+		{name: "custom path", opts: []Option{WithMetricsURLPath("otlp/v1/metrics/")}, wantPath: "/otlp/v1/metrics"},
+		{name: "gzip", opts: []Option{WithCompression(GzipCompression)}, wantPath: DefaultMetricsPath, wantEncoded: "gzip"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &collector{}
+			d, cleanup := newTestDriver(t, c, tc.opts...)
+			defer cleanup()
+
+			if err := d.ExportMetrics(context.Background(), newCheckpoint(t), export.CumulativeExportKindSelector()); err != nil {
+				t.Fatal("unexpected export error:", err)
+			}
+			if len(c.requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(c.requests))
+			}
+			if c.paths[0] != tc.wantPath {
+				t.Errorf("got path %q, want %q", c.paths[0], tc.wantPath)
+			}
+			if got := c.headers[0].Get("Content-Type"); got != contentType {
+				t.Errorf("got content type %q, want %q", got, contentType)
+			}
+			if got := c.headers[0].Get("Content-Encoding"); got != tc.wantEncoded {
+				t.Errorf("got content encoding %q, want %q", got, tc.wantEncoded)
+			}
+
+			rms := c.requests[0].GetResourceMetrics()
+			if len(rms) != 1 {
+				t.Fatalf("got %d resource metrics, want 1", len(rms))
+			}
+			attrs := rms[0].GetResource().GetAttributes()
+			if len(attrs) != 1 || attrs[0].GetKey() != "service.name" || attrs[0].GetValue().GetStringValue() != "test" {
+				t.Errorf("unexpected resource attributes %v", attrs)
+			}
+			m := rms[0].GetInstrumentationLibraryMetrics()[0].GetMetrics()[0]
+			if m.GetName() != "request.count" {
+				t.Errorf("got metric %q, want request.count", m.GetName())
+			}
+			pts := m.GetIntSum().GetDataPoints()
+			if len(pts) != 1 || pts[0].GetValue() != 7 {
+				t.Errorf("unexpected data points %v", pts)
+			}
+			if !m.GetIntSum().GetIsMonotonic() {
+				t.Error("counter should be exported as monotonic")
+			}
+		})
+	}
+}
+
+func TestExportMetricsRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		wantErr       bool
+		wantPermanent bool
+		wantAttempts  int32
+	}{
+		{name: "retry after 503 and 429", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, wantAttempts: 3},
+		{name: "give up", statuses: []int{503, 503, 503, 503, 503}, wantErr: true, wantAttempts: 3},
+		{name: "no retry on bad request", statuses: []int{http.StatusBadRequest}, wantErr: true, wantPermanent: true, wantAttempts: 1},
+		{name: "no retry on server error", statuses: []int{http.StatusInternalServerError}, wantErr: true, wantAttempts: 1},
+		{name: "give up on throttling", statuses: []int{429, 429, 429}, wantErr: true, wantAttempts: 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &collector{statuses: tc.statuses}
+			d, cleanup := newTestDriver(t, c, WithMaxAttempts(3))
+			defer cleanup()
+
+			err := d.ExportMetrics(context.Background(), newCheckpoint(t), export.CumulativeExportKindSelector())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			// Rejected requests are dropped from the disk queue.
+			var rejected *RejectedError
+			if got := errors.As(err, &rejected) && diskqueue.IsPermanent(err); got != tc.wantPermanent {
+				t.Errorf("got permanent error %v, want %v", got, tc.wantPermanent)
+			}
+			if got := atomic.LoadInt32(&c.attempts); got != tc.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestStopAbortsRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	d := NewDriver(WithEndpoint(strings.TrimPrefix(srv.URL, "http://")), WithInsecure())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.ExportMetrics(context.Background(), newCheckpoint(t), export.CumulativeExportKindSelector())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := d.Stop(context.Background()); err != nil {
+		t.Fatal("unexpected stop error:", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Errorf("got error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("export was not aborted by Stop")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: "Mon, 01 Feb 2021 10:00:05 GMT", want: 5 * time.Second, wantOK: true},
+		{value: "Mon, 01 Feb 2021 09:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tc := range tests {
+		got, ok := retryAfter(tc.value, now)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("retryAfter(%q) = %v, %v, want %v, %v", tc.value, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
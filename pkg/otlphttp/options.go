@@ -0,0 +1,196 @@
+package otlphttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// Compression describes the compression used for payloads sent to the
+// collector.
+type Compression int
+
+const (
+	// NoCompression tells the driver to send payloads without
+	// compression.
+	NoCompression Compression = iota
+	// GzipCompression tells the driver to send payloads after
+	// compressing them with gzip.
+	GzipCompression
+)
+
+const (
+	// DefaultEndpoint is the address of the OTLP/HTTP receiver of a local
+	// collector.
+	DefaultEndpoint = "0.0.0.0:55681"
+	// DefaultMetricsPath is the URL path the collector receives metrics on.
+	DefaultMetricsPath = "/v1/metrics"
+	// DefaultMaxAttempts is how many times a payload is sent before giving
+	// up when the collector keeps answering with a retryable status.
+	DefaultMaxAttempts = 5
+	// DefaultBackoff is the base of the exponential backoff used between
+	// retries when the collector does not send a Retry-After header.
+	DefaultBackoff = 300 * time.Millisecond
+	// DefaultMaxRetryAfter caps the wait requested by a Retry-After header.
+	DefaultMaxRetryAfter = 30 * time.Second
+)
+
+// config contains the settings of the HTTP driver.
+type config struct {
+	endpoint       string
+	metricsURLPath string
+	compression    Compression
+	maxAttempts    int
+	backoff        time.Duration
+	maxRetryAfter  time.Duration
+	insecure       bool
+	tlsCfg         *tls.Config
+	headers        map[string]string
+	wrapTransport  func(http.RoundTripper) http.RoundTripper
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		endpoint:       DefaultEndpoint,
+		metricsURLPath: DefaultMetricsPath,
+		compression:    NoCompression,
+		maxAttempts:    DefaultMaxAttempts,
+		backoff:        DefaultBackoff,
+		maxRetryAfter:  DefaultMaxRetryAfter,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the HTTP driver.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithEndpoint sets the host:port of the collector. The endpoint must not
+// contain a URL path, use WithMetricsURLPath for that.
+func WithEndpoint(endpoint string) Option {
+	return endpointOption(endpoint)
+}
+
+// WithMetricsURLPath overrides the URL path metrics are posted to. If
+// unset, DefaultMetricsPath is used.
+func WithMetricsURLPath(urlPath string) Option {
+	return metricsURLPathOption(urlPath)
+}
+
+// WithCompression sets the compression applied to the request body.
+func WithCompression(compression Compression) Option {
+	return compressionOption(compression)
+}
+
+// WithMaxAttempts sets how many times a payload is sent on retryable
+// errors. Values lower than one are ignored.
+func WithMaxAttempts(attempts int) Option {
+	return maxAttemptsOption(attempts)
+}
+
+// WithBackoff sets the base duration of the exponential backoff used
+// between retries. Non positive values are ignored.
+func WithBackoff(d time.Duration) Option {
+	return backoffOption(d)
+}
+
+// WithMaxRetryAfter caps the delay a collector may request with the
+// Retry-After header. Non positive values are ignored.
+func WithMaxRetryAfter(d time.Duration) Option {
+	return maxRetryAfterOption(d)
+}
+
+// WithInsecure makes the driver use plain HTTP instead of HTTPS.
+func WithInsecure() Option {
+	return insecureOption(true)
+}
+
+// WithTLSClientConfig sets the TLS configuration used to reach the collector.
+func WithTLSClientConfig(tlsCfg *tls.Config) Option {
+	return tlsClientConfigOption{tlsCfg}
+}
+
+// WithHeaders sets extra headers sent with each request, eg. for
+// authentication. Content-Type and Content-Encoding are set by the driver.
+func WithHeaders(headers map[string]string) Option {
+	return headersOption(headers)
+}
+
+// WithTransportWrapper wraps the transport requests are sent with, eg. to
+// instrument them.
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return transportWrapperOption(wrap)
+}
+
+type endpointOption string
+
+type metricsURLPathOption string
+
+type compressionOption Compression
+
+type maxAttemptsOption int
+
+type backoffOption time.Duration
+
+type maxRetryAfterOption time.Duration
+
+type insecureOption bool
+
+type tlsClientConfigOption struct{ *tls.Config }
+
+type headersOption map[string]string
+
+type transportWrapperOption func(http.RoundTripper) http.RoundTripper
+
+// Apply implements Option.
+func (o endpointOption) Apply(c *config) {
+	c.endpoint = string(o)
+}
+
+func (o metricsURLPathOption) Apply(c *config) {
+	c.metricsURLPath = string(o)
+}
+
+func (o compressionOption) Apply(c *config) {
+	c.compression = Compression(o)
+}
+
+func (o maxAttemptsOption) Apply(c *config) {
+	if o > 0 {
+		c.maxAttempts = int(o)
+	}
+}
+
+func (o backoffOption) Apply(c *config) {
+	if o > 0 {
+		c.backoff = time.Duration(o)
+	}
+}
+
+func (o maxRetryAfterOption) Apply(c *config) {
+	if o > 0 {
+		c.maxRetryAfter = time.Duration(o)
+	}
+}
+
+func (o insecureOption) Apply(c *config) {
+	c.insecure = bool(o)
+}
+
+func (o tlsClientConfigOption) Apply(c *config) {
+	c.tlsCfg = o.Config
+}
+
+func (o headersOption) Apply(c *config) {
+	c.headers = o
+}
+
+func (o transportWrapperOption) Apply(c *config) {
+	c.wrapTransport = o
+}
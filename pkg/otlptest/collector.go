@@ -0,0 +1,185 @@
+// Package otlptest runs an in-process OTLP/gRPC collector for tests, so
+// that pipelines exporting metrics and spans can be tested with go test
+// alone. The collector records every request it receives and offers helpers
+// to find the metrics and spans and check their type, labels and resource:
+//
+//	c, err := otlptest.NewCollector()
+//	...
+//	defer c.Stop()
+//	// export to c.Endpoint() without TLS
+//	m := c.RequireMetric(t, "requests")
+//	m.AssertType(t, otlptest.IntSum)
+//	m.AssertPoint(t, map[string]string{"path": "/"})
+package otlptest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Collector is an OTLP/gRPC server implementing the metrics and the trace
+// services.
+type Collector struct {
+	server   *grpc.Server
+	listener net.Listener
+
+	mu      sync.Mutex
+	metrics []*colmetricpb.ExportMetricsServiceRequest
+	traces  []*coltracepb.ExportTraceServiceRequest
+	headers []metadata.MD
+	err     error
+	// received is closed and replaced when a request is received.
+	received chan struct{}
+}
+
+// NewCollector starts a collector.
+func NewCollector(opts ...Option) (*Collector, error) {
+	cfg := newConfig(opts...)
+	lis, err := net.Listen("tcp", cfg.address)
+	if err != nil {
+		return nil, fmt.Errorf("otlptest: %w", err)
+	}
+	c := &Collector{
+		server:   grpc.NewServer(cfg.serverOptions...),
+		listener: lis,
+		received: make(chan struct{}),
+	}
+	colmetricpb.RegisterMetricsServiceServer(c.server, metricsService{c: c})
+	coltracepb.RegisterTraceServiceServer(c.server, traceService{c: c})
+	go func() {
+		_ = c.server.Serve(lis)
+	}()
+	return c, nil
+}
+
+// Endpoint returns the host:port the collector listens on.
+func (c *Collector) Endpoint() string {
+	return c.listener.Addr().String()
+}
+
+// Stop closes the listener and the open connections.
+func (c *Collector) Stop() {
+	c.server.Stop()
+}
+
+// SetError makes the collector fail the next requests with err until it is
+// reset with nil. Use status.Error for a gRPC code other than Unknown. The
+// failed requests are not recorded.
+func (c *Collector) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Reset forgets the requests received so far.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = nil
+	c.traces = nil
+	c.headers = nil
+}
+
+// MetricsRequests returns the metrics requests received, in order.
+func (c *Collector) MetricsRequests() []*colmetricpb.ExportMetricsServiceRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*colmetricpb.ExportMetricsServiceRequest(nil), c.metrics...)
+}
+
+// TraceRequests returns the trace requests received, in order.
+func (c *Collector) TraceRequests() []*coltracepb.ExportTraceServiceRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*coltracepb.ExportTraceServiceRequest(nil), c.traces...)
+}
+
+// Headers returns the metadata of the requests received, metrics and
+// traces, in order.
+func (c *Collector) Headers() []metadata.MD {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]metadata.MD(nil), c.headers...)
+}
+
+// WaitForMetricsRequests waits until n metrics requests were received, for
+// exporters pushing in the background.
+func (c *Collector) WaitForMetricsRequests(ctx context.Context, n int) error {
+	return c.wait(ctx, func() bool { return len(c.metrics) >= n })
+}
+
+// WaitForTraceRequests waits until n trace requests were received.
+func (c *Collector) WaitForTraceRequests(ctx context.Context, n int) error {
+	return c.wait(ctx, func() bool { return len(c.traces) >= n })
+}
+
+// wait waits until done, which is called with the lock held, returns
+// true.
+func (c *Collector) wait(ctx context.Context, done func() bool) error {
+	for {
+		c.mu.Lock()
+		ok, received := done(), c.received
+		c.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("otlptest: %w", ctx.Err())
+		case <-received:
+		}
+	}
+}
+
+// record calls add with the lock held unless the collector is failing
+// requests.
+func (c *Collector) record(ctx context.Context, add func()) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	add()
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.headers = append(c.headers, md)
+	close(c.received)
+	c.received = make(chan struct{})
+	return nil
+}
+
+type metricsService struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	c *Collector
+}
+
+func (s metricsService) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	err := s.c.record(ctx, func() {
+		s.c.metrics = append(s.c.metrics, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+type traceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	c *Collector
+}
+
+func (s traceService) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	err := s.c.record(ctx, func() {
+		s.c.traces = append(s.c.traces, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
@@ -0,0 +1,167 @@
+package otlptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newCollector(t *testing.T) *Collector {
+	t.Helper()
+	c, err := NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	return c
+}
+
+// newExporter returns the OTLP exporter of the SDK sending to c.
+func newExporter(t *testing.T, c *Collector, opts ...otlpgrpc.Option) *otlp.Exporter {
+	t.Helper()
+	opts = append([]otlpgrpc.Option{otlpgrpc.WithInsecure(), otlpgrpc.WithEndpoint(c.Endpoint())}, opts...)
+	exp, err := otlp.NewExporter(context.Background(), otlpgrpc.NewDriver(opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = exp.Shutdown(context.Background()) })
+	return exp
+}
+
+func TestMetrics(t *testing.T) {
+	c := newCollector(t)
+	exp := newExporter(t, c, otlpgrpc.WithHeaders(map[string]string{"tenant": "a"}))
+	cont := controller.New(
+		processor.New(simple.NewWithHistogramDistribution([]float64{1, 10}), exp),
+		controller.WithPusher(exp),
+		controller.WithCollectPeriod(time.Hour),
+		controller.WithResource(resource.NewWithAttributes(label.String("service.name", "test"), label.Int("pid", 7))),
+	)
+	if err := cont.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	meter := metric.Must(cont.MeterProvider().Meter("otlptest"))
+	ctx := context.Background()
+	meter.NewInt64Counter("requests").Add(ctx, 3, label.String("path", "/"))
+	meter.NewFloat64UpDownCounter("inflight").Add(ctx, -1.5)
+	meter.NewFloat64ValueRecorder("latency").Record(ctx, 5, label.String("path", "/"))
+	// Stop pushes once more.
+	if err := cont.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := c.RequireMetric(t, "requests")
+	requests.AssertType(t, IntSum)
+	requests.AssertMonotonic(t, true)
+	requests.AssertResource(t, map[string]string{"service.name": "test", "pid": "7"})
+	if p := requests.AssertPoint(t, map[string]string{"path": "/"}); p.Value != 3 {
+		t.Errorf("got requests %v, want 3", p.Value)
+	}
+	if got := requests.Temporality(); got != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Errorf("got temporality %v", got)
+	}
+	if requests.Library.Name != "otlptest" {
+		t.Errorf("got library %v", requests.Library)
+	}
+
+	inflight := c.RequireMetric(t, "inflight")
+	inflight.AssertType(t, DoubleSum)
+	inflight.AssertMonotonic(t, false)
+	if p := inflight.AssertPoint(t, map[string]string{}); p.Value != -1.5 {
+		t.Errorf("got inflight %v, want -1.5", p.Value)
+	}
+
+	latency := c.RequireMetric(t, "latency")
+	latency.AssertType(t, DoubleHistogram)
+	p := latency.AssertPoint(t, map[string]string{"path": "/"})
+	if p.Count != 1 || p.Value != 5 || len(p.BucketCounts) != 3 || p.BucketCounts[1] != 1 {
+		t.Errorf("got latency %+v", p)
+	}
+
+	if _, ok := c.FindMetric("missing"); ok {
+		t.Error("found a metric never recorded")
+	}
+	if headers := c.Headers(); len(headers) == 0 || len(headers[0].Get("tenant")) != 1 || headers[0].Get("tenant")[0] != "a" {
+		t.Errorf("got headers %v", headers)
+	}
+	c.Reset()
+	if len(c.Metrics()) != 0 {
+		t.Error("got metrics after Reset")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	c := newCollector(t)
+	conn, err := grpc.Dial(c.Endpoint(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tp := tracing.NewTracerProvider(
+		tracing.WithResource(resource.NewWithAttributes(label.String("service.name", "test"))),
+		tracing.WithSpanProcessor(tracing.NewBatchSpanProcessor(tracing.NewOTLPExporter(conn))),
+	)
+	_, span := tp.Tracer("otlptest").Start(context.Background(), "op", trace.WithAttributes(label.Int("n", 1), label.Bool("ok", true)))
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.WaitForTraceRequests(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	s := c.RequireSpan(t, "op")
+	s.AssertAttributes(t, map[string]string{"n": "1", "ok": "true"})
+	s.AssertResource(t, map[string]string{"service.name": "test"})
+	if _, ok := c.FindSpan("missing"); ok {
+		t.Error("found a span never started")
+	}
+}
+
+func TestSetError(t *testing.T) {
+	c := newCollector(t)
+	conn, err := grpc.Dial(c.Endpoint(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	exp := tracing.NewOTLPExporter(conn)
+	spans := []*tracing.SpanData{{Name: "op"}}
+
+	c.SetError(status.Error(codes.Unavailable, "down"))
+	err = exp.ExportSpans(context.Background(), spans)
+	if status.Code(errors.Unwrap(err)) != codes.Unavailable {
+		t.Errorf("got error %v, want Unavailable", err)
+	}
+	c.SetError(nil)
+	if err := exp.ExportSpans(context.Background(), spans); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.TraceRequests()); n != 1 {
+		t.Errorf("got %d requests, want the one that succeeded", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForMetricsRequests(ctx, 1); err == nil {
+		t.Error("waited for metrics never sent")
+	}
+}
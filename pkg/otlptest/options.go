@@ -0,0 +1,52 @@
+package otlptest
+
+import "google.golang.org/grpc"
+
+// DefaultAddress is where the collector listens by default, a random port
+// of the loopback interface.
+const DefaultAddress = "127.0.0.1:0"
+
+// config contains the settings of the collector.
+type config struct {
+	address       string
+	serverOptions []grpc.ServerOption
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{address: DefaultAddress}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the collector.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithAddress sets the address the collector listens on, eg. to test a
+// client with a fixed endpoint.
+func WithAddress(address string) Option {
+	return addressOption(address)
+}
+
+// WithServerOptions adds options to the gRPC server, eg. interceptors or
+// credentials.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return serverOptions(opts)
+}
+
+type addressOption string
+
+func (o addressOption) Apply(c *config) {
+	c.address = string(o)
+}
+
+type serverOptions []grpc.ServerOption
+
+func (o serverOptions) Apply(c *config) {
+	c.serverOptions = append(c.serverOptions, o...)
+}
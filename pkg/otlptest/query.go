@@ -0,0 +1,397 @@
+package otlptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// DataType is the kind of data of an OTLP metric.
+type DataType string
+
+// Data types of the metrics.
+const (
+	IntGauge        DataType = "IntGauge"
+	DoubleGauge     DataType = "DoubleGauge"
+	IntSum          DataType = "IntSum"
+	DoubleSum       DataType = "DoubleSum"
+	IntHistogram    DataType = "IntHistogram"
+	DoubleHistogram DataType = "DoubleHistogram"
+	DoubleSummary   DataType = "DoubleSummary"
+)
+
+// Metric is a metric received, with the resource and the instrumentation
+// library it was sent with.
+type Metric struct {
+	Resource *resourcepb.Resource
+	Library  *commonpb.InstrumentationLibrary
+	*metricpb.Metric
+}
+
+// Point is a data point of a metric. Value is the value of sums and gauges
+// and the sum of histograms and summaries.
+type Point struct {
+	Labels         map[string]string
+	Value          float64
+	Count          uint64
+	BucketCounts   []uint64
+	ExplicitBounds []float64
+}
+
+// Metrics returns the metrics received, in order.
+func (c *Collector) Metrics() []Metric {
+	var metrics []Metric
+	for _, req := range c.MetricsRequests() {
+		for _, rm := range req.ResourceMetrics {
+			for _, ilm := range rm.InstrumentationLibraryMetrics {
+				for _, m := range ilm.Metrics {
+					metrics = append(metrics, Metric{Resource: rm.Resource, Library: ilm.InstrumentationLibrary, Metric: m})
+				}
+			}
+		}
+	}
+	return metrics
+}
+
+// FindMetric returns the last metric received with a name, which holds the
+// latest values of cumulative metrics.
+func (c *Collector) FindMetric(name string) (Metric, bool) {
+	metrics := c.Metrics()
+	for i := len(metrics) - 1; i >= 0; i-- {
+		if metrics[i].Name == name {
+			return metrics[i], true
+		}
+	}
+	return Metric{}, false
+}
+
+// RequireMetric returns the last metric received with a name, the test
+// fails now when there is none.
+func (c *Collector) RequireMetric(t testing.TB, name string) Metric {
+	t.Helper()
+	m, ok := c.FindMetric(name)
+	if !ok {
+		var names []string
+		for _, m := range c.Metrics() {
+			names = append(names, m.Name)
+		}
+		t.Fatalf("otlptest: no metric %q received, got %v", name, names)
+	}
+	return m
+}
+
+// Type returns the data type of the metric.
+func (m Metric) Type() DataType {
+	switch m.Data.(type) {
+	case *metricpb.Metric_IntGauge:
+		return IntGauge
+	case *metricpb.Metric_DoubleGauge:
+		return DoubleGauge
+	case *metricpb.Metric_IntSum:
+		return IntSum
+	case *metricpb.Metric_DoubleSum:
+		return DoubleSum
+	case *metricpb.Metric_IntHistogram:
+		return IntHistogram
+	case *metricpb.Metric_DoubleHistogram:
+		return DoubleHistogram
+	case *metricpb.Metric_DoubleSummary:
+		return DoubleSummary
+	}
+	return ""
+}
+
+// Monotonic tells whether the metric is a monotonic sum.
+func (m Metric) Monotonic() bool {
+	switch d := m.Data.(type) {
+	case *metricpb.Metric_IntSum:
+		return d.IntSum.IsMonotonic
+	case *metricpb.Metric_DoubleSum:
+		return d.DoubleSum.IsMonotonic
+	}
+	return false
+}
+
+// Temporality returns the aggregation temporality of sums and histograms,
+// unspecified for the other types.
+func (m Metric) Temporality() metricpb.AggregationTemporality {
+	switch d := m.Data.(type) {
+	case *metricpb.Metric_IntSum:
+		return d.IntSum.AggregationTemporality
+	case *metricpb.Metric_DoubleSum:
+		return d.DoubleSum.AggregationTemporality
+	case *metricpb.Metric_IntHistogram:
+		return d.IntHistogram.AggregationTemporality
+	case *metricpb.Metric_DoubleHistogram:
+		return d.DoubleHistogram.AggregationTemporality
+	}
+	return metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_UNSPECIFIED
+}
+
+// Points returns the data points of the metric.
+func (m Metric) Points() []Point {
+	var points []Point
+	switch d := m.Data.(type) {
+	case *metricpb.Metric_IntGauge:
+		points = intPoints(d.IntGauge.DataPoints)
+	case *metricpb.Metric_DoubleGauge:
+		points = doublePoints(d.DoubleGauge.DataPoints)
+	case *metricpb.Metric_IntSum:
+		points = intPoints(d.IntSum.DataPoints)
+	case *metricpb.Metric_DoubleSum:
+		points = doublePoints(d.DoubleSum.DataPoints)
+	case *metricpb.Metric_IntHistogram:
+		for _, p := range d.IntHistogram.DataPoints {
+			points = append(points, Point{
+				Labels:         Labels(p.Labels),
+				Value:          float64(p.Sum),
+				Count:          p.Count,
+				BucketCounts:   p.BucketCounts,
+				ExplicitBounds: p.ExplicitBounds,
+			})
+		}
+	case *metricpb.Metric_DoubleHistogram:
+		for _, p := range d.DoubleHistogram.DataPoints {
+			points = append(points, Point{
+				Labels:         Labels(p.Labels),
+				Value:          p.Sum,
+				Count:          p.Count,
+				BucketCounts:   p.BucketCounts,
+				ExplicitBounds: p.ExplicitBounds,
+			})
+		}
+	case *metricpb.Metric_DoubleSummary:
+		for _, p := range d.DoubleSummary.DataPoints {
+			points = append(points, Point{Labels: Labels(p.Labels), Value: p.Sum, Count: p.Count})
+		}
+	}
+	return points
+}
+
+func intPoints(dps []*metricpb.IntDataPoint) []Point {
+	points := make([]Point, 0, len(dps))
+	for _, p := range dps {
+		points = append(points, Point{Labels: Labels(p.Labels), Value: float64(p.Value)})
+	}
+	return points
+}
+
+func doublePoints(dps []*metricpb.DoubleDataPoint) []Point {
+	points := make([]Point, 0, len(dps))
+	for _, p := range dps {
+		points = append(points, Point{Labels: Labels(p.Labels), Value: p.Value})
+	}
+	return points
+}
+
+// FindPoint returns the data point with exactly the labels given.
+func (m Metric) FindPoint(labels map[string]string) (Point, bool) {
+	for _, p := range m.Points() {
+		if equal(p.Labels, labels) {
+			return p, true
+		}
+	}
+	return Point{}, false
+}
+
+// ResourceAttributes returns the attributes of the resource of the metric.
+func (m Metric) ResourceAttributes() map[string]string {
+	return resourceAttributes(m.Resource)
+}
+
+// AssertType fails the test when the metric is not of the data type.
+func (m Metric) AssertType(t testing.TB, want DataType) {
+	t.Helper()
+	if got := m.Type(); got != want {
+		t.Errorf("otlptest: metric %q is a %s, want %s", m.Name, got, want)
+	}
+}
+
+// AssertMonotonic fails the test when the metric is not, or is, a monotonic
+// sum.
+func (m Metric) AssertMonotonic(t testing.TB, want bool) {
+	t.Helper()
+	if got := m.Monotonic(); got != want {
+		t.Errorf("otlptest: metric %q monotonic is %t, want %t", m.Name, got, want)
+	}
+}
+
+// AssertPoint returns the data point with exactly the labels given, the
+// test fails when there is none.
+func (m Metric) AssertPoint(t testing.TB, labels map[string]string) Point {
+	t.Helper()
+	p, ok := m.FindPoint(labels)
+	if !ok {
+		var got []string
+		for _, p := range m.Points() {
+			got = append(got, format(p.Labels))
+		}
+		t.Errorf("otlptest: metric %q has no point with labels %s, got %v", m.Name, format(labels), got)
+	}
+	return p
+}
+
+// AssertResource fails the test when the resource of the metric does not
+// have the attributes given, it can have others.
+func (m Metric) AssertResource(t testing.TB, attrs map[string]string) {
+	t.Helper()
+	assertSubset(t, fmt.Sprintf("resource of metric %q", m.Name), m.ResourceAttributes(), attrs)
+}
+
+// Span is a span received, with the resource and the instrumentation
+// library it was sent with.
+type Span struct {
+	Resource *resourcepb.Resource
+	Library  *commonpb.InstrumentationLibrary
+	*tracepb.Span
+}
+
+// Spans returns the spans received, in order.
+func (c *Collector) Spans() []Span {
+	var spans []Span
+	for _, req := range c.TraceRequests() {
+		for _, rs := range req.ResourceSpans {
+			for _, ils := range rs.InstrumentationLibrarySpans {
+				for _, s := range ils.Spans {
+					spans = append(spans, Span{Resource: rs.Resource, Library: ils.InstrumentationLibrary, Span: s})
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// FindSpan returns the first span received with a name.
+func (c *Collector) FindSpan(name string) (Span, bool) {
+	for _, s := range c.Spans() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Span{}, false
+}
+
+// RequireSpan returns the first span received with a name, the test fails
+// now when there is none.
+func (c *Collector) RequireSpan(t testing.TB, name string) Span {
+	t.Helper()
+	s, ok := c.FindSpan(name)
+	if !ok {
+		var names []string
+		for _, s := range c.Spans() {
+			names = append(names, s.Name)
+		}
+		t.Fatalf("otlptest: no span %q received, got %v", name, names)
+	}
+	return s
+}
+
+// AttributeValues returns the attributes of the span formatted as strings.
+func (s Span) AttributeValues() map[string]string {
+	return Attributes(s.Attributes)
+}
+
+// ResourceAttributes returns the attributes of the resource of the span.
+func (s Span) ResourceAttributes() map[string]string {
+	return resourceAttributes(s.Resource)
+}
+
+// AssertAttributes fails the test when the span does not have the
+// attributes given, it can have others.
+func (s Span) AssertAttributes(t testing.TB, attrs map[string]string) {
+	t.Helper()
+	assertSubset(t, fmt.Sprintf("span %q", s.Name), s.AttributeValues(), attrs)
+}
+
+// AssertResource fails the test when the resource of the span does not have
+// the attributes given, it can have others.
+func (s Span) AssertResource(t testing.TB, attrs map[string]string) {
+	t.Helper()
+	assertSubset(t, fmt.Sprintf("resource of span %q", s.Name), s.ResourceAttributes(), attrs)
+}
+
+// Labels returns metric labels as a map.
+func Labels(kvs []*commonpb.StringKeyValue) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+// Attributes returns attributes as a map, the values formatted as strings.
+func Attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = formatValue(kv.Value)
+	}
+	return m
+}
+
+func resourceAttributes(r *resourcepb.Resource) map[string]string {
+	if r == nil {
+		return map[string]string{}
+	}
+	return Attributes(r.Attributes)
+}
+
+func formatValue(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_ArrayValue:
+		var values []string
+		for _, e := range v.ArrayValue.Values {
+			values = append(values, formatValue(e))
+		}
+		return "[" + strings.Join(values, ",") + "]"
+	}
+	return ""
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+func assertSubset(t testing.TB, what string, got, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Errorf("otlptest: %s has %s, want %s=%s", what, format(got), k, v)
+		}
+	}
+}
+
+// format formats labels sorted by key, eg. {a=1,b=2}.
+func format(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + labels[k]
+	}
+	return "{" + strings.Join(keys, ",") + "}"
+}
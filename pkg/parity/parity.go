@@ -0,0 +1,381 @@
+// Package parity compares the metrics an application exposes to Prometheus
+// with the ones it pushes over OTLP, so that the divergences between the
+// pull and the push paths are found by a test rather than by eyeballing
+// two dumps:
+//
+//	families, err := parity.ParsePrometheus(resp.Body)
+//	...
+//	report := parity.Compare(families, collector.Metrics())
+//	json.NewEncoder(os.Stdout).Encode(report)
+//
+// OTLP names and label keys are sanitized the way the Prometheus exporter
+// does before they are compared, eg. "test_app.alloc" is "test_app_alloc".
+package parity
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/skonto/test-otel/pkg/otlptest"
+)
+
+// Kind is the kind of a divergence.
+type Kind string
+
+// Kinds of divergences, in the order they are reported for a metric.
+const (
+	// Name is a metric exposed on one path only.
+	Name Kind = "name"
+	// Type is a metric whose types differ. A sum is a counter to
+	// Prometheus, so a non-monotonic sum exposed as a gauge is reported:
+	// that is how it shows up at the collector.
+	Type Kind = "type"
+	// Labels is a metric whose label keys differ, eg. the resource
+	// attributes the Prometheus exporter adds to every series.
+	Labels Kind = "labels"
+	// Series is a label set found on one path only, the labels are
+	// restricted to the keys both paths have.
+	Series Kind = "series"
+	// Value is a series whose values differ.
+	Value Kind = "value"
+)
+
+var kindOrder = map[Kind]int{Name: 0, Type: 1, Labels: 2, Series: 3, Value: 4}
+
+// Divergence is a difference between the two paths. Prometheus and OTLP
+// describe each side, an empty one means the side has nothing.
+type Divergence struct {
+	Kind       Kind              `json:"kind"`
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Prometheus string            `json:"prometheus,omitempty"`
+	OTLP       string            `json:"otlp,omitempty"`
+	// Resource lists the label keys only Prometheus has which are
+	// attributes of the OTLP resource.
+	Resource []string `json:"resource,omitempty"`
+}
+
+// Report lists the divergences found, sorted by metric and kind.
+type Report struct {
+	// Metrics is the number of metrics found on both paths.
+	Metrics     int          `json:"metrics"`
+	Divergences []Divergence `json:"divergences"`
+}
+
+// Equal tells whether the two paths agree.
+func (r Report) Equal() bool {
+	return len(r.Divergences) == 0
+}
+
+// ParsePrometheus parses metrics in the Prometheus text format.
+func ParsePrometheus(r io.Reader) (map[string]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("parity: %w", err)
+	}
+	return families, nil
+}
+
+// Compare compares the metric families scraped with the metrics pushed.
+// When a metric was pushed several times the last one is used, so that
+// cumulative metrics are compared with their latest values; scrape right
+// after a push for the values to match.
+func Compare(families map[string]*dto.MetricFamily, metrics []otlptest.Metric) Report {
+	pushed := map[string]otlptest.Metric{}
+	for _, m := range metrics {
+		pushed[sanitize(m.Name)] = m
+	}
+	names := map[string]bool{}
+	for name := range families {
+		names[name] = true
+	}
+	for name := range pushed {
+		names[name] = true
+	}
+
+	var r Report
+	for name := range names {
+		family, scraped := families[name]
+		m, ok := pushed[name]
+		switch {
+		case !ok:
+			r.add(Divergence{Kind: Name, Metric: name, Prometheus: promType(family)})
+		case !scraped:
+			r.add(Divergence{Kind: Name, Metric: name, OTLP: otlpType(m)})
+		default:
+			r.Metrics++
+			r.compare(name, family, m)
+		}
+	}
+	sort.SliceStable(r.Divergences, func(i, j int) bool {
+		a, b := r.Divergences[i], r.Divergences[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		if a.Kind != b.Kind {
+			return kindOrder[a.Kind] < kindOrder[b.Kind]
+		}
+		return format(a.Labels) < format(b.Labels)
+	})
+	return r
+}
+
+func (r *Report) add(d Divergence) {
+	r.Divergences = append(r.Divergences, d)
+}
+
+func (r *Report) compare(name string, family *dto.MetricFamily, m otlptest.Metric) {
+	pt, ot := promType(family), otlpType(m)
+	if pt != equivalentType(m) {
+		r.add(Divergence{Kind: Type, Metric: name, Prometheus: pt, OTLP: ot})
+	}
+
+	points := m.Points()
+	promKeys, otlpKeys := map[string]bool{}, map[string]bool{}
+	for _, s := range family.Metric {
+		for _, l := range s.Label {
+			promKeys[l.GetName()] = true
+		}
+	}
+	for i, p := range points {
+		points[i].Labels = sanitizeKeys(p.Labels)
+		for k := range points[i].Labels {
+			otlpKeys[k] = true
+		}
+	}
+	onlyProm, onlyOTLP := difference(promKeys, otlpKeys), difference(otlpKeys, promKeys)
+	if len(onlyProm) > 0 || len(onlyOTLP) > 0 {
+		r.add(Divergence{
+			Kind:       Labels,
+			Metric:     name,
+			Prometheus: strings.Join(onlyProm, ","),
+			OTLP:       strings.Join(onlyOTLP, ","),
+			Resource:   resourceKeys(m, onlyProm),
+		})
+	}
+
+	// Series are matched on the label keys both paths have.
+	scraped := map[string]sample{}
+	labels := map[string]map[string]string{}
+	for _, s := range family.Metric {
+		l := map[string]string{}
+		for _, p := range s.Label {
+			if otlpKeys[p.GetName()] {
+				l[p.GetName()] = p.GetValue()
+			}
+		}
+		key := format(l)
+		scraped[key], labels[key] = promSample(family.GetType(), s), l
+	}
+	pushed := map[string]sample{}
+	for _, p := range points {
+		l := map[string]string{}
+		for k, v := range p.Labels {
+			if promKeys[k] {
+				l[k] = v
+			}
+		}
+		key := format(l)
+		pushed[key], labels[key] = otlpSample(m, p), l
+	}
+	for key, l := range labels {
+		fromProm, inProm := scraped[key]
+		fromOTLP, inOTLP := pushed[key]
+		switch {
+		case !inOTLP:
+			r.add(Divergence{Kind: Series, Metric: name, Labels: l, Prometheus: fromProm.String()})
+		case !inProm:
+			r.add(Divergence{Kind: Series, Metric: name, Labels: l, OTLP: fromOTLP.String()})
+		case fromProm.shape == fromOTLP.shape && fromProm.String() != fromOTLP.String():
+			r.add(Divergence{Kind: Value, Metric: name, Labels: l, Prometheus: fromProm.String(), OTLP: fromOTLP.String()})
+		}
+	}
+}
+
+// promType returns the lower case Prometheus type, eg. "counter".
+func promType(family *dto.MetricFamily) string {
+	return strings.ToLower(family.GetType().String())
+}
+
+// otlpType returns the OTLP data type, with the monotonicity of sums.
+func otlpType(m otlptest.Metric) string {
+	t := string(m.Type())
+	switch m.Type() {
+	case otlptest.IntSum, otlptest.DoubleSum:
+		if m.Monotonic() {
+			return t + " (monotonic)"
+		}
+		return t + " (non-monotonic)"
+	}
+	return t
+}
+
+// equivalentType returns the Prometheus type of an OTLP metric.
+func equivalentType(m otlptest.Metric) string {
+	switch m.Type() {
+	case otlptest.IntGauge, otlptest.DoubleGauge:
+		return "gauge"
+	case otlptest.IntSum, otlptest.DoubleSum:
+		return "counter"
+	case otlptest.IntHistogram, otlptest.DoubleHistogram:
+		return "histogram"
+	case otlptest.DoubleSummary:
+		return "summary"
+	}
+	return ""
+}
+
+// shape tells which values of a sample are set.
+type shape int
+
+const (
+	scalar shape = iota
+	histogram
+	summary
+)
+
+// sample is a value of a series, comparable across the paths. Only the
+// finite bucket bounds are kept, with cumulative counts.
+type sample struct {
+	shape   shape
+	value   float64
+	count   uint64
+	buckets []bucket
+}
+
+type bucket struct {
+	bound float64
+	count uint64
+}
+
+func (s sample) String() string {
+	switch s.shape {
+	case histogram:
+		buckets := make([]string, 0, len(s.buckets))
+		for _, b := range s.buckets {
+			buckets = append(buckets, fmt.Sprintf("%s:%d", formatFloat(b.bound), b.count))
+		}
+		return fmt.Sprintf("count=%d sum=%s buckets=[%s]", s.count, formatFloat(s.value), strings.Join(buckets, " "))
+	case summary:
+		return fmt.Sprintf("count=%d sum=%s", s.count, formatFloat(s.value))
+	}
+	return formatFloat(s.value)
+}
+
+func promSample(t dto.MetricType, m *dto.Metric) sample {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return sample{value: m.GetCounter().GetValue()}
+	case dto.MetricType_GAUGE:
+		return sample{value: m.GetGauge().GetValue()}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		s := sample{shape: histogram, value: h.GetSampleSum(), count: h.GetSampleCount()}
+		for _, b := range h.Bucket {
+			if !math.IsInf(b.GetUpperBound(), 1) {
+				s.buckets = append(s.buckets, bucket{bound: b.GetUpperBound(), count: b.GetCumulativeCount()})
+			}
+		}
+		return s
+	case dto.MetricType_SUMMARY:
+		return sample{shape: summary, value: m.GetSummary().GetSampleSum(), count: m.GetSummary().GetSampleCount()}
+	}
+	return sample{value: m.GetUntyped().GetValue()}
+}
+
+func otlpSample(m otlptest.Metric, p otlptest.Point) sample {
+	switch m.Type() {
+	case otlptest.IntHistogram, otlptest.DoubleHistogram:
+		s := sample{shape: histogram, value: p.Value, count: p.Count}
+		var cumulative uint64
+		for i, bound := range p.ExplicitBounds {
+			if i < len(p.BucketCounts) {
+				cumulative += p.BucketCounts[i]
+			}
+			s.buckets = append(s.buckets, bucket{bound: bound, count: cumulative})
+		}
+		return s
+	case otlptest.DoubleSummary:
+		return sample{shape: summary, value: p.Value, count: p.Count}
+	}
+	return sample{value: p.Value}
+}
+
+// resourceKeys returns the keys which are attributes of the resource of m.
+func resourceKeys(m otlptest.Metric, keys []string) []string {
+	attrs := sanitizeKeys(m.ResourceAttributes())
+	var found []string
+	for _, k := range keys {
+		if _, ok := attrs[k]; ok {
+			found = append(found, k)
+		}
+	}
+	return found
+}
+
+// difference returns the sorted keys of a missing from b.
+func difference(a, b map[string]bool) []string {
+	var keys []string
+	for k := range a {
+		if !b[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sanitizeKeys(labels map[string]string) map[string]string {
+	sanitized := make(map[string]string, len(labels))
+	for k, v := range labels {
+		sanitized[sanitize(k)] = v
+	}
+	return sanitized
+}
+
+// sanitize replaces the characters Prometheus does not allow in names as
+// the Prometheus exporter does.
+func sanitize(s string) string {
+	if s == "" {
+		return s
+	}
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+	if unicode.IsDigit(rune(s[0])) {
+		s = "key_" + s
+	}
+	if s[0] == '_' {
+		s = "key" + s
+	}
+	return s
+}
+
+// format returns labels as {k1="v1",k2="v2"} sorted by key.
+func format(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,145 @@
+package parity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/otlptest"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const scraped = `# TYPE requests counter
+requests{path="/",service_name="app"} 3
+requests{path="/a",service_name="app"} 1
+# TYPE inflight gauge
+inflight{service_name="app"} 2
+# TYPE latency histogram
+latency_bucket{service_name="app",le="1"} 1
+latency_bucket{service_name="app",le="10"} 2
+latency_bucket{service_name="app",le="+Inf"} 3
+latency_sum{service_name="app"} 20.5
+latency_count{service_name="app"} 3
+# TYPE pull_only gauge
+pull_only 1
+`
+
+var resource = &resourcepb.Resource{
+	Attributes: []*commonpb.KeyValue{{
+		Key:   "service.name",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "app"}},
+	}},
+}
+
+func metric(name string, data interface{}) otlptest.Metric {
+	m := &metricpb.Metric{Name: name}
+	switch d := data.(type) {
+	case *metricpb.IntSum:
+		m.Data = &metricpb.Metric_IntSum{IntSum: d}
+	case *metricpb.DoubleHistogram:
+		m.Data = &metricpb.Metric_DoubleHistogram{DoubleHistogram: d}
+	case *metricpb.IntGauge:
+		m.Data = &metricpb.Metric_IntGauge{IntGauge: d}
+	}
+	return otlptest.Metric{Resource: resource, Metric: m}
+}
+
+func labels(kvs ...string) []*commonpb.StringKeyValue {
+	var l []*commonpb.StringKeyValue
+	for i := 0; i < len(kvs); i += 2 {
+		l = append(l, &commonpb.StringKeyValue{Key: kvs[i], Value: kvs[i+1]})
+	}
+	return l
+}
+
+func TestCompare(t *testing.T) {
+	families, err := ParsePrometheus(strings.NewReader(scraped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed := []otlptest.Metric{
+		// Overridden by the later push.
+		metric("requests", &metricpb.IntSum{IsMonotonic: true, DataPoints: []*metricpb.IntDataPoint{
+			{Labels: labels("path", "/"), Value: 1},
+		}}),
+		metric("requests", &metricpb.IntSum{IsMonotonic: true, DataPoints: []*metricpb.IntDataPoint{
+			{Labels: labels("path", "/"), Value: 3},
+			{Labels: labels("path", "/b"), Value: 1},
+		}}),
+		metric("inflight", &metricpb.IntSum{DataPoints: []*metricpb.IntDataPoint{
+			{Value: 1},
+		}}),
+		metric("latency", &metricpb.DoubleHistogram{DataPoints: []*metricpb.DoubleHistogramDataPoint{
+			{Count: 3, Sum: 20.5, BucketCounts: []uint64{1, 1, 1}, ExplicitBounds: []float64{1, 10}},
+		}}),
+		metric("push.only", &metricpb.IntGauge{}),
+	}
+
+	got := Compare(families, pushed)
+	want := Report{Metrics: 3, Divergences: []Divergence{
+		{Kind: Type, Metric: "inflight", Prometheus: "gauge", OTLP: "IntSum (non-monotonic)"},
+		{Kind: Labels, Metric: "inflight", Prometheus: "service_name", Resource: []string{"service_name"}},
+		{Kind: Value, Metric: "inflight", Labels: map[string]string{}, Prometheus: "2", OTLP: "1"},
+		{Kind: Labels, Metric: "latency", Prometheus: "service_name", Resource: []string{"service_name"}},
+		{Kind: Name, Metric: "pull_only", Prometheus: "gauge"},
+		{Kind: Name, Metric: "push_only", OTLP: "IntGauge"},
+		{Kind: Labels, Metric: "requests", Prometheus: "service_name", Resource: []string{"service_name"}},
+		{Kind: Series, Metric: "requests", Labels: map[string]string{"path": "/a"}, Prometheus: "1"},
+		{Kind: Series, Metric: "requests", Labels: map[string]string{"path": "/b"}, OTLP: "1"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+	if got.Equal() {
+		t.Error("report with divergences is equal")
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	families, err := ParsePrometheus(strings.NewReader(`# TYPE latency histogram
+latency_bucket{le="1"} 1
+latency_bucket{le="10"} 1
+latency_bucket{le="+Inf"} 2
+latency_sum 20
+latency_count 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metric("latency", &metricpb.DoubleHistogram{DataPoints: []*metricpb.DoubleHistogramDataPoint{
+		{Count: 2, Sum: 20, BucketCounts: []uint64{0, 1, 1}, ExplicitBounds: []float64{1, 10}},
+	}})
+	m.Resource = nil
+	got := Compare(families, []otlptest.Metric{m})
+	want := []Divergence{{
+		Kind:       Value,
+		Metric:     "latency",
+		Labels:     map[string]string{},
+		Prometheus: "count=2 sum=20 buckets=[1:1 10:1]",
+		OTLP:       "count=2 sum=20 buckets=[1:0 10:1]",
+	}}
+	if !reflect.DeepEqual(got.Divergences, want) {
+		t.Errorf("got %+v, want %+v", got.Divergences, want)
+	}
+}
+
+func TestParsePrometheusError(t *testing.T) {
+	if _, err := ParsePrometheus(strings.NewReader("# TYPE x counter\nx{ 1\n")); err == nil || !strings.HasPrefix(err.Error(), "parity: ") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for in, want := range map[string]string{
+		"test_app.go.alloc": "test_app_go_alloc",
+		"0ratio":            "key_0ratio",
+		"_hidden":           "key_hidden",
+		"service.name":      "service_name",
+	} {
+		if got := sanitize(in); got != want {
+			t.Errorf("sanitize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package promserver
+
+import (
+	"crypto/tls"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	AddressEnv           = "PROMETHEUS_ADDRESS"
+	PathEnv              = "PROMETHEUS_PATH"
+	TLSCertFileEnv       = "PROMETHEUS_TLS_CERT_FILE"
+	TLSKeyFileEnv        = "PROMETHEUS_TLS_KEY_FILE"
+	BasicAuthUserEnv     = "PROMETHEUS_BASIC_AUTH_USER"
+	BasicAuthPasswordEnv = "PROMETHEUS_BASIC_AUTH_PASSWORD"
+)
+
+// config contains the settings of the server.
+type config struct {
+	address           string
+	path              string
+	certFile, keyFile string
+	tlsConfig         *tls.Config
+	username          string
+	password          string
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	meterProvider     metric.MeterProvider
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		address:           DefaultAddress,
+		path:              DefaultPath,
+		readHeaderTimeout: DefaultReadTimeout,
+		readTimeout:       DefaultReadTimeout,
+		writeTimeout:      DefaultWriteTimeout,
+		idleTimeout:       DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the server.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// OptionsFromEnv returns the options set through the PROMETHEUS_*
+// environment variables. Append them after the defaults of a binary so
+// that the environment takes precedence.
+func OptionsFromEnv() []Option {
+	var opts []Option
+	if addr := os.Getenv(AddressEnv); addr != "" {
+		opts = append(opts, WithAddress(addr))
+	}
+	if path := os.Getenv(PathEnv); path != "" {
+		opts = append(opts, WithPath(path))
+	}
+	if cert, key := os.Getenv(TLSCertFileEnv), os.Getenv(TLSKeyFileEnv); cert != "" || key != "" {
+		opts = append(opts, WithTLSFiles(cert, key))
+	}
+	if user := os.Getenv(BasicAuthUserEnv); user != "" {
+		opts = append(opts, WithBasicAuth(user, os.Getenv(BasicAuthPasswordEnv)))
+	}
+	return opts
+}
+
+// WithAddress sets the address to listen on, DefaultAddress by default.
+func WithAddress(addr string) Option {
+	return addressOption(addr)
+}
+
+// WithPath sets the URL path metrics are served on, DefaultPath by default.
+// It must start with a slash, requests to other paths, including those
+// under a path ending with a slash, get a 404.
+func WithPath(path string) Option {
+	return pathOption(path)
+}
+
+// WithTLSFiles serves HTTPS with the certificate and key in the given PEM
+// files. Both must be set.
+func WithTLSFiles(certFile, keyFile string) Option {
+	return tlsFilesOption{certFile: certFile, keyFile: keyFile}
+}
+
+// WithTLSConfig serves HTTPS with the given configuration, eg. to require
+// client certificates. It is combined with WithTLSFiles when both are set.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return tlsConfigOption{cfg}
+}
+
+// WithBasicAuth requires scrapes to authenticate with the given credentials.
+func WithBasicAuth(username, password string) Option {
+	return basicAuthOption{username: username, password: password}
+}
+
+// WithTimeouts sets the time allowed to read a request and to write a
+// response. Non positive values are ignored.
+func WithTimeouts(read, write time.Duration) Option {
+	return timeoutsOption{read: read, write: write}
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the scrape
+// duration. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+type addressOption string
+
+type pathOption string
+
+type tlsFilesOption struct {
+	certFile string
+	keyFile  string
+}
+
+type tlsConfigOption struct{ *tls.Config }
+
+type basicAuthOption struct {
+	username string
+	password string
+}
+
+type timeoutsOption struct {
+	read  time.Duration
+	write time.Duration
+}
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// Apply implements Option.
+func (o addressOption) Apply(c *config) {
+	c.address = string(o)
+}
+
+func (o pathOption) Apply(c *config) {
+	if o != "" {
+		c.path = string(o)
+	}
+}
+
+func (o tlsFilesOption) Apply(c *config) {
+	c.certFile = o.certFile
+	c.keyFile = o.keyFile
+}
+
+func (o tlsConfigOption) Apply(c *config) {
+	c.tlsConfig = o.Config
+}
+
+func (o basicAuthOption) Apply(c *config) {
+	c.username = o.username
+	c.password = o.password
+}
+
+func (o timeoutsOption) Apply(c *config) {
+	if o.read > 0 {
+		c.readHeaderTimeout = o.read
+		c.readTimeout = o.read
+	}
+	if o.write > 0 {
+		c.writeTimeout = o.write
+	}
+}
+
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
@@ -0,0 +1,228 @@
+// Package promserver serves a Prometheus exporter over HTTP on its own
+// server, instead of the default mux, with configurable address and path,
+// optional TLS and basic auth, request timeouts and a self-metric of the
+// scrape duration. Listen errors are returned by Start rather than lost in a
+// goroutine.
+package promserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/unit"
+)
+
+const (
+	// DefaultAddress is the address the server listens on by default.
+	DefaultAddress = ":9090"
+	// DefaultPath is the URL path metrics are served on by default.
+	DefaultPath = "/metrics"
+	// DefaultReadTimeout is the default time allowed to read a request.
+	DefaultReadTimeout = 5 * time.Second
+	// DefaultWriteTimeout is the default time allowed to write a response.
+	DefaultWriteTimeout = 10 * time.Second
+	// DefaultIdleTimeout is how long keep-alive connections are kept.
+	DefaultIdleTimeout = time.Minute
+)
+
+// ErrAlreadyStarted is returned when Start is called more than once.
+var ErrAlreadyStarted = errors.New("promserver: already started")
+
+// Server serves a metrics handler, usually a *prometheus.Exporter.
+type Server struct {
+	cfg   config
+	srv   *http.Server
+	errCh chan error
+
+	mu       sync.Mutex
+	listener net.Listener
+
+	scrapeDuration metric.Float64ValueRecorder
+}
+
+// New creates a server for handler. It does not listen until Start.
+func New(handler http.Handler, opts ...Option) (*Server, error) {
+	s := &Server{cfg: newConfig(opts...), errCh: make(chan error, 1)}
+	if (s.cfg.certFile == "") != (s.cfg.keyFile == "") {
+		return nil, errors.New("promserver: both a TLS certificate and key file are required")
+	}
+	// Request paths start with a slash, a path without one never matches.
+	if !strings.HasPrefix(s.cfg.path, "/") {
+		return nil, fmt.Errorf("promserver: path %q does not start with /", s.cfg.path)
+	}
+	if s.cfg.meterProvider != nil {
+		var err error
+		s.scrapeDuration, err = s.cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/promserver").NewFloat64ValueRecorder(
+			"prometheus.scrape.duration",
+			metric.WithDescription("The time taken to serve a scrape"),
+			metric.WithUnit(unit.Milliseconds),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.srv = &http.Server{
+		Handler:           s.exactPath(s.instrument(s.authenticate(handler))),
+		ReadHeaderTimeout: s.cfg.readHeaderTimeout,
+		ReadTimeout:       s.cfg.readTimeout,
+		WriteTimeout:      s.cfg.writeTimeout,
+		IdleTimeout:       s.cfg.idleTimeout,
+	}
+	return s, nil
+}
+
+// Start listens on the configured address and serves in the background. It
+// returns the errors that prevent serving, eg. the address being in use or
+// an invalid certificate. Errors that stop the server later are delivered
+// on Err.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return ErrAlreadyStarted
+	}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", s.cfg.address)
+	if err != nil {
+		return fmt.Errorf("promserver: %w", err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	s.listener = ln
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.errCh <- fmt.Errorf("promserver: %w", err)
+		}
+		close(s.errCh)
+	}()
+	return nil
+}
+
+// Err returns a channel that receives the error that stopped the server, if
+// any, and is closed once the server stopped.
+func (s *Server) Err() <-chan error {
+	return s.errCh
+}
+
+// Addr returns the address the server listens on, useful when the
+// configured port is 0. It is empty before Start.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr()
+}
+
+func (s *Server) addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// URL returns the URL metrics are served on. It is empty before Start.
+func (s *Server) URL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	scheme := "http"
+	if s.cfg.certFile != "" || s.cfg.tlsConfig != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, s.addr(), s.cfg.path)
+}
+
+// exactPath serves the configured path only, unlike ServeMux which serves
+// the whole subtree of a path ending with a slash.
+func (s *Server) exactPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != s.cfg.path {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shutdown stops the server, waiting for in flight scrapes to finish.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.cfg.certFile == "" && s.cfg.tlsConfig == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.cfg.tlsConfig != nil {
+		cfg = s.cfg.tlsConfig.Clone()
+	}
+	if s.cfg.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.certFile, s.cfg.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("promserver: %w", err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	return cfg, nil
+}
+
+// authenticate checks the basic auth credentials when they are configured.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.cfg.username == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.cfg.username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.cfg.password)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrument records prometheus.scrape.duration{code}.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	if s.cfg.meterProvider == nil {
+		return next
+	}
+	codeKey := label.Key("code")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+		s.scrapeDuration.Record(r.Context(), elapsed, codeKey.String(strconv.Itoa(sw.status)))
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,290 @@
+package promserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// newExporter returns a Prometheus exporter with a counter and the meter
+// provider of its pipeline.
+func newExporter(t *testing.T) (*prometheus.Exporter, metric.MeterProvider) {
+	t.Helper()
+	p := metrictest.NewPipeline(metrictest.WithHistogramDistribution(nil))
+	exp, err := prometheus.NewExporter(prometheus.Config{}, p.Controller)
+	if err != nil {
+		t.Fatal("failed to create exporter:", err)
+	}
+	metric.Must(p.Meter()).NewInt64Counter("request.count").Add(context.Background(), 1)
+	return exp, p.MeterProvider()
+}
+
+func startServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	exp, mp := newExporter(t)
+	opts = append([]Option{WithAddress("127.0.0.1:0"), WithMeterProvider(mp)}, opts...)
+	s, err := New(exp, opts...)
+	if err != nil {
+		t.Fatal("failed to create server:", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal("failed to start server:", err)
+	}
+	return s
+}
+
+func scrape(t *testing.T, client *http.Client, url string, auth ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth) == 2 {
+		req.SetBasicAuth(auth[0], auth[1])
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal("scrape failed:", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestServe(t *testing.T) {
+	s := startServer(t, WithPath("/custom"))
+	defer s.Shutdown(context.Background())
+
+	code, body := scrape(t, http.DefaultClient, s.URL())
+	if code != http.StatusOK || !strings.Contains(body, "request_count 1") {
+		t.Fatalf("got %d %q, want the counter", code, body)
+	}
+	// The first scrape is recorded and shows up in the next one.
+	if _, body := scrape(t, http.DefaultClient, s.URL()); !strings.Contains(body, `prometheus_scrape_duration_count{code="200"} 1`) {
+		t.Errorf("scrape duration is missing:\n%s", body)
+	}
+	if code, _ := scrape(t, http.DefaultClient, "http://"+s.Addr()+"/metrics"); code != http.StatusNotFound {
+		t.Errorf("got %d on another path, want 404", code)
+	}
+}
+
+func TestServeRoot(t *testing.T) {
+	s := startServer(t, WithPath("/"))
+	defer s.Shutdown(context.Background())
+
+	if code, _ := scrape(t, http.DefaultClient, s.URL()); code != http.StatusOK {
+		t.Errorf("got %d on the root, want 200", code)
+	}
+	if code, _ := scrape(t, http.DefaultClient, s.URL()+"metrics"); code != http.StatusNotFound {
+		t.Errorf("got %d under the root, want 404", code)
+	}
+}
+
+func TestConcurrentStart(t *testing.T) {
+	exp, _ := newExporter(t)
+	s, err := New(exp, WithAddress("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal("failed to create server:", err)
+	}
+	defer s.Shutdown(context.Background())
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_ = s.URL()
+			errs <- s.Start()
+		}()
+	}
+	started := 0
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			started++
+		} else if err != ErrAlreadyStarted {
+			t.Error(err)
+		}
+	}
+	if started != 1 || s.Addr() == "" {
+		t.Errorf("started %d times on %q, want once", started, s.Addr())
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	s := startServer(t, WithBasicAuth("prometheus", "secret"))
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		name string
+		auth []string
+		want int
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "wrong password", auth: []string{"prometheus", "guess"}, want: http.StatusUnauthorized},
+		{name: "valid", auth: []string{"prometheus", "secret"}, want: http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if code, _ := scrape(t, http.DefaultClient, s.URL(), tc.auth...); code != tc.want {
+				t.Errorf("got status %d, want %d", code, tc.want)
+			}
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, pool := writeCertificate(t, dir)
+
+	s := startServer(t, WithTLSFiles(certFile, keyFile))
+	defer s.Shutdown(context.Background())
+	if !strings.HasPrefix(s.URL(), "https://") {
+		t.Fatalf("got URL %s, want https", s.URL())
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if code, _ := scrape(t, client, s.URL()); code != http.StatusOK {
+		t.Errorf("got status %d over TLS, want 200", code)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	exp, _ := newExporter(t)
+
+	s, err := New(exp, WithAddress(ln.Addr().String()))
+	if err != nil {
+		t.Fatal("failed to create server:", err)
+	}
+	if err := s.Start(); err == nil {
+		t.Error("expected an error when the address is in use")
+	}
+
+	s, err = New(exp, WithAddress("127.0.0.1:0"), WithTLSFiles("missing.crt", "missing.key"))
+	if err != nil {
+		t.Fatal("failed to create server:", err)
+	}
+	if err := s.Start(); err == nil {
+		t.Error("expected an error for missing certificate files")
+	}
+
+	if _, err := New(exp, WithTLSFiles("only.crt", "")); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+}
+
+func TestInvalidPath(t *testing.T) {
+	exp, _ := newExporter(t)
+	for _, path := range []string{"metrics", "metrics/"} {
+		if _, err := New(exp, WithPath(path)); err == nil || !strings.HasPrefix(err.Error(), "promserver: ") {
+			t.Errorf("path %q: got error %v", path, err)
+		}
+	}
+}
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and
+// returns its files and a pool trusting it.
+func writeCertificate(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "promserver test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+// BenchmarkRender measures rendering the Prometheus text format as the
+// number of series grows. The pipeline collects on the first scrape only,
+// scrapes then render the same checkpoint.
+func BenchmarkRender(b *testing.B) {
+	for _, labelSets := range []int{10, 100, 1000, 10000} {
+		b.Run(strconv.Itoa(labelSets), func(b *testing.B) {
+			p := metrictest.NewPipeline(
+				metrictest.WithHistogramDistribution([]float64{1, 5, 10, 50, 100}),
+				metrictest.WithCollectPeriod(time.Hour),
+			)
+			exp, err := prometheus.NewExporter(prometheus.Config{}, p.Controller)
+			if err != nil {
+				b.Fatal("failed to create exporter:", err)
+			}
+			meter := metric.Must(p.Meter())
+			counter := meter.NewInt64Counter("request.count")
+			latency := meter.NewFloat64ValueRecorder("request.latency")
+			ctx := context.Background()
+			for i := 0; i < labelSets; i++ {
+				labels := []label.KeyValue{label.String("path", "/api/items/"+strconv.Itoa(i)), label.String("host", "localhost")}
+				counter.Add(ctx, 1, labels...)
+				latency.Record(ctx, float64(i%100), labels...)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			exp.ServeHTTP(httptest.NewRecorder(), req)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				w := httptest.NewRecorder()
+				exp.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					b.Fatalf("got status %d", w.Code)
+				}
+			}
+		})
+	}
+}
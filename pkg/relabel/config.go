@@ -0,0 +1,57 @@
+package relabel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Rule actions accepted in a Config.
+const (
+	// Drop removes the label Key.
+	Drop = "drop"
+	// Rename moves the value of the label Key to the label To, replacing
+	// the value To had if any.
+	Rename = "rename"
+	// Replace rewrites the values of the label Key matching Match with
+	// Replacement.
+	Replace = "replace"
+)
+
+// Config describes how labels are rewritten before export.
+//
+// Every rule matching an instrument is applied, in order, on the labels
+// left by the rules before it.
+type Config struct {
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Rule rewrites one label of the instruments matching its name pattern.
+type Rule struct {
+	// Name is a path.Match pattern, eg. "http.server.*". Empty matches
+	// every name.
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	// To is the new key of Rename rules.
+	To string `json:"to,omitempty"`
+	// Match is a regular expression matched against the whole value of
+	// Replace rules, eg. "/api/list/[^/]+".
+	Match string `json:"match,omitempty"`
+	// Replacement of the matching values, it can refer to the groups of
+	// Match as in regexp.Regexp.Expand, eg. "/api/$1/:id".
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// LoadConfig reads a JSON encoded Config from a file.
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, fmt.Errorf("relabel: %w", err)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("relabel: %s: %w", filename, err)
+	}
+	return cfg, nil
+}
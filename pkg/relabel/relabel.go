@@ -0,0 +1,153 @@
+// Package relabel drops, renames and rewrites labels before export, eg. to
+// remove noisy labels or to match the label keys existing dashboards use.
+//
+// The Relabeler wraps the processor of a pipeline and rewrites the labels of
+// every accumulation before passing it on. When rules make several label
+// sets of an instrument equal, the processor merges their aggregations into
+// a single series, as it does for accumulations of the same label set.
+package relabel
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"go.opentelemetry.io/otel/label"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+type rule struct {
+	name        string
+	action      string
+	key         label.Key
+	to          label.Key
+	match       *regexp.Regexp
+	replacement string
+}
+
+// Relabeler is an export.Checkpointer applying label rules to the
+// accumulations it passes to the wrapped Checkpointer.
+type Relabeler struct {
+	export.Checkpointer
+	rules []rule
+}
+
+var _ export.Checkpointer = (*Relabeler)(nil)
+
+// New validates cfg and returns a Relabeler applying it in front of next,
+// usually a processor from the SDK's processor/basic package.
+func New(next export.Checkpointer, cfg Config) (*Relabeler, error) {
+	r := &Relabeler{Checkpointer: next}
+	for i, cr := range cfg.Rules {
+		if _, err := path.Match(cr.Name, ""); err != nil {
+			return nil, fmt.Errorf("relabel: rule %d: invalid name pattern %q: %w", i, cr.Name, err)
+		}
+		if cr.Key == "" {
+			return nil, fmt.Errorf("relabel: rule %d: missing key", i)
+		}
+		compiled := rule{name: cr.Name, action: cr.Action, key: label.Key(cr.Key)}
+		switch cr.Action {
+		case Drop:
+		case Rename:
+			if cr.To == "" || cr.To == cr.Key {
+				return nil, fmt.Errorf("relabel: rule %d: missing new key of %s", i, cr.Key)
+			}
+			compiled.to = label.Key(cr.To)
+		case Replace:
+			re, err := regexp.Compile("^(?:" + cr.Match + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("relabel: rule %d: %w", i, err)
+			}
+			compiled.match = re
+			compiled.replacement = cr.Replacement
+		default:
+			return nil, fmt.Errorf("relabel: rule %d: unknown action %q", i, cr.Action)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Process implements export.Processor.
+func (r *Relabeler) Process(accum export.Accumulation) error {
+	desc := accum.Descriptor()
+	var kvs []label.KeyValue
+	changed := false
+	for _, rl := range r.rules {
+		if rl.name != "" {
+			// Patterns are validated by New.
+			if ok, _ := path.Match(rl.name, desc.Name()); !ok {
+				continue
+			}
+		}
+		if kvs == nil {
+			kvs = accum.Labels().ToSlice()
+		}
+		if rl.apply(kvs) {
+			changed = true
+		}
+	}
+	if !changed {
+		return r.Checkpointer.Process(accum)
+	}
+	labels := label.NewSet(dropped(kvs)...)
+	return r.Checkpointer.Process(export.NewAccumulation(desc, &labels, accum.Resource(), accum.Aggregator()))
+}
+
+// apply rewrites kvs in place and reports whether it changed. Dropped
+// labels get an empty key and are removed at the end.
+func (rl rule) apply(kvs []label.KeyValue) bool {
+	changed := false
+	if rl.action == Rename {
+		// The renamed label replaces the label To, if both exist.
+		if index(kvs, rl.key) < 0 {
+			return false
+		}
+		if i := index(kvs, rl.to); i >= 0 {
+			kvs[i].Key = ""
+		}
+	}
+	for i, kv := range kvs {
+		if kv.Key != rl.key {
+			continue
+		}
+		switch rl.action {
+		case Drop:
+			kvs[i].Key = ""
+		case Rename:
+			kvs[i].Key = rl.to
+		case Replace:
+			value := kv.Value.Emit()
+			match := rl.match.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			replaced := string(rl.match.ExpandString(nil, rl.replacement, value, match))
+			if replaced == value && kv.Value.Type() == label.STRING {
+				continue
+			}
+			kvs[i].Value = label.StringValue(replaced)
+		}
+		changed = true
+	}
+	return changed
+}
+
+func index(kvs []label.KeyValue, key label.Key) int {
+	for i, kv := range kvs {
+		if kv.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func dropped(kvs []label.KeyValue) []label.KeyValue {
+	kept := kvs[:0]
+	for _, kv := range kvs {
+		if kv.Key != "" {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
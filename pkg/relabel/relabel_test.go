@@ -0,0 +1,126 @@
+package relabel
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+)
+
+var testConfig = Config{
+	Rules: []Rule{
+		{Action: Drop, Key: "host"},
+		{Name: "request.*", Action: Replace, Key: "path", Match: `/api/list/[^/]+`, Replacement: "/api/list/:name"},
+		{Name: "request.*", Action: Replace, Key: "path", Match: `/api/(\w+)/\d+`, Replacement: "/api/$1/:id"},
+		{Action: Rename, Key: "path", To: "route"},
+		{Action: Replace, Key: "code", Match: `2\d\d`, Replacement: "2xx"},
+	},
+}
+
+func collect(t *testing.T, cfg Config, instrument func(metric.Meter)) map[string]float64 {
+	p := metrictest.NewPipeline(metrictest.WithWrap(func(next export.Checkpointer) export.Checkpointer {
+		r, err := New(next, cfg)
+		if err != nil {
+			t.Fatal("invalid config:", err)
+		}
+		return r
+	}))
+	instrument(p.Meter())
+	return p.Values(t)
+}
+
+func TestRelabel(t *testing.T) {
+	got := collect(t, testConfig, func(meter metric.Meter) {
+		ctx := context.Background()
+		requests := metric.Must(meter).NewInt64Counter("request.count")
+		latency := metric.Must(meter).NewFloat64ValueRecorder("request.latency")
+		other := metric.Must(meter).NewInt64Counter("other.count")
+		for i, path := range []string{"/api/list/foo", "/api/list/bar", "/api/users/1", "/api/users/2", "/healthz"} {
+			for _, host := range []string{"a", "b"} {
+				labels := []label.KeyValue{label.String("path", path), label.String("host", host), label.Int("code", 200+i)}
+				requests.Add(ctx, 1, labels...)
+				latency.Record(ctx, float64(i), labels...)
+				other.Add(ctx, 1, labels...)
+			}
+		}
+	})
+	want := map[string]float64{
+		// Colliding label sets are merged.
+		"request.count{code=2xx,route=/api/list/:name}":         4,
+		"request.count{code=2xx,route=/api/users/:id}":          4,
+		"request.count{code=2xx,route=/healthz}":                2,
+		"request.latency{code=2xx,route=/api/list/:name}":       0 + 0 + 1 + 1,
+		"request.latency{code=2xx,route=/api/list/:name}.count": 4,
+		"request.latency{code=2xx,route=/api/users/:id}":        2 + 2 + 3 + 3,
+		"request.latency{code=2xx,route=/api/users/:id}.count":  4,
+		"request.latency{code=2xx,route=/healthz}":              4 + 4,
+		"request.latency{code=2xx,route=/healthz}.count":        2,
+		// Path values are only rewritten for request.* instruments.
+		"other.count{code=2xx,route=/api/list/foo}": 2,
+		"other.count{code=2xx,route=/api/list/bar}": 2,
+		"other.count{code=2xx,route=/api/users/1}":  2,
+		"other.count{code=2xx,route=/api/users/2}":  2,
+		"other.count{code=2xx,route=/healthz}":      2,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+func TestRenameOntoExisting(t *testing.T) {
+	cfg := Config{Rules: []Rule{{Action: Rename, Key: "path", To: "route"}}}
+	got := collect(t, cfg, func(meter metric.Meter) {
+		metric.Must(meter).NewInt64Counter("request.count").Add(context.Background(), 1,
+			label.String("path", "/a"), label.String("route", "/b"))
+	})
+	want := map[string]float64{"request.count{route=/a}": 1}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "action", rule: Rule{Action: "keep", Key: "host"}},
+		{name: "key", rule: Rule{Action: Drop}},
+		{name: "to", rule: Rule{Action: Rename, Key: "path"}},
+		{name: "same key", rule: Rule{Action: Rename, Key: "path", To: "path"}},
+		{name: "match", rule: Rule{Action: Replace, Key: "path", Match: "("}},
+		{name: "pattern", rule: Rule{Name: "[", Action: Drop, Key: "host"}},
+	}
+	for _, tc := range tests {
+		if _, err := New(nil, Config{Rules: []Rule{tc.rule}}); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relabel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "relabel.json")
+	raw := `{"rules": [{"action": "drop", "key": "host"}, {"name": "request.*", "action": "replace", "key": "path", "match": "/api/.*", "replacement": "/api"}]}`
+	if err := ioutil.WriteFile(filename, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal("failed to load config:", err)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[1].Replacement != "/api" || cfg.Rules[1].Name != "request.*" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
@@ -0,0 +1,72 @@
+package spanmetrics
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// config contains the settings of the processor.
+type config struct {
+	meterProvider metric.MeterProvider
+	attributes    []attributeLabel
+}
+
+// attributeLabel copies the span attribute key to the label as.
+type attributeLabel struct {
+	key, as label.Key
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		meterProvider: otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the processor.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithMeterProvider sets the metric.MeterProvider the metrics are recorded
+// with, the global one by default.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return meterProviderOption{mp}
+}
+
+// WithAttributeLabels labels the metrics with the values of the given span
+// attributes, under the same keys. Spans without the attribute are recorded
+// without the label. The attributes must have few distinct values, eg.
+// http.method rather than http.target.
+func WithAttributeLabels(keys ...label.Key) Option {
+	labels := make([]attributeLabel, len(keys))
+	for i, k := range keys {
+		labels[i] = attributeLabel{key: k, as: k}
+	}
+	return attributeLabelsOption(labels)
+}
+
+// WithAttributeLabel labels the metrics with the values of the span
+// attribute key under the label as.
+func WithAttributeLabel(key, as label.Key) Option {
+	return attributeLabelsOption{{key: key, as: as}}
+}
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+type attributeLabelsOption []attributeLabel
+
+// Apply implements Option.
+func (o meterProviderOption) Apply(c *config) {
+	c.meterProvider = o.MeterProvider
+}
+
+func (o attributeLabelsOption) Apply(c *config) {
+	c.attributes = append(c.attributes, o...)
+}
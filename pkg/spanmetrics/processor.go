@@ -0,0 +1,91 @@
+// Package spanmetrics derives RED metrics, rate, errors and duration, from
+// the spans recorded by a tracing.TracerProvider, so that traced services
+// get consistent metrics without writing instruments by hand:
+//
+//	p, err := spanmetrics.New(spanmetrics.WithAttributeLabels(semconv.HTTPMethodKey))
+//	tp := tracing.NewTracerProvider(tracing.WithAllSpansProcessor(p), ...)
+//
+// Every ended span is counted in span.calls and its duration recorded in
+// span.duration, labeled with its name, kind and status code. The error
+// rate is the rate of the calls with status code Error. Register the
+// processor with WithAllSpansProcessor for the metrics to cover the spans
+// that are not sampled too, with WithSpanProcessor it only sees the
+// sampled ones.
+package spanmetrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/unit"
+)
+
+// Label keys of the recorded metrics.
+const (
+	SpanNameKey   = label.Key("span.name")
+	SpanKindKey   = label.Key("span.kind")
+	StatusCodeKey = label.Key("status.code")
+)
+
+// Processor is a tracing.SpanProcessor recording the metrics of the spans
+// as they end.
+type Processor struct {
+	attributes []attributeLabel
+	calls      metric.Int64Counter
+	duration   metric.Float64ValueRecorder
+}
+
+var _ tracing.SpanProcessor = (*Processor)(nil)
+
+// New creates the instruments of the processor.
+func New(opts ...Option) (*Processor, error) {
+	cfg := newConfig(opts...)
+	meter := cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/spanmetrics")
+	p := &Processor{attributes: cfg.attributes}
+	var err error
+	if p.calls, err = meter.NewInt64Counter("span.calls",
+		metric.WithDescription("The number of spans ended")); err != nil {
+		return nil, fmt.Errorf("spanmetrics: %w", err)
+	}
+	if p.duration, err = meter.NewFloat64ValueRecorder("span.duration",
+		metric.WithDescription("The duration of the spans"),
+		metric.WithUnit(unit.Milliseconds)); err != nil {
+		return nil, fmt.Errorf("spanmetrics: %w", err)
+	}
+	return p, nil
+}
+
+// OnEnd implements tracing.SpanProcessor.
+func (p *Processor) OnEnd(s *tracing.SpanData) {
+	labels := make([]label.KeyValue, 3, 3+len(p.attributes))
+	labels[0] = SpanNameKey.String(s.Name)
+	labels[1] = SpanKindKey.String(s.SpanKind.String())
+	labels[2] = StatusCodeKey.String(s.StatusCode.String())
+	for _, a := range p.attributes {
+		for _, kv := range s.Attributes {
+			if kv.Key == a.key {
+				labels = append(labels, label.KeyValue{Key: a.as, Value: kv.Value})
+				break
+			}
+		}
+	}
+	ctx := context.Background()
+	elapsed := float64(s.EndTime.Sub(s.StartTime)) / float64(time.Millisecond)
+	p.calls.Add(ctx, 1, labels...)
+	p.duration.Record(ctx, elapsed, labels...)
+}
+
+// ForceFlush implements tracing.SpanProcessor, metrics are recorded as
+// spans end.
+func (p *Processor) ForceFlush(context.Context) error {
+	return nil
+}
+
+// Shutdown implements tracing.SpanProcessor.
+func (p *Processor) Shutdown(context.Context) error {
+	return nil
+}
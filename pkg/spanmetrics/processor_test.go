@@ -0,0 +1,89 @@
+package spanmetrics
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/metrictest"
+	"github.com/skonto/test-otel/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestProcessor(t *testing.T) {
+	pipeline := metrictest.NewPipeline()
+	p, err := New(
+		WithMeterProvider(pipeline.MeterProvider()),
+		WithAttributeLabels("http.method"),
+		WithAttributeLabel("http.status_code", "code"),
+	)
+	if err != nil {
+		t.Fatal("failed to create processor:", err)
+	}
+	tracer := tracing.NewTracerProvider(tracing.WithSpanProcessor(p)).Tracer("test")
+
+	start := time.Now()
+	for i, code := range []int64{200, 200, 500} {
+		_, s := tracer.Start(context.Background(), "/api/items/{id}",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithTimestamp(start),
+			trace.WithAttributes(label.String("http.method", "GET"), label.String("http.target", "/api/items/1")))
+		s.SetAttributes(label.Int64("http.status_code", code))
+		if code == 500 {
+			s.SetStatus(codes.Error, "")
+		}
+		s.End(trace.WithTimestamp(start.Add(time.Duration(i+1) * time.Millisecond)))
+	}
+	_, s := tracer.Start(context.Background(), "backend")
+	s.End()
+
+	got := pipeline.Values(t)
+
+	ok := "code=200,http.method=GET,span.kind=server,span.name=/api/items/{id},status.code=Unset"
+	failed := "code=500,http.method=GET,span.kind=server,span.name=/api/items/{id},status.code=Error"
+	backend := "span.kind=internal,span.name=backend,status.code=Unset"
+	want := map[string]float64{
+		"span.calls{" + ok + "}":               2,
+		"span.calls{" + failed + "}":           1,
+		"span.calls{" + backend + "}":          1,
+		"span.duration{" + ok + "}":            1 + 2,
+		"span.duration{" + ok + "}.count":      2,
+		"span.duration{" + failed + "}":        3,
+		"span.duration{" + backend + "}.count": 1,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s: got %v, want %v", name, got[name], value)
+		}
+	}
+	for name := range got {
+		if strings.Contains(name, "http.target") {
+			t.Errorf("%s is labeled with an attribute not extracted", name)
+		}
+	}
+}
+
+func TestUnsampledSpans(t *testing.T) {
+	pipeline := metrictest.NewPipeline()
+	p, err := New(WithMeterProvider(pipeline.MeterProvider()))
+	if err != nil {
+		t.Fatal("failed to create processor:", err)
+	}
+	sampled := tracing.NewTracerProvider(tracing.WithSpanProcessor(p), tracing.WithSampleRatio(0)).Tracer("test")
+	all := tracing.NewTracerProvider(tracing.WithAllSpansProcessor(p), tracing.WithSampleRatio(0)).Tracer("test")
+	for _, tracer := range []trace.Tracer{sampled, all} {
+		_, s := tracer.Start(context.Background(), "backend")
+		s.End()
+	}
+
+	// Only the processors given with WithAllSpansProcessor see the spans
+	// that are not sampled.
+	want := map[string]float64{"span.kind=internal,span.name=backend,status.code=Unset": 1}
+	if got := pipeline.Sums(t, "span.calls"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SpanExporter sends batches of spans to a backend.
+type SpanExporter interface {
+	// ExportSpans exports a batch of spans, which must not be modified.
+	ExportSpans(ctx context.Context, spans []*SpanData) error
+	// Shutdown releases the exporter, it is called once the last batch is
+	// exported.
+	Shutdown(ctx context.Context) error
+}
+
+const outcomeKey = label.Key("outcome")
+
+// ErrShutdown is returned when flushing a BatchSpanProcessor that was shut
+// down.
+var ErrShutdown = errors.New("tracing: span processor is shut down")
+
+// BatchSpanProcessor queues the ended spans and exports them in batches
+// from a goroutine, when a batch is full or after the batch timeout. Spans
+// ended while the queue is full are dropped rather than blocking the
+// instrumented code.
+type BatchSpanProcessor struct {
+	// First for the alignment of atomic operations on 32 bits platforms.
+	exported, dropped int64
+
+	cfg      batchConfig
+	exporter SpanExporter
+
+	queue   chan *SpanData
+	flushCh chan chan error
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+
+	stopOnce sync.Once
+	stopped  int32
+}
+
+var _ SpanProcessor = (*BatchSpanProcessor)(nil)
+
+// NewBatchSpanProcessor creates a BatchSpanProcessor exporting to exporter
+// and starts its goroutine.
+func NewBatchSpanProcessor(exporter SpanExporter, opts ...BatchOption) *BatchSpanProcessor {
+	bsp := &BatchSpanProcessor{
+		cfg:      newBatchConfig(opts...),
+		exporter: exporter,
+		flushCh:  make(chan chan error),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	bsp.queue = make(chan *SpanData, bsp.cfg.maxQueueSize)
+	if err := bsp.registerMetrics(); err != nil {
+		otel.Handle(err)
+	}
+	go bsp.run()
+	return bsp
+}
+
+// OnEnd implements SpanProcessor.
+func (bsp *BatchSpanProcessor) OnEnd(s *SpanData) {
+	if atomic.LoadInt32(&bsp.stopped) != 0 {
+		return
+	}
+	select {
+	case bsp.queue <- s:
+	default:
+		atomic.AddInt64(&bsp.dropped, 1)
+	}
+}
+
+// ForceFlush implements SpanProcessor, it returns once the spans queued
+// before the call are exported.
+func (bsp *BatchSpanProcessor) ForceFlush(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case bsp.flushCh <- done:
+	case <-bsp.doneCh:
+		return ErrShutdown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown implements SpanProcessor, it exports the queued spans before
+// shutting the exporter down.
+func (bsp *BatchSpanProcessor) Shutdown(ctx context.Context) error {
+	var err error
+	bsp.stopOnce.Do(func() {
+		atomic.StoreInt32(&bsp.stopped, 1)
+		close(bsp.stopCh)
+		select {
+		case <-bsp.doneCh:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		err = bsp.exporter.Shutdown(ctx)
+	})
+	return err
+}
+
+func (bsp *BatchSpanProcessor) run() {
+	defer close(bsp.doneCh)
+	timer := time.NewTimer(bsp.cfg.batchTimeout)
+	defer timer.Stop()
+	batch := make([]*SpanData, 0, bsp.cfg.maxExportBatchSize)
+	export := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := bsp.export(batch)
+		batch = make([]*SpanData, 0, bsp.cfg.maxExportBatchSize)
+		return err
+	}
+	// drain exports what is queued, in batches.
+	drain := func() error {
+		var first error
+		for {
+			select {
+			case s := <-bsp.queue:
+				batch = append(batch, s)
+				if len(batch) == bsp.cfg.maxExportBatchSize {
+					if err := export(); err != nil && first == nil {
+						first = err
+					}
+				}
+			default:
+				if err := export(); err != nil && first == nil {
+					first = err
+				}
+				return first
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-bsp.queue:
+			batch = append(batch, s)
+			if len(batch) == bsp.cfg.maxExportBatchSize {
+				_ = export()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(bsp.cfg.batchTimeout)
+			}
+		case <-timer.C:
+			_ = export()
+			timer.Reset(bsp.cfg.batchTimeout)
+		case done := <-bsp.flushCh:
+			done <- drain()
+		case <-bsp.stopCh:
+			_ = drain()
+			return
+		}
+	}
+}
+
+// export exports a batch, errors are also reported to the global handler
+// since the instrumented code is not there to get them.
+func (bsp *BatchSpanProcessor) export(batch []*SpanData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bsp.cfg.exportTimeout)
+	defer cancel()
+	if err := bsp.exporter.ExportSpans(ctx, batch); err != nil {
+		atomic.AddInt64(&bsp.dropped, int64(len(batch)))
+		err = fmt.Errorf("tracing: failed to export %d spans: %w", len(batch), err)
+		otel.Handle(err)
+		return err
+	}
+	atomic.AddInt64(&bsp.exported, int64(len(batch)))
+	return nil
+}
+
+// registerMetrics reports the exported and dropped spans, and the length
+// of the queue.
+func (bsp *BatchSpanProcessor) registerMetrics() error {
+	if bsp.cfg.meterProvider == nil {
+		return nil
+	}
+	meter := bsp.cfg.meterProvider.Meter("github.com/skonto/test-otel/pkg/tracing")
+	if _, err := meter.NewInt64SumObserver("tracing.spans",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			result.Observe(atomic.LoadInt64(&bsp.exported), outcomeKey.String("exported"))
+			result.Observe(atomic.LoadInt64(&bsp.dropped), outcomeKey.String("dropped"))
+		},
+		metric.WithDescription("The number of ended spans exported or dropped")); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	if _, err := meter.NewInt64ValueObserver("tracing.queue_length",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			result.Observe(int64(len(bsp.queue)))
+		},
+		metric.WithDescription("The number of ended spans waiting to be exported")); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package tracing
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	// DefaultMaxQueueSize is the number of ended spans a BatchSpanProcessor
+	// buffers before dropping spans.
+	DefaultMaxQueueSize = 2048
+	// DefaultMaxExportBatchSize is the number of spans a BatchSpanProcessor
+	// exports at most at once.
+	DefaultMaxExportBatchSize = 512
+	// DefaultBatchTimeout is how long a BatchSpanProcessor waits for a batch
+	// to fill before exporting it.
+	DefaultBatchTimeout = 5 * time.Second
+	// DefaultExportTimeout bounds the export of a batch.
+	DefaultExportTimeout = 30 * time.Second
+)
+
+// config contains the settings of the tracer provider.
+type config struct {
+	resource   *resource.Resource
+	processors []SpanProcessor
+	// allSpansProcessors also receive the spans that are not sampled.
+	allSpansProcessors []SpanProcessor
+	sampleRatio        float64
+}
+
+// newConfig computes a config from the supplied Options.
+func newConfig(opts ...Option) config {
+	c := config{
+		resource:    resource.Empty(),
+		sampleRatio: 1,
+	}
+	for _, opt := range opts {
+		opt.Apply(&c)
+	}
+	return c
+}
+
+// Option supports configuring optional settings for the tracer provider.
+type Option interface {
+	// Apply updates *config.
+	Apply(*config)
+}
+
+// WithResource sets the resource describing the process the spans are
+// recorded by.
+func WithResource(r *resource.Resource) Option {
+	return resourceOption{r}
+}
+
+// WithSpanProcessor registers a processor the ended spans are passed to.
+// It can be given several times.
+func WithSpanProcessor(p SpanProcessor) Option {
+	return spanProcessorOption{p}
+}
+
+// WithAllSpansProcessor registers a processor the ended spans are passed
+// to whether they are sampled or not, eg. to derive metrics from every
+// request. The spans that are not sampled are then recorded, but only
+// passed to these processors. It can be given several times.
+func WithAllSpansProcessor(p SpanProcessor) Option {
+	return allSpansProcessorOption{p}
+}
+
+// WithSampleRatio sets the ratio of the traces started by the provider
+// that are sampled, all of them by default. Spans with a parent follow the
+// sampling decision of their parent.
+func WithSampleRatio(ratio float64) Option {
+	return sampleRatioOption(ratio)
+}
+
+type resourceOption struct{ *resource.Resource }
+
+type spanProcessorOption struct{ SpanProcessor }
+
+type allSpansProcessorOption struct{ SpanProcessor }
+
+type sampleRatioOption float64
+
+// Apply implements Option.
+func (o resourceOption) Apply(c *config) {
+	if o.Resource != nil {
+		c.resource = o.Resource
+	}
+}
+
+func (o spanProcessorOption) Apply(c *config) {
+	c.processors = append(c.processors, o.SpanProcessor)
+}
+
+func (o allSpansProcessorOption) Apply(c *config) {
+	c.allSpansProcessors = append(c.allSpansProcessors, o.SpanProcessor)
+}
+
+func (o sampleRatioOption) Apply(c *config) {
+	switch {
+	case o < 0:
+		c.sampleRatio = 0
+	case o > 1:
+		c.sampleRatio = 1
+	default:
+		c.sampleRatio = float64(o)
+	}
+}
+
+// batchConfig contains the settings of the batch span processor.
+type batchConfig struct {
+	maxQueueSize       int
+	maxExportBatchSize int
+	batchTimeout       time.Duration
+	exportTimeout      time.Duration
+	meterProvider      metric.MeterProvider
+}
+
+// newBatchConfig computes a batchConfig from the supplied BatchOptions.
+func newBatchConfig(opts ...BatchOption) batchConfig {
+	c := batchConfig{
+		maxQueueSize:       DefaultMaxQueueSize,
+		maxExportBatchSize: DefaultMaxExportBatchSize,
+		batchTimeout:       DefaultBatchTimeout,
+		exportTimeout:      DefaultExportTimeout,
+	}
+	for _, opt := range opts {
+		opt.ApplyBatch(&c)
+	}
+	if c.maxExportBatchSize > c.maxQueueSize {
+		c.maxExportBatchSize = c.maxQueueSize
+	}
+	return c
+}
+
+// BatchOption supports configuring optional settings for the batch span
+// processor.
+type BatchOption interface {
+	// ApplyBatch updates *batchConfig.
+	ApplyBatch(*batchConfig)
+}
+
+// WithMaxQueueSize sets the number of ended spans buffered before spans are
+// dropped, DefaultMaxQueueSize by default. Non positive values are ignored.
+func WithMaxQueueSize(n int) BatchOption {
+	return maxQueueSizeOption(n)
+}
+
+// WithMaxExportBatchSize sets the number of spans exported at most at once,
+// DefaultMaxExportBatchSize by default. Non positive values are ignored.
+func WithMaxExportBatchSize(n int) BatchOption {
+	return maxExportBatchSizeOption(n)
+}
+
+// WithBatchTimeout sets how long to wait for a batch to fill before
+// exporting it, DefaultBatchTimeout by default. Non positive values are
+// ignored.
+func WithBatchTimeout(d time.Duration) BatchOption {
+	return batchTimeoutOption(d)
+}
+
+// WithExportTimeout bounds the export of a batch, DefaultExportTimeout by
+// default. Non positive values are ignored.
+func WithExportTimeout(d time.Duration) BatchOption {
+	return exportTimeoutOption(d)
+}
+
+// WithMeterProvider sets the metric.MeterProvider used to report the
+// exported and dropped spans. If not set no metrics are reported.
+func WithMeterProvider(mp metric.MeterProvider) BatchOption {
+	return meterProviderOption{mp}
+}
+
+type maxQueueSizeOption int
+
+type maxExportBatchSizeOption int
+
+type batchTimeoutOption time.Duration
+
+type exportTimeoutOption time.Duration
+
+type meterProviderOption struct{ metric.MeterProvider }
+
+// ApplyBatch implements BatchOption.
+func (o maxQueueSizeOption) ApplyBatch(c *batchConfig) {
+	if o > 0 {
+		c.maxQueueSize = int(o)
+	}
+}
+
+func (o maxExportBatchSizeOption) ApplyBatch(c *batchConfig) {
+	if o > 0 {
+		c.maxExportBatchSize = int(o)
+	}
+}
+
+func (o batchTimeoutOption) ApplyBatch(c *batchConfig) {
+	if o > 0 {
+		c.batchTimeout = time.Duration(o)
+	}
+}
+
+func (o exportTimeoutOption) ApplyBatch(c *batchConfig) {
+	if o > 0 {
+		c.exportTimeout = time.Duration(o)
+	}
+}
+
+func (o meterProviderOption) ApplyBatch(c *batchConfig) {
+	c.meterProvider = o.MeterProvider
+}
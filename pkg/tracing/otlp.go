@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skonto/test-otel/pkg/internal/transform"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// OTLPExporter exports spans to a collector over OTLP/gRPC, on a
+// connection owned by the caller so that it can be shared with the metric
+// exports.
+type OTLPExporter struct {
+	client coltracepb.TraceServiceClient
+}
+
+var _ SpanExporter = (*OTLPExporter)(nil)
+
+// NewOTLPExporter creates an exporter sending spans on conn.
+func NewOTLPExporter(conn grpc.ClientConnInterface) *OTLPExporter {
+	return &OTLPExporter{client: coltracepb.NewTraceServiceClient(conn)}
+}
+
+// ExportSpans implements SpanExporter.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	_, err := e.client.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: ResourceSpans(spans)})
+	if err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	return nil
+}
+
+// Shutdown implements SpanExporter, the connection is left open for its
+// owner to close.
+func (e *OTLPExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// ResourceSpans transforms spans into OTLP ResourceSpans, grouped by
+// resource and then by instrumentation library.
+func ResourceSpans(spans []*SpanData) []*tracepb.ResourceSpans {
+	type resourceBatch struct {
+		rs        *tracepb.ResourceSpans
+		libraries map[instrumentation.Library]*tracepb.InstrumentationLibrarySpans
+	}
+	var out []*tracepb.ResourceSpans
+	grouped := map[label.Distinct]*resourceBatch{}
+	for _, s := range spans {
+		rID := s.Resource.Equivalent()
+		rb, ok := grouped[rID]
+		if !ok {
+			rb = &resourceBatch{
+				rs:        &tracepb.ResourceSpans{Resource: transform.Resource(s.Resource)},
+				libraries: map[instrumentation.Library]*tracepb.InstrumentationLibrarySpans{},
+			}
+			grouped[rID] = rb
+			out = append(out, rb.rs)
+		}
+		ils, ok := rb.libraries[s.InstrumentationLibrary]
+		if !ok {
+			ils = &tracepb.InstrumentationLibrarySpans{
+				InstrumentationLibrary: &commonpb.InstrumentationLibrary{
+					Name:    s.InstrumentationLibrary.Name,
+					Version: s.InstrumentationLibrary.Version,
+				},
+			}
+			rb.libraries[s.InstrumentationLibrary] = ils
+			rb.rs.InstrumentationLibrarySpans = append(rb.rs.InstrumentationLibrarySpans, ils)
+		}
+		ils.Spans = append(ils.Spans, span2pb(s))
+	}
+	return out
+}
+
+func span2pb(s *SpanData) *tracepb.Span {
+	sc := s.SpanContext
+	pb := &tracepb.Span{
+		TraceId:                sc.TraceID[:],
+		SpanId:                 sc.SpanID[:],
+		TraceState:             sc.TraceState.String(),
+		Name:                   s.Name,
+		Kind:                   tracepb.Span_SpanKind(s.SpanKind),
+		StartTimeUnixNano:      toNanos(s.StartTime),
+		EndTimeUnixNano:        toNanos(s.EndTime),
+		Attributes:             transform.Attributes(s.Attributes),
+		DroppedAttributesCount: uint32(s.DroppedAttributes),
+		DroppedEventsCount:     uint32(s.DroppedEvents),
+		DroppedLinksCount:      uint32(s.DroppedLinks),
+		Status:                 &tracepb.Status{Code: statusCode(s.StatusCode), Message: s.StatusMessage},
+	}
+	if s.ParentSpanID.IsValid() {
+		pb.ParentSpanId = s.ParentSpanID[:]
+	}
+	for _, e := range s.Events {
+		pb.Events = append(pb.Events, &tracepb.Span_Event{
+			TimeUnixNano: toNanos(e.Time),
+			Name:         e.Name,
+			Attributes:   transform.Attributes(e.Attributes),
+		})
+	}
+	for _, l := range s.Links {
+		pb.Links = append(pb.Links, &tracepb.Span_Link{
+			TraceId:    l.TraceID[:],
+			SpanId:     l.SpanID[:],
+			TraceState: l.TraceState.String(),
+			Attributes: transform.Attributes(l.Attributes),
+		})
+	}
+	return pb
+}
+
+// statusCode maps the API codes to the OTLP ones, which are numbered
+// differently.
+func statusCode(code codes.Code) tracepb.Status_StatusCode {
+	switch code {
+	case codes.Ok:
+		return tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		return tracepb.Status_STATUS_CODE_ERROR
+	}
+	return tracepb.Status_STATUS_CODE_UNSET
+}
+
+func toNanos(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
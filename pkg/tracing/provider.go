@@ -0,0 +1,199 @@
+// Package tracing is a lightweight implementation of the
+// go.opentelemetry.io/otel/trace API: a tracer provider recording spans and
+// passing them to processors once ended, a batch span processor and an
+// exporter sending the batches to a collector over OTLP/gRPC.
+//
+//	conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+//	tp := tracing.NewTracerProvider(
+//		tracing.WithResource(res),
+//		tracing.WithSpanProcessor(tracing.NewBatchSpanProcessor(tracing.NewOTLPExporter(conn))))
+//	defer tp.Shutdown(ctx)
+//	otel.SetTracerProvider(tp)
+//
+// It covers what the instrumentation of this repository needs rather than
+// the whole SDK: there are no span limits to configure and the only
+// sampler is a trace ID ratio honoring the decision of the parent. The
+// spans that are not sampled are still recorded for the processors given
+// with WithAllSpansProcessor, eg. to derive metrics from every request.
+package tracing
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanProcessor receives the spans recorded by a TracerProvider once they
+// end. OnEnd is called synchronously from Span.End and must not block.
+type SpanProcessor interface {
+	// OnEnd is called with the data of an ended span, which must not be
+	// modified.
+	OnEnd(s *SpanData)
+	// ForceFlush exports the spans received so far.
+	ForceFlush(ctx context.Context) error
+	// Shutdown flushes and releases the processor, spans received
+	// afterwards are ignored.
+	Shutdown(ctx context.Context) error
+}
+
+// TracerProvider creates the tracers recording spans, it implements
+// trace.TracerProvider.
+type TracerProvider struct {
+	cfg            config
+	sampleBoundary uint64
+
+	mu      sync.Mutex
+	tracers map[instrumentation.Library]*tracer
+	ids     *rand.Rand
+}
+
+var _ trace.TracerProvider = (*TracerProvider)(nil)
+
+// NewTracerProvider creates a tracer provider, spans are dropped once ended
+// unless a processor is given with WithSpanProcessor or
+// WithAllSpansProcessor.
+func NewTracerProvider(opts ...Option) *TracerProvider {
+	cfg := newConfig(opts...)
+	var seed int64
+	_ = binary.Read(crand.Reader, binary.LittleEndian, &seed)
+	boundary := uint64(math.MaxUint64)
+	if cfg.sampleRatio < 1 {
+		boundary = uint64(cfg.sampleRatio * (1 << 64))
+	}
+	return &TracerProvider{
+		cfg:            cfg,
+		sampleBoundary: boundary,
+		tracers:        map[instrumentation.Library]*tracer{},
+		ids:            rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Tracer implements trace.TracerProvider.
+func (p *TracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	library := instrumentation.Library{Name: name, Version: trace.NewTracerConfig(opts...).InstrumentationVersion}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.tracers[library]
+	if !ok {
+		t = &tracer{provider: p, library: library}
+		p.tracers[library] = t
+	}
+	return t
+}
+
+// ForceFlush flushes the processors of the provider and returns the first
+// error met.
+func (p *TracerProvider) ForceFlush(ctx context.Context) error {
+	var first error
+	for _, sp := range p.processors() {
+		if err := sp.ForceFlush(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Shutdown flushes and shuts the processors of the provider down and
+// returns the first error met. It must be called before the process exits
+// for the last spans to be exported.
+func (p *TracerProvider) Shutdown(ctx context.Context) error {
+	var first error
+	for _, sp := range p.processors() {
+		if err := sp.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// newIDs returns a new span ID, and a new trace ID when the span starts a
+// trace.
+func (p *TracerProvider) newIDs(newTrace bool) (tid trace.TraceID, sid trace.SpanID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if newTrace {
+		for !tid.IsValid() {
+			_, _ = p.ids.Read(tid[:])
+		}
+	}
+	for !sid.IsValid() {
+		_, _ = p.ids.Read(sid[:])
+	}
+	return tid, sid
+}
+
+// sampled decides whether a new trace is sampled from the random low half
+// of its ID, so that all the services of a trace agree given the same ratio.
+func (p *TracerProvider) sampled(tid trace.TraceID) bool {
+	if p.sampleBoundary == math.MaxUint64 {
+		return true
+	}
+	return binary.BigEndian.Uint64(tid[8:]) < p.sampleBoundary
+}
+
+// processors returns every processor of the provider.
+func (p *TracerProvider) processors() []SpanProcessor {
+	all := make([]SpanProcessor, 0, len(p.cfg.processors)+len(p.cfg.allSpansProcessors))
+	all = append(all, p.cfg.processors...)
+	return append(all, p.cfg.allSpansProcessors...)
+}
+
+// recordsUnsampled tells whether the spans that are not sampled are
+// recorded, for the processors receiving all the spans.
+func (p *TracerProvider) recordsUnsampled() bool {
+	return len(p.cfg.allSpansProcessors) > 0
+}
+
+func (p *TracerProvider) end(s *SpanData) {
+	if s.SpanContext.IsSampled() {
+		for _, sp := range p.cfg.processors {
+			sp.OnEnd(s)
+		}
+	}
+	for _, sp := range p.cfg.allSpansProcessors {
+		sp.OnEnd(s)
+	}
+}
+
+type tracer struct {
+	provider *TracerProvider
+	library  instrumentation.Library
+}
+
+// Start implements trace.Tracer.
+func (t *tracer) Start(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanConfig(opts...)
+	var parent trace.SpanContext
+	remote := false
+	if !cfg.NewRoot {
+		parent = trace.SpanContextFromContext(ctx)
+		if !parent.IsValid() {
+			parent = trace.RemoteSpanContextFromContext(ctx)
+			remote = parent.IsValid()
+		}
+	}
+
+	sc := trace.SpanContext{TraceState: parent.TraceState}
+	if parent.IsValid() {
+		_, sc.SpanID = t.provider.newIDs(false)
+		sc.TraceID = parent.TraceID
+		sc.TraceFlags = parent.TraceFlags & trace.FlagsSampled
+	} else {
+		sc.TraceID, sc.SpanID = t.provider.newIDs(true)
+		if t.provider.sampled(sc.TraceID) {
+			sc.TraceFlags = trace.FlagsSampled
+		}
+	}
+	if !sc.IsSampled() && !t.provider.recordsUnsampled() {
+		s := nonRecordingSpan{tracer: t, sc: sc}
+		return trace.ContextWithSpan(ctx, s), s
+	}
+	s := newSpan(t, name, sc, parent.SpanID, remote, cfg)
+	return trace.ContextWithSpan(ctx, s), s
+}
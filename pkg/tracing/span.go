@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limits on what a span records, further attributes, events and links are
+// counted as dropped.
+const (
+	MaxAttributes = 128
+	MaxEvents     = 128
+	MaxLinks      = 128
+)
+
+// Keys of the attributes of the events recorded by Span.RecordError.
+const (
+	ExceptionEventName  = "exception"
+	ExceptionTypeKey    = label.Key("exception.type")
+	ExceptionMessageKey = label.Key("exception.message")
+)
+
+// SpanData is what a span recorded, handed to the SpanProcessors once the
+// span ended.
+type SpanData struct {
+	SpanContext trace.SpanContext
+	// ParentSpanID is invalid for root spans.
+	ParentSpanID trace.SpanID
+	// HasRemoteParent is set when the parent span is from another process.
+	HasRemoteParent bool
+	Name            string
+	SpanKind        trace.SpanKind
+	StartTime       time.Time
+	EndTime         time.Time
+	Attributes      []label.KeyValue
+	Events          []Event
+	Links           []trace.Link
+	StatusCode      codes.Code
+	StatusMessage   string
+
+	DroppedAttributes int
+	DroppedEvents     int
+	DroppedLinks      int
+
+	Resource               *resource.Resource
+	InstrumentationLibrary instrumentation.Library
+}
+
+// Event is an event recorded in a span.
+type Event struct {
+	Name       string
+	Time       time.Time
+	Attributes []label.KeyValue
+}
+
+// span is a recording span, its data is handed to the processors when it
+// ends.
+type span struct {
+	tracer *tracer
+
+	mu    sync.Mutex
+	data  *SpanData
+	ended bool
+}
+
+var _ trace.Span = (*span)(nil)
+
+func newSpan(t *tracer, name string, sc trace.SpanContext, parent trace.SpanID, remote bool, cfg *trace.SpanConfig) *span {
+	start := cfg.Timestamp
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &span{tracer: t, data: &SpanData{
+		SpanContext:            sc,
+		ParentSpanID:           parent,
+		HasRemoteParent:        remote,
+		Name:                   name,
+		SpanKind:               trace.ValidateSpanKind(cfg.SpanKind),
+		StartTime:              start,
+		Resource:               t.provider.cfg.resource,
+		InstrumentationLibrary: t.library,
+	}}
+	s.setAttributes(cfg.Attributes)
+	for _, l := range cfg.Links {
+		if len(s.data.Links) >= MaxLinks {
+			s.data.DroppedLinks++
+			continue
+		}
+		s.data.Links = append(s.data.Links, l)
+	}
+	return s
+}
+
+// Tracer implements trace.Span.
+func (s *span) Tracer() trace.Tracer {
+	return s.tracer
+}
+
+// End implements trace.Span.
+func (s *span) End(opts ...trace.SpanOption) {
+	cfg := trace.NewSpanConfig(opts...)
+	end := cfg.Timestamp
+	if end.IsZero() {
+		end = time.Now()
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = end
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.provider.end(data)
+}
+
+// AddEvent implements trace.Span.
+func (s *span) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if len(s.data.Events) >= MaxEvents {
+		s.data.DroppedEvents++
+		return
+	}
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: cfg.Timestamp, Attributes: cfg.Attributes})
+}
+
+// IsRecording implements trace.Span.
+func (s *span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+// RecordError implements trace.Span, it adds an exception event.
+func (s *span) RecordError(err error, opts ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	opts = append(opts, trace.WithAttributes(
+		ExceptionTypeKey.String(errorType(err)),
+		ExceptionMessageKey.String(err.Error()),
+	))
+	s.AddEvent(ExceptionEventName, opts...)
+}
+
+// SpanContext implements trace.Span.
+func (s *span) SpanContext() trace.SpanContext {
+	return s.data.SpanContext
+}
+
+// SetStatus implements trace.Span.
+func (s *span) SetStatus(code codes.Code, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.data.StatusCode = code
+	s.data.StatusMessage = ""
+	if code == codes.Error {
+		s.data.StatusMessage = msg
+	}
+}
+
+// SetName implements trace.Span.
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+// SetAttributes implements trace.Span.
+func (s *span) SetAttributes(kvs ...label.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.setAttributes(kvs)
+	}
+}
+
+// setAttributes overwrites the attributes with the same key and appends
+// the others up to MaxAttributes.
+func (s *span) setAttributes(kvs []label.KeyValue) {
+next:
+	for _, kv := range kvs {
+		if !kv.Key.Defined() {
+			continue
+		}
+		for i := range s.data.Attributes {
+			if s.data.Attributes[i].Key == kv.Key {
+				s.data.Attributes[i] = kv
+				continue next
+			}
+		}
+		if len(s.data.Attributes) >= MaxAttributes {
+			s.data.DroppedAttributes++
+			continue
+		}
+		s.data.Attributes = append(s.data.Attributes, kv)
+	}
+}
+
+// errorType returns the Go type of an error, eg. "*net.OpError".
+func errorType(err error) string {
+	return reflect.TypeOf(err).String()
+}
+
+// nonRecordingSpan carries the context of a span that is not sampled, so
+// that its children are not sampled either.
+type nonRecordingSpan struct {
+	tracer *tracer
+	sc     trace.SpanContext
+}
+
+var _ trace.Span = nonRecordingSpan{}
+
+func (s nonRecordingSpan) Tracer() trace.Tracer                    { return s.tracer }
+func (s nonRecordingSpan) End(...trace.SpanOption)                 {}
+func (s nonRecordingSpan) AddEvent(string, ...trace.EventOption)   {}
+func (s nonRecordingSpan) IsRecording() bool                       { return false }
+func (s nonRecordingSpan) RecordError(error, ...trace.EventOption) {}
+func (s nonRecordingSpan) SpanContext() trace.SpanContext          { return s.sc }
+func (s nonRecordingSpan) SetStatus(codes.Code, string)            {}
+func (s nonRecordingSpan) SetName(string)                          {}
+func (s nonRecordingSpan) SetAttributes(...label.KeyValue)         {}
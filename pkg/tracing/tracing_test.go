@@ -0,0 +1,370 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// memoryExporter keeps the exported batches.
+type memoryExporter struct {
+	mu       sync.Mutex
+	batches  [][]*SpanData
+	err      error
+	shutdown bool
+}
+
+func (e *memoryExporter) ExportSpans(_ context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.batches = append(e.batches, append([]*SpanData(nil), spans...))
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shutdown = true
+	return nil
+}
+
+func (e *memoryExporter) spans() []*SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var spans []*SpanData
+	for _, b := range e.batches {
+		spans = append(spans, b...)
+	}
+	return spans
+}
+
+// syncProcessor hands the spans to the test as they end.
+type syncProcessor struct {
+	mu    sync.Mutex
+	ended []*SpanData
+}
+
+func (p *syncProcessor) OnEnd(s *SpanData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ended = append(p.ended, s)
+}
+
+func (p *syncProcessor) ForceFlush(context.Context) error { return nil }
+
+func (p *syncProcessor) Shutdown(context.Context) error { return nil }
+
+func TestSpans(t *testing.T) {
+	sp := &syncProcessor{}
+	res := resource.NewWithAttributes(label.String("service.name", "test"))
+	tracer := NewTracerProvider(WithSpanProcessor(sp), WithResource(res)).
+		Tracer("test", trace.WithInstrumentationVersion("v1"))
+
+	ctx, parent := tracer.Start(context.Background(), "parent",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(label.String("a", "1")))
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttributes(label.String("b", "1"), label.String("b", "2"))
+	child.RecordError(errors.New("boom"))
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	child.SetName("ignored")
+	parent.SetName("renamed")
+	parent.End()
+	parent.End()
+
+	if len(sp.ended) != 2 {
+		t.Fatalf("got %d spans, want 2", len(sp.ended))
+	}
+	c, p := sp.ended[0], sp.ended[1]
+	if p.Name != "renamed" || p.SpanKind != trace.SpanKindServer || p.ParentSpanID.IsValid() || !p.SpanContext.IsSampled() {
+		t.Errorf("unexpected parent %+v", p)
+	}
+	if p.Resource != res || p.InstrumentationLibrary.Name != "test" || p.InstrumentationLibrary.Version != "v1" {
+		t.Errorf("got resource %v and library %v", p.Resource, p.InstrumentationLibrary)
+	}
+	if c.Name != "child" || c.SpanKind != trace.SpanKindInternal {
+		t.Errorf("unexpected child %+v", c)
+	}
+	if c.SpanContext.TraceID != p.SpanContext.TraceID || c.ParentSpanID != p.SpanContext.SpanID || c.HasRemoteParent {
+		t.Errorf("child %v is not a child of %v", c.SpanContext, p.SpanContext)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0] != label.String("b", "2") {
+		t.Errorf("got attributes %v, want b=2", c.Attributes)
+	}
+	if c.StatusCode != codes.Error || c.StatusMessage != "failed" {
+		t.Errorf("got status %v %q", c.StatusCode, c.StatusMessage)
+	}
+	want := []label.KeyValue{ExceptionTypeKey.String("*errors.errorString"), ExceptionMessageKey.String("boom")}
+	if len(c.Events) != 1 || c.Events[0].Name != ExceptionEventName || len(c.Events[0].Attributes) != 2 ||
+		c.Events[0].Attributes[0] != want[0] || c.Events[0].Attributes[1] != want[1] {
+		t.Errorf("got events %+v, want an exception", c.Events)
+	}
+	if c.EndTime.Before(c.StartTime) || p.EndTime.Before(c.EndTime) {
+		t.Errorf("child %v-%v ends after parent %v-%v", c.StartTime, c.EndTime, p.StartTime, p.EndTime)
+	}
+}
+
+func TestRemoteParent(t *testing.T) {
+	sp := &syncProcessor{}
+	tracer := NewTracerProvider(WithSpanProcessor(sp)).Tracer("test")
+	remote := trace.SpanContext{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	}
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), remote)
+
+	_, s := tracer.Start(ctx, "server")
+	s.End()
+	_, root := tracer.Start(ctx, "root", trace.WithNewRoot())
+	root.End()
+
+	if got := sp.ended[0]; got.SpanContext.TraceID != remote.TraceID || got.ParentSpanID != remote.SpanID || !got.HasRemoteParent {
+		t.Errorf("got %v with parent %v, want a child of %v", got.SpanContext, got.ParentSpanID, remote)
+	}
+	if got := sp.ended[1]; got.SpanContext.TraceID == remote.TraceID || got.ParentSpanID.IsValid() {
+		t.Errorf("got %v with parent %v, want a new trace", got.SpanContext, got.ParentSpanID)
+	}
+
+	// Not sampled upstream.
+	remote.TraceFlags = 0
+	ctx = trace.ContextWithRemoteSpanContext(context.Background(), remote)
+	ctx, s = tracer.Start(ctx, "server")
+	_, child := tracer.Start(ctx, "child")
+	if s.IsRecording() || child.IsRecording() || child.SpanContext().TraceID != remote.TraceID {
+		t.Errorf("got recording spans %v for a trace not sampled", child.SpanContext())
+	}
+	child.End()
+	s.End()
+	if len(sp.ended) != 2 {
+		t.Errorf("got %d spans, want 2", len(sp.ended))
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	for _, ratio := range []float64{0, 0.5, 1} {
+		sp := &syncProcessor{}
+		tracer := NewTracerProvider(WithSpanProcessor(sp), WithSampleRatio(ratio)).Tracer("test")
+		const n = 1000
+		for i := 0; i < n; i++ {
+			_, s := tracer.Start(context.Background(), "span")
+			s.End()
+		}
+		got := float64(len(sp.ended)) / n
+		if got < ratio-0.1 || got > ratio+0.1 {
+			t.Errorf("sampled %v of the traces, want %v", got, ratio)
+		}
+	}
+}
+
+func TestAllSpansProcessor(t *testing.T) {
+	sampled, all := &syncProcessor{}, &syncProcessor{}
+	tracer := NewTracerProvider(WithSpanProcessor(sampled), WithAllSpansProcessor(all), WithSampleRatio(0)).Tracer("test")
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	parent.End()
+	remote := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{2}}
+	_, server := tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), remote), "server")
+	server.End()
+
+	if len(sampled.ended) != 0 {
+		t.Errorf("got %d spans not sampled in the processor of the sampled ones", len(sampled.ended))
+	}
+	if len(all.ended) != 3 {
+		t.Fatalf("got %d spans, want 3", len(all.ended))
+	}
+	c, p, s := all.ended[0], all.ended[1], all.ended[2]
+	for _, d := range all.ended {
+		if d.SpanContext.IsSampled() {
+			t.Errorf("%s is sampled", d.Name)
+		}
+	}
+	if c.ParentSpanID != p.SpanContext.SpanID || c.SpanContext.TraceID != p.SpanContext.TraceID {
+		t.Errorf("child %v is not a child of %v", c.SpanContext, p.SpanContext)
+	}
+	if s.SpanContext.TraceID != remote.TraceID || s.ParentSpanID != remote.SpanID {
+		t.Errorf("got %v with parent %v, want a child of %v", s.SpanContext, s.ParentSpanID, remote)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	sp := &syncProcessor{}
+	_, s := NewTracerProvider(WithSpanProcessor(sp)).Tracer("test").Start(context.Background(), "span")
+	for i := 0; i < MaxAttributes+3; i++ {
+		s.SetAttributes(label.Int("k"+string(rune('a'+i%26))+string(rune('a'+i/26)), i))
+		s.AddEvent("event")
+	}
+	s.End()
+	got := sp.ended[0]
+	if len(got.Attributes) != MaxAttributes || got.DroppedAttributes != 3 {
+		t.Errorf("got %d attributes, %d dropped", len(got.Attributes), got.DroppedAttributes)
+	}
+	if len(got.Events) != MaxEvents || got.DroppedEvents != MaxAttributes+3-MaxEvents {
+		t.Errorf("got %d events, %d dropped", len(got.Events), got.DroppedEvents)
+	}
+}
+
+func TestBatchSpanProcessor(t *testing.T) {
+	exporter := &memoryExporter{}
+	bsp := NewBatchSpanProcessor(exporter, WithMaxExportBatchSize(3), WithBatchTimeout(time.Hour))
+	tracer := NewTracerProvider(WithSpanProcessor(bsp)).Tracer("test")
+	for i := 0; i < 7; i++ {
+		_, s := tracer.Start(context.Background(), "span")
+		s.End()
+	}
+	if err := bsp.ForceFlush(context.Background()); err != nil {
+		t.Fatal("failed to flush:", err)
+	}
+	exporter.mu.Lock()
+	var sizes []int
+	for _, b := range exporter.batches {
+		sizes = append(sizes, len(b))
+	}
+	exporter.mu.Unlock()
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("got batches of %v spans, want [3 3 1]", sizes)
+	}
+
+	_, s := tracer.Start(context.Background(), "last")
+	s.End()
+	if err := bsp.Shutdown(context.Background()); err != nil {
+		t.Fatal("failed to shut down:", err)
+	}
+	if got := len(exporter.spans()); got != 8 || !exporter.shutdown {
+		t.Errorf("got %d spans exported, shutdown %v, want 8 and a shut down exporter", got, exporter.shutdown)
+	}
+	if err := bsp.ForceFlush(context.Background()); err != ErrShutdown {
+		t.Errorf("got %v flushing after shutdown, want ErrShutdown", err)
+	}
+	_, s = tracer.Start(context.Background(), "ignored")
+	s.End()
+}
+
+func TestBatchTimeout(t *testing.T) {
+	exporter := &memoryExporter{}
+	bsp := NewBatchSpanProcessor(exporter, WithBatchTimeout(10*time.Millisecond))
+	defer bsp.Shutdown(context.Background())
+	_, s := NewTracerProvider(WithSpanProcessor(bsp)).Tracer("test").Start(context.Background(), "span")
+	s.End()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.spans()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the span was not exported after the batch timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchQueueFull(t *testing.T) {
+	exporter := &memoryExporter{err: errors.New("unavailable")}
+	bsp := NewBatchSpanProcessor(exporter, WithMaxQueueSize(2), WithBatchTimeout(time.Hour))
+	// The goroutine may take spans out of the queue before the others are
+	// ended, the rest is dropped. What is taken fails to export.
+	for i := 0; i < 10; i++ {
+		bsp.OnEnd(&SpanData{})
+	}
+	if err := bsp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bsp.dropped != 10 {
+		t.Errorf("got %d spans dropped, want 10", bsp.dropped)
+	}
+}
+
+// traceService is a collector receiving spans.
+type traceService struct {
+	coltracepb.UnimplementedTraceServiceServer
+	received chan *coltracepb.ExportTraceServiceRequest
+}
+
+func (s *traceService) Export(_ context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	s.received <- req
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestOTLPExporter(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	collector := &traceService{received: make(chan *coltracepb.ExportTraceServiceRequest, 1)}
+	coltracepb.RegisterTraceServiceServer(srv, collector)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	res := resource.NewWithAttributes(label.String("service.name", "test"))
+	tp := NewTracerProvider(WithResource(res), WithSpanProcessor(NewBatchSpanProcessor(NewOTLPExporter(conn))))
+	ctx, parent := tp.Tracer("a").Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tp.Tracer("b").Start(ctx, "child", trace.WithAttributes(label.Int("n", 1)))
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	parent.SetStatus(codes.Ok, "ignored")
+	parent.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal("failed to shut down:", err)
+	}
+
+	req := <-collector.received
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("got %d resources, want 1", len(req.ResourceSpans))
+	}
+	rs := req.ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0].Value.GetStringValue() != "test" {
+		t.Errorf("got resource %v", rs.Resource)
+	}
+	spans := map[string]*tracepb.Span{}
+	for _, ils := range rs.InstrumentationLibrarySpans {
+		if len(ils.Spans) != 1 {
+			t.Fatalf("got %d spans for library %s, want 1", len(ils.Spans), ils.InstrumentationLibrary.Name)
+		}
+		spans[ils.InstrumentationLibrary.Name] = ils.Spans[0]
+	}
+	p, c := spans["a"], spans["b"]
+	if p == nil || c == nil {
+		t.Fatalf("got spans %v, want one per library", spans)
+	}
+	psc := parent.SpanContext()
+	if string(p.TraceId) != string(psc.TraceID[:]) || string(p.SpanId) != string(psc.SpanID[:]) || p.ParentSpanId != nil {
+		t.Errorf("got parent ids %x %x %x", p.TraceId, p.SpanId, p.ParentSpanId)
+	}
+	if string(c.ParentSpanId) != string(psc.SpanID[:]) {
+		t.Errorf("got child parent %x, want %x", c.ParentSpanId, psc.SpanID[:])
+	}
+	if p.Kind != tracepb.Span_SPAN_KIND_SERVER || p.Status.Code != tracepb.Status_STATUS_CODE_OK || p.Status.Message != "" {
+		t.Errorf("got parent kind %v status %v", p.Kind, p.Status)
+	}
+	if c.Kind != tracepb.Span_SPAN_KIND_INTERNAL || c.Status.Code != tracepb.Status_STATUS_CODE_ERROR || c.Status.Message != "failed" {
+		t.Errorf("got child kind %v status %v", c.Kind, c.Status)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0].Key != "n" || c.Attributes[0].Value.GetIntValue() != 1 {
+		t.Errorf("got child attributes %v", c.Attributes)
+	}
+	if c.StartTimeUnixNano == 0 || c.EndTimeUnixNano < c.StartTimeUnixNano {
+		t.Errorf("got child times %d-%d", c.StartTimeUnixNano, c.EndTimeUnixNano)
+	}
+}
@@ -0,0 +1,23 @@
+package views
+
+import (
+	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// NewPrometheusExportPipeline is prometheus.NewExportPipeline with views
+// applied. Instruments without a view use config.DefaultHistogramBoundaries.
+func NewPrometheusExportPipeline(config prometheus.Config, views []View, options ...controller.Option) (*prometheus.Exporter, error) {
+	selector, err := New(simple.NewWithHistogramDistribution(config.DefaultHistogramBoundaries), views...)
+	if err != nil {
+		return nil, err
+	}
+	cont := controller.New(
+		processor.New(selector, export.CumulativeExportKindSelector(), processor.WithMemory(true)),
+		options...,
+	)
+	return prometheus.NewExporter(config, cont)
+}
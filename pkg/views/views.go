@@ -0,0 +1,124 @@
+// Package views sets histogram boundaries per instrument, standing in for
+// the Views API the SDK does not have yet
+// (https://github.com/open-telemetry/opentelemetry-go/issues/689).
+//
+// A Selector wraps the export.AggregatorSelector of a pipeline, Prometheus
+// or OTLP alike. ValueRecorders and ValueObservers matching a view are
+// aggregated into a histogram with the view's boundaries, every other
+// instrument gets whatever the wrapped selector chooses.
+package views
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+)
+
+// View sets the histogram boundaries of the instruments it matches.
+type View struct {
+	// Name is an instrument name or a path.Match pattern, eg.
+	// "reconcile_latency" or "http.server.*".
+	Name       string
+	Boundaries []float64
+}
+
+// Selector is an export.AggregatorSelector applying views on top of
+// another selector.
+type Selector struct {
+	next  export.AggregatorSelector
+	exact map[string][]float64
+	globs []View
+}
+
+var _ export.AggregatorSelector = (*Selector)(nil)
+
+// New returns a selector applying views to the instruments of next. A view
+// naming an instrument exactly takes precedence over patterns, patterns
+// are tried in order.
+func New(next export.AggregatorSelector, views ...View) (*Selector, error) {
+	s := &Selector{next: next, exact: map[string][]float64{}}
+	for _, v := range views {
+		if len(v.Boundaries) == 0 {
+			return nil, fmt.Errorf("views: %s has no boundaries", v.Name)
+		}
+		boundaries := append([]float64(nil), v.Boundaries...)
+		sort.Float64s(boundaries)
+		if !isPattern(v.Name) {
+			if _, ok := s.exact[v.Name]; !ok {
+				s.exact[v.Name] = boundaries
+			}
+			continue
+		}
+		if _, err := path.Match(v.Name, ""); err != nil {
+			return nil, fmt.Errorf("views: invalid pattern %q: %w", v.Name, err)
+		}
+		s.globs = append(s.globs, View{Name: v.Name, Boundaries: boundaries})
+	}
+	return s, nil
+}
+
+// Boundaries returns the boundaries of the view matching an instrument
+// name, if any.
+func (s *Selector) Boundaries(name string) ([]float64, bool) {
+	if b, ok := s.exact[name]; ok {
+		return b, true
+	}
+	for _, v := range s.globs {
+		// Patterns are validated by New.
+		if ok, _ := path.Match(v.Name, name); ok {
+			return v.Boundaries, true
+		}
+	}
+	return nil, false
+}
+
+// AggregatorFor implements export.AggregatorSelector.
+func (s *Selector) AggregatorFor(desc *metric.Descriptor, aggPtrs ...*export.Aggregator) {
+	if desc.InstrumentKind().Grouping() {
+		if boundaries, ok := s.Boundaries(desc.Name()); ok {
+			aggs := histogram.New(len(aggPtrs), desc, boundaries)
+			for i := range aggPtrs {
+				*aggPtrs[i] = &aggs[i]
+			}
+			return
+		}
+	}
+	s.next.AggregatorFor(desc, aggPtrs...)
+}
+
+// Parse reads views written as name=b1,b2,...;name=..., the format used to
+// pass views through an environment variable, eg.
+// "reconcile_latency=10,100,1000;http.*=0.1,1,10".
+func Parse(s string) ([]View, error) {
+	var views []View
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("views: %q is not name=boundaries", part)
+		}
+		v := View{Name: strings.TrimSpace(part[:eq])}
+		for _, b := range strings.Split(part[eq+1:], ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+			if err != nil {
+				return nil, fmt.Errorf("views: %s: %w", v.Name, err)
+			}
+			v.Boundaries = append(v.Boundaries, f)
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
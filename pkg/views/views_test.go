@@ -0,0 +1,146 @@
+package views
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skonto/test-otel/pkg/internal/transform"
+	"go.opentelemetry.io/otel/exporters/metric/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+var testViews = []View{
+	{Name: "reconcile_*", Boundaries: []float64{100, 10, 1000}},
+	{Name: "reconcile_latency", Boundaries: []float64{1, 5}},
+	{Name: "http.*", Boundaries: []float64{0.1}},
+}
+
+func record(meter metric.Meter) {
+	ctx := context.Background()
+	m := metric.Must(meter)
+	m.NewFloat64ValueRecorder("reconcile_latency").Record(ctx, 2)
+	m.NewFloat64ValueRecorder("reconcile_duration").Record(ctx, 50)
+	m.NewFloat64ValueRecorder("db_latency").Record(ctx, 2)
+	m.NewInt64Counter("http.requests").Add(ctx, 1)
+}
+
+func TestBoundaries(t *testing.T) {
+	s, err := New(simple.NewWithInexpensiveDistribution(), testViews...)
+	if err != nil {
+		t.Fatal("invalid views:", err)
+	}
+	tests := []struct {
+		name string
+		want []float64
+	}{
+		{name: "reconcile_latency", want: []float64{1, 5}},
+		{name: "reconcile_duration", want: []float64{10, 100, 1000}},
+		{name: "http.latency", want: []float64{0.1}},
+		{name: "db_latency"},
+	}
+	for _, tc := range tests {
+		got, _ := s.Boundaries(tc.name)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got boundaries %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	exp, err := NewPrometheusExportPipeline(prometheus.Config{DefaultHistogramBoundaries: []float64{3}}, testViews,
+		controller.WithCollectPeriod(0))
+	if err != nil {
+		t.Fatal("failed to create pipeline:", err)
+	}
+	record(exp.MeterProvider().Meter("test"))
+
+	rec := httptest.NewRecorder()
+	exp.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`reconcile_latency_bucket{le="1"} 0`,
+		`reconcile_latency_bucket{le="5"} 1`,
+		`reconcile_duration_bucket{le="100"} 1`,
+		// No view, the default boundaries apply.
+		`db_latency_bucket{le="3"} 1`,
+		// Views do not apply to counters.
+		"http_requests 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	s, err := New(simple.NewWithInexpensiveDistribution(), testViews...)
+	if err != nil {
+		t.Fatal("invalid views:", err)
+	}
+	proc := processor.New(s, export.CumulativeExportKindSelector())
+	cont := controller.New(proc, controller.WithCollectPeriod(0))
+	record(cont.MeterProvider().Meter("test"))
+	if err := cont.Collect(context.Background()); err != nil {
+		t.Fatal("failed to collect:", err)
+	}
+	rms, err := transform.CheckpointSet(context.Background(), export.CumulativeExportKindSelector(), proc.CheckpointSet())
+	if err != nil {
+		t.Fatal("failed to transform:", err)
+	}
+
+	bounds := map[string][]float64{}
+	for _, m := range rms[0].GetInstrumentationLibraryMetrics()[0].GetMetrics() {
+		if h := m.GetDoubleHistogram(); h != nil {
+			bounds[m.GetName()] = h.GetDataPoints()[0].GetExplicitBounds()
+		}
+	}
+	want := map[string][]float64{
+		"reconcile_latency":  {1, 5},
+		"reconcile_duration": {10, 100, 1000},
+	}
+	// db_latency keeps the minmaxsumcount of the wrapped selector, which is
+	// sent as a two bucket histogram with the fixed bounds [0 100].
+	if got := bounds["db_latency"]; !reflect.DeepEqual(got, []float64{0, 100}) {
+		t.Errorf("got db_latency bounds %v, want [0 100]", got)
+	}
+	delete(bounds, "db_latency")
+	if !reflect.DeepEqual(bounds, want) {
+		t.Errorf("got histograms %v, want %v", bounds, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	got, err := Parse("reconcile_latency=10, 100,1000; http.*=0.5;")
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	want := []View{
+		{Name: "reconcile_latency", Boundaries: []float64{10, 100, 1000}},
+		{Name: "http.*", Boundaries: []float64{0.5}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, invalid := range []string{"reconcile_latency", "=1", "x=one"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(simple.NewWithInexpensiveDistribution(), View{Name: "x"}); err == nil {
+		t.Error("expected an error for a view without boundaries")
+	}
+	if _, err := New(simple.NewWithInexpensiveDistribution(), View{Name: "[", Boundaries: []float64{1}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}